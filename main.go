@@ -2,17 +2,30 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/user"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	semver "github.com/Masterminds/semver/v3"
 	"github.com/google/go-github/v57/github"
@@ -28,50 +41,87 @@ var (
 type ActionInfo struct {
 	Owner   string
 	Repo    string
+	Host    string // GitHub Enterprise Server host for a full-URL uses:, empty for github.com
 	Version string
 	SHA     string
 	Error   error
+	// Archived is set when --warn-archived found the action's repository archived.
+	Archived bool
+	// Fallback explains, step by step, why the policy's own choice was passed over when the
+	// version came from a later step of the fallback chain (e.g. same-major → latest release).
+	Fallback string
+	// ResolvedVia names the resolution step the SHA came from (one of the via* constants).
+	ResolvedVia string
+	// CacheKey is the cacheKey the occurrence was resolved under; Cached is set when an
+	// earlier identical resolution answered it.
+	CacheKey string
+	Cached   bool
 }
 
+// The steps of resolveActionForPolicy a resolution can come from, reported as resolvedVia.
+const (
+	viaLatestRelease  = "latest-release"
+	viaHighestSemver  = "highest-semver"
+	viaSameMajor      = "same-major"
+	viaRequested      = "requested"
+	viaBranch         = "branch"
+	viaNewestFallback = "newest-fallback"
+)
+
 // ActionOccurrence represents a single occurrence of a `uses: owner/repo@ref` entry
 // in the workflow content. It tracks the exact byte offsets for safe in-place replacement
 // and also provides human-friendly line/column for output.
 type ActionOccurrence struct {
 	Owner        string
 	Repo         string
-	Action       string // owner/repo
+	Action       string // owner/repo, or https://host/owner/repo as written for another host
+	Host         string // host of a full-URL uses: other than github.com, else empty
 	RequestedRef string
+	Comment      string // trailing comment text without the leading '#', if any
+	Quote        string // closing quote right after the ref when the value is quoted, else empty
 
 	// Byte offsets in the original file content
 	MatchStart   int // start of the entire `uses: ...` match
 	MatchEnd     int // end of the entire match
 	ReplaceStart int // start of the replacement span (the '@' character before the ref)
 	ReplaceEnd   int // end of the replacement span (end of match)
+	RefEnd       int // end of the ref exactly as written, including any stripped artifacts
 
 	// 1-based positions for display
 	Line   int
 	Column int
 }
 
-type GitHubHosts struct {
-	GitHubCom struct {
-		OAuthToken string `yaml:"oauth_token"`
-	} `yaml:"github.com"`
+// valueEnd is the end of the uses: value: the ref plus its closing quote, if any. Anything
+// from here to ReplaceEnd is the trailing comment and whitespace.
+func (occ ActionOccurrence) valueEnd() int {
+	return occ.RefEnd + len(occ.Quote)
+}
+
+// GitHubHosts is gh's hosts.yml: the credentials of each host it is logged in to.
+type GitHubHosts map[string]struct {
+	OAuthToken string `yaml:"oauth_token"`
 }
 
 // UpdatePolicy defines how versions should be selected relative to the requested reference.
 // - UpdatePolicyMajor: bump to the latest available version across all majors (default)
 // - UpdatePolicySameMajor: stay within the requested major, pick the latest tag for that major
 // - UpdatePolicyRequested: pin exactly the requested ref (useful for moving majors like v4)
+// - UpdatePolicyHead: pin the tip of the repository's default branch
 type UpdatePolicy int
 
 const (
 	UpdatePolicyMajor UpdatePolicy = iota
 	UpdatePolicySameMajor
 	UpdatePolicyRequested
+	UpdatePolicyHead
 )
 
-type Config struct{}
+// Config is the --config file. Its settings replace the built-in defaults and are in turn
+// overridden by flags given on the command line; pointer fields tell unset from false.
+type Config struct {
+	ExpandMajor *bool `yaml:"expand_major"`
+}
 
 func parsePolicy(policyStr string) (UpdatePolicy, error) {
 	switch strings.ToLower(strings.TrimSpace(policyStr)) {
@@ -82,6 +132,8 @@ func parsePolicy(policyStr string) (UpdatePolicy, error) {
 		return UpdatePolicySameMajor, nil
 	case "requested", "exact", "pin-requested":
 		return UpdatePolicyRequested, nil
+	case "head", "default-branch":
+		return UpdatePolicyHead, nil
 	default:
 		return UpdatePolicyMajor, fmt.Errorf("unknown policy: %s", policyStr)
 	}
@@ -117,6 +169,20 @@ func prettyRef(ref string) string {
 	return ref
 }
 
+// isShortSHA reports whether s looks like an abbreviated commit SHA (7 to 39 hex characters).
+func isShortSHA(s string) bool {
+	if len(s) < 7 || len(s) >= 40 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')) {
+			return false
+		}
+	}
+	return true
+}
+
 func isFullSHA(s string) bool {
 	if len(s) != 40 {
 		return false
@@ -130,9 +196,36 @@ func isFullSHA(s string) bool {
 	return true
 }
 
+// isPlannedChange reports whether occ resolved to a commit other than its current ref, i.e.
+// whether pinning writes a new SHA for it. With --mode tag-first the pin is a tag as the ref
+// and the SHA in the comment, so either differing is a change.
+func isPlannedChange(occ ActionOccurrence, info ActionInfo, style CommentStyle) bool {
+	if info.Error != nil || strings.TrimSpace(info.SHA) == "" {
+		return false
+	}
+	if style.tagFirst(info) {
+		ref, _ := style.pin(occ, info)
+		return occ.RequestedRef != ref || style.parse(occ.Comment) != info.SHA
+	}
+	return occ.RequestedRef != info.SHA
+}
+
+// changedOnly keeps the occurrences, and their infos, that get a new SHA (--report-only-changed).
+func changedOnly(occurrences []ActionOccurrence, actionInfos []ActionInfo, style CommentStyle) ([]ActionOccurrence, []ActionInfo) {
+	var occs []ActionOccurrence
+	var infos []ActionInfo
+	for i, occ := range occurrences {
+		if i < len(actionInfos) && isPlannedChange(occ, actionInfos[i], style) {
+			occs = append(occs, occ)
+			infos = append(infos, actionInfos[i])
+		}
+	}
+	return occs, infos
+}
+
 // printPlannedChanges prints a concise from → to mapping for each occurrence that will change.
-func printPlannedChanges(occurrences []ActionOccurrence, actionInfos []ActionInfo) {
-	fmt.Println(bold("Planned updates:\n"))
+func printPlannedChanges(w io.Writer, occurrences []ActionOccurrence, actionInfos []ActionInfo, style CommentStyle) {
+	fmt.Fprintln(w, bold("Planned updates:\n"))
 	hadChange := false
 
 	for i, occ := range occurrences {
@@ -140,25 +233,69 @@ func printPlannedChanges(occurrences []ActionOccurrence, actionInfos []ActionInf
 			continue
 		}
 		info := actionInfos[i]
-		if info.Error != nil {
+		if !isPlannedChange(occ, info, style) {
 			continue
 		}
 		oldRef := occ.RequestedRef
-		newRef := info.SHA
-		if oldRef == newRef || strings.TrimSpace(newRef) == "" {
-			continue
-		}
+		newRef, comment := style.pin(occ, info)
 		action := fmt.Sprintf("%s/%s", occ.Owner, occ.Repo)
-		// Example: "  - actions/checkout (L12:C9): v4 → 5e2f1c1…  (v4.2.2)"
-		fmt.Printf("  - %s (L%d:C%d): %s → %s  (%s)\n", action, occ.Line, occ.Column, prettyRef(oldRef), prettyRef(newRef), info.Version)
+		// Example: "  - actions/checkout (L12:C9): v4 → 5e2f1c1…  (v4.2.2)", or with --mode
+		// tag-first "v4 → v4  (5e2f1c1…)"
+		fmt.Fprintf(w, "  - %s (L%d:C%d): %s → %s  (%s)\n", action, occ.Line, occ.Column, prettyRef(oldRef), prettyRef(newRef), prettyRef(comment))
 		hadChange = true
 	}
 	if !hadChange {
-		fmt.Println("  No changes needed. All actions already pinned to the latest commits.")
+		fmt.Fprintln(w, "  No changes needed. All actions already pinned to the latest commits.")
+	}
+}
+
+// printGroupedPlannedChanges is printPlannedChanges with identical changes (same action, same
+// from → to) collapsed into one line listing every affected position, in order of first appearance.
+func printGroupedPlannedChanges(w io.Writer, occurrences []ActionOccurrence, actionInfos []ActionInfo, style CommentStyle) {
+	fmt.Fprintln(w, bold("Planned updates:\n"))
+
+	type group struct {
+		line      string
+		positions []string
+	}
+	var groups []*group
+	byKey := make(map[string]*group)
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) {
+			continue
+		}
+		info := actionInfos[i]
+		if !isPlannedChange(occ, info, style) {
+			continue
+		}
+		oldRef := occ.RequestedRef
+		newRef, comment := style.pin(occ, info)
+		action := fmt.Sprintf("%s/%s", occ.Owner, occ.Repo)
+		// Example: "  - actions/checkout: v4 → 5e2f1c1…  (v4.2.2) at L12:C9, L30:C9"
+		line := fmt.Sprintf("  - %s: %s → %s  (%s)", action, prettyRef(oldRef), prettyRef(newRef), prettyRef(comment))
+		key := strings.Join([]string{action, oldRef, newRef, comment}, "\x00")
+		g, ok := byKey[key]
+		if !ok {
+			g = &group{line: line}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.positions = append(g.positions, fmt.Sprintf("L%d:C%d", occ.Line, occ.Column))
+	}
+	if len(groups) == 0 {
+		fmt.Fprintln(w, "  No changes needed. All actions already pinned to the latest commits.")
+		return
+	}
+	for _, g := range groups {
+		fmt.Fprintf(w, "%s at %s\n", g.line, strings.Join(g.positions, ", "))
 	}
 }
 
-func getGitHubToken() (string, error) {
+func getGitHubToken(stdinToken string) (string, error) {
+	if stdinToken != "" {
+		return stdinToken, nil
+	}
+
 	if token := os.Getenv("GH_TOKEN"); token != "" {
 		return token, nil
 	}
@@ -180,7 +317,61 @@ func getGitHubToken() (string, error) {
 	return "", fmt.Errorf("no GitHub token found. Set GH_TOKEN or GITHUB_TOKEN environment variable, or use 'gh auth login'")
 }
 
+// readTokenFromStdin reads the GitHub token from the first line of in, like
+// `docker login --password-stdin`.
+func readTokenFromStdin(in *bufio.Reader) (string, error) {
+	line, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("read token from stdin: %w", err)
+	}
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return "", fmt.Errorf("--token-stdin: no token on stdin")
+	}
+	return token, nil
+}
+
+// getRegistryToken returns the credential for container registries, which is independent of
+// the GitHub API token: the --registry-token flag wins over PIN_REGISTRY_TOKEN. An empty result
+// means registry requests are made anonymously.
+func getRegistryToken(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv("PIN_REGISTRY_TOKEN")
+}
+
+// getHostToken returns the token for a GitHub Enterprise Server host, looked up the way gh
+// does: GH_ENTERPRISE_TOKEN, GITHUB_ENTERPRISE_TOKEN, then gh's credentials for that host.
+// GH_TOKEN and GITHUB_TOKEN are github.com credentials and never sent to another host.
+func getHostToken(host string) (string, error) {
+	if token := os.Getenv("GH_ENTERPRISE_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	if token := os.Getenv("GITHUB_ENTERPRISE_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	token, err := keyring.Get("gh:"+host, "")
+	if err == nil {
+		return token, nil
+	}
+
+	token, err = tokenFromHostsFile(host)
+	if err == nil {
+		return token, nil
+	}
+
+	return "", fmt.Errorf("no token found for %s. Set GH_ENTERPRISE_TOKEN or GITHUB_ENTERPRISE_TOKEN, or use 'gh auth login --hostname %s'", host, host)
+}
+
 func getGitHubTokenFromHostsFile() (string, error) {
+	return tokenFromHostsFile("github.com")
+}
+
+// tokenFromHostsFile returns gh's stored oauth_token for host.
+func tokenFromHostsFile(host string) (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
@@ -198,615 +389,5811 @@ func getGitHubTokenFromHostsFile() (string, error) {
 		return "", err
 	}
 
-	if hosts.GitHubCom.OAuthToken != "" {
-		return hosts.GitHubCom.OAuthToken, nil
+	if token := hosts[host].OAuthToken; token != "" {
+		return token, nil
 	}
 
-	return "", fmt.Errorf("no oauth_token found in hosts file")
+	return "", fmt.Errorf("no oauth_token found for %s in hosts file", host)
 }
 
 func main() {
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [--expand-major] [--policy <policy>] [--yes|--write] [--dry-run] <workflow-file>\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Example: %s --policy same-major --yes .github/workflows/update_cli_docs.yml\n", os.Args[0])
-	}
-	// Toggle: when a moving major tag (e.g., v4 or 4) is detected, expand the displayed version
-	// comment to the full semver tag (e.g., v4.2.2) that the major tag currently points to.
-	expandMajorFlag := flag.Bool("expand-major", false, "Expand moving major tags (vN or N) to full semver in the version comment")
-	policyFlag := flag.String("policy", "major", "Update policy: major (default), same-major, requested")
-	yesFlag := flag.Bool("yes", false, "Apply changes without confirmation prompt")
-	writeFlag := flag.Bool("write", false, "Apply changes without confirmation prompt (alias of --yes)")
-	dryRunFlag := flag.Bool("dry-run", false, "Preview planned updates and exit without writing")
-	flag.Parse()
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
 
-	nonInteractiveApply := *yesFlag || *writeFlag
+// newGitHubClient builds the API client used by run. Tests replace it to target a fake server.
+var newGitHubClient = func(ctx context.Context, token string, headers http.Header) *github.Client {
+	return github.NewClient(newHTTPClient(headers)).WithAuthToken(token)
+}
 
-	if *dryRunFlag && nonInteractiveApply {
-		fmt.Fprintf(os.Stderr, "Error: --dry-run cannot be used with --yes/--write\n")
-		os.Exit(1)
-	}
+// newHostClient builds the API client for a GitHub Enterprise Server host, whose REST API is
+// served under /api/v3. Tests replace it like newGitHubClient.
+var newHostClient = func(ctx context.Context, host, token string, headers http.Header) (*github.Client, error) {
+	base := "https://" + host + "/api/v3/"
+	return github.NewClient(newHTTPClient(headers)).WithAuthToken(token).WithEnterpriseURLs(base, "https://"+host+"/api/uploads/")
+}
 
-	if flag.NArg() != 1 {
-		flag.Usage()
-		os.Exit(1)
+// newHTTPClient returns the HTTP client for API requests. Extra headers are injected into every
+// request on top of the default transport, so proxy settings from the environment still apply.
+func newHTTPClient(headers http.Header) *http.Client {
+	if len(headers) == 0 {
+		return &http.Client{}
 	}
+	return &http.Client{Transport: &headerTransport{base: http.DefaultTransport, headers: headers}}
+}
 
-	workflowFile := flag.Arg(0)
+// headerTransport is an http.RoundTripper that adds a fixed set of headers to each request.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
 
-	if _, err := os.Stat(workflowFile); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Error: File '%s' not found\n", workflowFile)
-		os.Exit(1)
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, values := range t.headers {
+		req.Header.Del(key)
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
 	}
+	return t.base.RoundTrip(req)
+}
+
+// Backoff of --resolve-retries: retry n (from 0) waits retryBaseDelay·2ⁿ, at most retryMaxDelay.
+var (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
 
-	fmt.Printf("\n%s %s\n\n", bold("Scanning workflow"), workflowFile)
+// retryTransport is an http.RoundTripper retrying GET and HEAD requests that failed with a
+// transient error (see isTransient) up to retries times, waiting backoff before each retry.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	jitter  bool
+	random  func() float64 // uniform in [0, 1)
+}
 
-	content, err := os.ReadFile(workflowFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
-		os.Exit(1)
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.base.RoundTrip(req)
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= t.retries || !isTransient(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(t.backoff(attempt))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
 	}
+}
 
-	actions := extractActions(string(content))
-	occurrences := extractOccurrences(string(content))
-	if len(actions) == 0 {
-		fmt.Printf("%s No GitHub Actions references found in %s\n", bold("No actions:"), workflowFile)
-		os.Exit(1)
+// backoff returns the wait before retry attempt (from 0). With jitter it is drawn uniformly
+// from [0, delay) ("full jitter"), so requests that failed together do not retry together.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 32 && retryBaseDelay<<attempt < retryMaxDelay {
+		delay = retryBaseDelay << attempt
+	}
+	if !t.jitter {
+		return delay
 	}
+	return time.Duration(t.random() * float64(delay))
+}
 
-	fmt.Println(bold("Discovered actions:\n"))
-	for _, action := range actions {
-		fmt.Printf("  - %s\n", action)
+// isTransient reports whether a request is worth retrying: it failed to get a response, was
+// rate limited (429, or a 403 secondary rate limit carrying Retry-After), or hit a 502, 503 or
+// 504 from an overloaded server.
+func isTransient(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusForbidden:
+		return resp.Header.Get("Retry-After") != ""
 	}
-	fmt.Println()
+	return false
+}
 
-	// Determine effective update policy (default to latest major) from flag only
-	effectivePolicy := UpdatePolicyMajor
-	if p, err := parsePolicy(*policyFlag); err == nil {
-		effectivePolicy = p
+// withRetries returns a copy of client whose requests go through a retryTransport, or client
+// itself when retries is 0.
+func withRetries(client *github.Client, retries int, jitter bool) *github.Client {
+	if retries <= 0 || client == nil {
+		return client
+	}
+	hc := *client.Client()
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
 	}
+	hc.Transport = &retryTransport{base: base, retries: retries, jitter: jitter, random: rand.Float64}
+	retrying := github.NewClient(&hc)
+	retrying.BaseURL, retrying.UploadURL, retrying.UserAgent = client.BaseURL, client.UploadURL, client.UserAgent
+	return retrying
+}
 
-	fmt.Println(bold("Resolving latest versions and SHAs (parallel)...\n"))
+// headerFlag collects repeatable --header key=value flags.
+type headerFlag http.Header
 
-	token, err := getGitHubToken()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+func (h headerFlag) String() string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
 
-	ctx := context.Background()
-	client := github.NewTokenClient(ctx, token)
-
-	actionInfos := getActionInfosForOccurrences(ctx, client, occurrences, *expandMajorFlag, effectivePolicy)
+// repoOverrideFlag collects repeatable --repo-override owner/repo=otherowner/otherrepo
+// mappings, keyed by the lowercased source slug.
+type repoOverrideFlag map[string]string
 
-	if len(actionInfos) == 0 {
-		fmt.Println(bold("No action information retrieved."))
-		os.Exit(1)
+func (o repoOverrideFlag) String() string {
+	keys := make([]string, 0, len(o))
+	for key := range o {
+		keys = append(keys, key+"="+o[key])
 	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
 
-	fmt.Println()
-	fmt.Printf("%s %s\n", bold("Updating file"), workflowFile)
+func (o repoOverrideFlag) Set(value string) error {
+	from, to, ok := strings.Cut(value, "=")
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if !ok || !isRepoSlug(from) || !isRepoSlug(to) {
+		return fmt.Errorf("invalid repo override %q, want owner/repo=otherowner/otherrepo", value)
+	}
+	o[strings.ToLower(from)] = to
+	return nil
+}
 
-	updatedContent := updateContent(string(content), occurrences, actionInfos)
+// hostWorkersFlag collects repeatable --resolve-workers-per-host host=N limits, keyed by the
+// lowercased host.
+type hostWorkersFlag map[string]int
 
-	// Always show planned updates for a clear from → to view
-	fmt.Println()
-	printPlannedChanges(occurrences, actionInfos)
+func (h hostWorkersFlag) String() string {
+	keys := make([]string, 0, len(h))
+	for host, n := range h {
+		keys = append(keys, fmt.Sprintf("%s=%d", host, n))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
 
-	// Dry-run: exit after preview without prompting or writing. Exit code 2 if changes would be made.
-	if *dryRunFlag {
-		if string(content) == updatedContent {
-			return
-		}
-		os.Exit(2)
+func (h hostWorkersFlag) Set(value string) error {
+	host, count, ok := strings.Cut(value, "=")
+	host = strings.ToLower(strings.TrimSpace(host))
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if !ok || host == "" || strings.Contains(host, "/") || err != nil || n < 1 {
+		return fmt.Errorf("invalid host workers %q, want host=N with N at least 1", value)
 	}
+	h[host] = n
+	return nil
+}
 
-	if string(content) == updatedContent {
-		fmt.Println()
-		fmt.Println(bold("\nUp to date:"), "All actions are already pinned to the latest versions.")
-		return
+// ignoreFlag collects repeatable --ignore owner/repo globs.
+type ignoreFlag []string
+
+func (f *ignoreFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *ignoreFlag) Set(value string) error {
+	pattern := strings.TrimSpace(value)
+	if err := checkIgnorePattern(pattern); err != nil {
+		return err
 	}
+	*f = append(*f, pattern)
+	return nil
+}
 
-	fmt.Println()
-	// If --yes is set, skip the prompt and apply immediately
-	if !nonInteractiveApply {
-		if !promptConfirmation(bold("Apply changes?") + " [y/N] ") {
-			fmt.Println(bold("\nNo changes applied."))
-			return
-		}
+// checkIgnorePattern rejects globs that path.Match cannot use.
+func checkIgnorePattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+		return fmt.Errorf("invalid ignore pattern %q, want an owner/repo glob", pattern)
 	}
+	return nil
+}
 
-	err = os.WriteFile(workflowFile, []byte(updatedContent), 0644)
+// readIgnoreFile parses an --ignore-file: one owner/repo glob per line. Blank lines and
+// comments starting with # are ignored.
+func readIgnoreFile(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
-		os.Exit(1)
+		return nil, err
 	}
-
-	fmt.Printf("%s %s\n", bold("\nUpdated file"), workflowFile)
-	fmt.Println()
-	fmt.Println(bold("Pinned actions:\n"))
-	for _, info := range actionInfos {
-		if info.Error == nil {
-			fmt.Printf("  %s/%s@%s # %s\n", info.Owner, info.Repo, info.SHA, info.Version)
+	var patterns []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := checkIgnorePattern(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, i+1, err)
 		}
+		patterns = append(patterns, line)
 	}
+	return patterns, nil
 }
 
-func extractActions(content string) []string {
-	// Preserve order of first appearance while de-duplicating
-	re := regexp.MustCompile(`uses:\s+([^@/]+/[^@\s]+)`)
-	matches := re.FindAllStringSubmatch(content, -1)
+// allowEntry is one line of an --owner-allowlist-file: an owner/repo glob and the version
+// approved for it, "*" for any.
+type allowEntry struct {
+	slug    string
+	version string
+}
 
-	seen := make(map[string]bool)
-	actions := make([]string, 0, len(matches))
-	for _, match := range matches {
-		if len(match) <= 1 {
+// readAllowlistFile parses an --owner-allowlist-file: one owner/repo@version per line. As with
+// --ignore, owner/repo may be a glob (matched by isAllowed); the version is a tag, a commit SHA
+// or "*" for any. Blank lines and comments starting with # are ignored.
+func readAllowlistFile(file string) ([]allowEntry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries []allowEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
 			continue
 		}
-		action := match[1]
-		if seen[action] {
-			continue
+		slug, version, ok := strings.Cut(line, "@")
+		if !ok || version == "" || checkIgnorePattern(slug) != nil {
+			return nil, fmt.Errorf("%s:%d: invalid allowlist entry %q, want owner/repo@version", file, i+1, line)
 		}
-		seen[action] = true
-		actions = append(actions, action)
+		entries = append(entries, allowEntry{slug: slug, version: version})
 	}
-	return actions
+	return entries, nil
 }
 
-// extractOccurrences finds each `uses: owner/repo@ref` occurrence along with positions.
-func extractOccurrences(content string) []ActionOccurrence {
-	re := regexp.MustCompile(`uses:\s+([^@/]+/[^@\s]+)@([^\s#]+)(\s*#[^\n]*)?`)
-	indices := re.FindAllStringSubmatchIndex(content, -1)
-	occurrences := make([]ActionOccurrence, 0, len(indices))
-
-	for _, idxs := range indices {
-		if len(idxs) < 6 {
+// isAllowed reports whether an allowlist entry approves the resolution of slug: its version or
+// its commit SHA. As with --ignore, slugs match regardless of case unless caseSensitive is set.
+func isAllowed(entries []allowEntry, slug string, info ActionInfo, caseSensitive bool) bool {
+	for _, e := range entries {
+		if !matchesAny(slug, []string{e.slug}, caseSensitive) {
 			continue
 		}
-		matchStart, matchEnd := idxs[0], idxs[1]
-		ownerRepoStart, ownerRepoEnd := idxs[2], idxs[3]
-		refStart, refEnd := idxs[4], idxs[5]
-		action := content[ownerRepoStart:ownerRepoEnd]
-		parts := strings.SplitN(action, "/", 2)
-		if len(parts) != 2 {
-			continue
+		if e.version == "*" || e.version == info.Version || strings.EqualFold(e.version, info.SHA) {
+			return true
 		}
-		owner, repo := parts[0], parts[1]
-		requestedRef := content[refStart:refEnd]
-		// '@' should be right after ownerRepoEnd
-		replaceStart := ownerRepoEnd
-		replaceEnd := matchEnd
+	}
+	return false
+}
 
-		line, col := computeLineCol(content, ownerRepoStart)
+// auditEntry is one line of the --audit-log: an occurrence --owner-allowlist-file rejected,
+// with the ref as written and what it resolved to.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	File    string    `json:"file"`
+	Line    int       `json:"line"`
+	Column  int       `json:"column"`
+	Action  string    `json:"action"`
+	Ref     string    `json:"ref"`
+	Version string    `json:"version"`
+	SHA     string    `json:"sha"`
+}
 
-		occurrences = append(occurrences, ActionOccurrence{
-			Owner:        owner,
-			Repo:         repo,
-			Action:       action,
-			RequestedRef: requestedRef,
-			MatchStart:   matchStart,
-			MatchEnd:     matchEnd,
-			ReplaceStart: replaceStart,
-			ReplaceEnd:   replaceEnd,
-			Line:         line,
-			Column:       col,
-		})
-	}
-	return occurrences
+// auditLog counts the occurrences --owner-allowlist-file rejected and appends each to the
+// --audit-log file, if any. Files processed in parallel share it.
+type auditLog struct {
+	mu       sync.Mutex
+	path     string
+	user     string
+	rejected int
 }
 
-// computeLineCol returns 1-based line and column for the given byte offset.
-func computeLineCol(content string, offset int) (int, int) {
-	if offset < 0 {
-		offset = 0
+func (a *auditLog) record(e auditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rejected++
+	if a.path == "" {
+		return nil
 	}
-	if offset > len(content) {
-		offset = len(content)
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
 	}
-	line := 1
-	col := 1
-	lastNL := -1
-	for i := 0; i < offset; i++ {
-		if content[i] == '\n' {
-			line++
-			lastNL = i
-		}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(e); err != nil {
+		f.Close()
+		return err
 	}
-	col = offset - lastNL
-	return line, col
+	return f.Close()
 }
 
-func isMovingMajorTag(ref string) bool {
-	// v4 or 4
-	re := regexp.MustCompile(`^v?\d+$`)
-	return re.MatchString(ref)
-}
-
-func resolveTagToCommitSHA(ctx context.Context, client *github.Client, owner, repo, tagName string) (string, string, error) {
-	// Resolve a tag ref to a commit SHA, dereferencing annotated tags
-	ref, resp, err := client.Git.GetRef(ctx, owner, repo, "tags/"+tagName)
-	if err != nil {
-		if resp != nil && resp.StatusCode == http.StatusNotFound {
-			return "", "", fmt.Errorf("tag not found: %s", tagName)
-		}
-		return "", "", err
+// auditUser names who ran the tool for the audit log: the GitHub Actions actor in CI, else the
+// local user.
+func auditUser() string {
+	if actor := os.Getenv("GITHUB_ACTOR"); actor != "" {
+		return actor
 	}
-	sha := ref.GetObject().GetSHA()
-	if ref.GetObject().GetType() == "tag" {
-		tagObj, _, tagErr := client.Git.GetTag(ctx, owner, repo, sha)
-		if tagErr == nil && tagObj != nil && tagObj.GetObject().GetType() == "commit" && tagObj.GetObject().GetSHA() != "" {
-			sha = tagObj.GetObject().GetSHA()
-		}
+	if u, err := user.Current(); err == nil {
+		return u.Username
 	}
-	if sha == "" {
-		return "", "", fmt.Errorf("no SHA found for tag %s", tagName)
+	return "unknown"
+}
+
+func isRepoSlug(s string) bool {
+	owner, repo, ok := strings.Cut(s, "/")
+	return ok && owner != "" && repo != "" && !strings.ContainsAny(s, "@ \t")
+}
+
+func (h headerFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("invalid header %q, want key=value", value)
 	}
-	return sha, tagName, nil
+	http.Header(h).Add(key, strings.TrimSpace(val))
+	return nil
 }
 
-func selectTagBySemverOrNewest(ctx context.Context, client *github.Client, owner, repo string) (string, string, error) {
-	// List tags and pick highest semver; if none parsable, pick newest (first page ordering)
-	opts := &github.ListOptions{PerPage: 100}
-	tags, _, err := client.Repositories.ListTags(ctx, owner, repo, opts)
-	if err != nil || len(tags) == 0 {
-		if err == nil {
-			err = fmt.Errorf("no tags found")
+// run executes the CLI with the given arguments and streams, returning the process exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) (code int) {
+	started := time.Now()
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s [--expand-major] [--policy <policy>] [--yes|--write] [--dry-run] <workflow-file|dir|glob>...\n", os.Args[0])
+		fmt.Fprintf(stderr, "Example: %s --policy same-major --yes .github/workflows/update_cli_docs.yml\n", os.Args[0])
+	}
+	// Toggle: when a moving major tag (e.g., v4 or 4) is detected, expand the displayed version
+	// comment to the full semver tag (e.g., v4.2.2) that the major tag currently points to.
+	expandMajorFlag := fs.Bool("expand-major", false, "Expand moving major tags (vN or N) to full semver in the version comment")
+	preferReleaseNameFlag := fs.Bool("prefer-release-tag-name", false, "Use the latest release's display name as the version comment (major policy)")
+	policyFlag := fs.String("policy", "major", "Update policy: major (default), same-major, requested, head")
+	yesFlag := fs.Bool("yes", false, "Apply changes without confirmation prompt")
+	confirmEachFileFlag := fs.Bool("confirm-each-file", false, "Ask before writing each file, after showing its planned changes, even with --yes")
+	promptDefaultFlag := fs.String("prompt-default", "no", "Answer to the confirmation prompt when Enter is pressed: yes or no")
+	writeFlag := fs.Bool("write", false, "Apply changes without confirmation prompt (alias of --yes)")
+	fixFlag := fs.Bool("fix", false, "Apply changes without confirmation prompt (alias of --yes)")
+	dryRunFlag := fs.Bool("dry-run", false, "Preview planned updates and exit without writing")
+	checkFlag := fs.Bool("check", false, "Report planned updates without writing, like --dry-run; with --fix, apply them")
+	registryTokenFlag := fs.String("registry-token", "", "Token for container registry lookups (default $PIN_REGISTRY_TOKEN); independent of the GitHub token")
+	commentOnlyFlag := fs.Bool("update-comment-only", false, "Never change pinned SHAs; only add or correct their # version comments")
+	allowDowngradeFlag := fs.Bool("allow-downgrade", false, "Allow writing a pin whose version is lower than the currently pinned version")
+	ownerCaseInsensitiveFlag := fs.Bool("owner-case-insensitive", true, "Match --exclude-owners, --ignore and --owner-allowlist-file regardless of case, as GitHub does (--owner-case-insensitive=false to match exactly)")
+	reportOnlyChangedFlag := fs.Bool("report-only-changed", false, "List only occurrences that get a new SHA in resolution lists and --summary-only/--export-env/--print-shas output")
+	includeHiddenFlag := fs.Bool("include-hidden", false, "Also search hidden directories (other than .github) inside directory arguments")
+	includeCommentedFlag := fs.Bool("include-commented", false, "Also pin uses: references in commented-out lines (# - uses: owner/repo@ref)")
+	caseInsensitiveUsesFlag := fs.Bool("case-insensitive-uses", false, "Also pin references under a miscased key such as Uses: (GitHub rejects these; they are warned about either way)")
+	excludeOwnersFlag := fs.String("exclude-owners", "", "Comma-separated owners whose actions are never pinned (e.g. actions,github)")
+	var ignores ignoreFlag
+	fs.Var(&ignores, "ignore", "Never pin actions whose owner/repo matches this glob (e.g. my-org/*); repeatable")
+	ignoreFileFlag := fs.String("ignore-file", "", "Read --ignore globs from this file, one per line (# comments allowed)")
+	allowlistFileFlag := fs.String("owner-allowlist-file", "", "Only pin resolutions listed in this file as owner/repo@version, one per line; others are left unchanged and fail the run")
+	auditLogFlag := fs.String("audit-log", "", "Append each occurrence rejected by --owner-allowlist-file to this file as a JSON line")
+	maxParallelFilesFlag := fs.Int("max-parallel-files", 1, "Process up to N files at once, keeping output in file order (needs --dry-run, --check or --yes)")
+	concurrencyFlag := fs.String("concurrency", "", "Maximum parallel resolutions, or auto to derive from the remaining rate limit (default unlimited)")
+	statsJSONFlag := fs.String("stats-json", "", "Write API call counts, cache stats and per-action timings as JSON to this file (- for stdout) after the run")
+	cacheStatsFlag := fs.Bool("cache-stats", false, "Print cache hits, misses and sizes after the run")
+	lintCheckoutFlag := fs.Bool("lint-checkout", false, "Warn about actions/checkout steps that leave persist-credentials at its default of true, or set it to true")
+	explainCacheKeyFlag := fs.Bool("explain-cache-key", false, "Print the cache key each action occurrence was resolved under, and whether it was cached")
+	headers := headerFlag{}
+	fs.Var(headers, "header", "Extra HTTP header (key=value) sent with every API request; repeatable")
+	summaryOnlyFlag := fs.Bool("summary-only", false, "Print only the final owner/repo@sha # version pin lines")
+	commentPrefixFlag := fs.String("comment-prefix", "", "Prefix for the version comment, e.g. 'pinned:' writes # pinned: v4.2.2")
+	modeFlag := fs.String("mode", modeSHAFirst, "How pins are written: sha-first (@<sha> # <version>) or tag-first (@<tag> # <sha>, keeping the tag as written)")
+	commentStyleFlag := fs.String("comment-style", commentStyleVersion, "Trailing comment on rewritten lines: version (# <version>), ratchet (# ratchet:owner/repo@<version>), dated (# <version> (<date pinned>)) or none-strip (no comment, removing any existing one)")
+	validateFlag := fs.Bool("validate", false, "Refuse to write a file whose updated content no longer parses as YAML")
+	exportEnvFlag := fs.Bool("export-env", false, "Print only PIN_<owner>_<repo>=<sha> lines for eval or $GITHUB_ENV")
+	printSHAsFlag := fs.Bool("print-shas", false, "Print only tab-separated owner/repo, sha and version lines for each resolved occurrence")
+	commentAlignmentFlag := fs.Bool("comment-alignment", false, "Align the # version comments of SHA-pinned actions in one column per file")
+	postWriteCmdFlag := fs.String("post-write-cmd", "", "Command run after each file is written, with {file} replaced by its path (e.g. 'git add {file}')")
+	postWriteShellFlag := fs.Bool("post-write-shell", false, "Run --post-write-cmd through sh -c instead of splitting it into arguments")
+	fromRefFlag := fs.String("from-ref", "", "Pin the workflow at <git-ref>:<path> in the current repository and print the result, without checking it out")
+	stdinFilenameFlag := fs.String("stdin-filename", "<stdin>", "Name used for the workflow read from - in messages and reports")
+	rangeFormatFlag := fs.Bool("range-format", false, "With - or --from-ref, print the edits as JSON byte ranges instead of the pinned workflow")
+	jsonFlag := fs.Bool("json", false, "With --dry-run, print only the plan as one JSON document per file")
+	jsonPrettyFlag := fs.Bool("json-pretty", false, "Indent --json output (default: indented when stdout is a terminal, compact otherwise)")
+	tokenStdinFlag := fs.Bool("token-stdin", false, "Read the GitHub token from the first line of stdin")
+	noOpExitCodeFlag := fs.Int("no-op-exit-code", 0, "Exit code when the run finishes without changes to make")
+	changesExitCodeFlag := fs.Int("changes-exit-code", 2, "Exit code when --dry-run finds changes to make")
+	errorExitCodeFlag := fs.Int("error-exit-code", 1, "Exit code on errors")
+	actionTimeoutFlag := fs.Duration("resolve-timeout-per-action", 0, "Give up resolving a single action after this long and leave it unchanged (0 means no limit)")
+	retriesFlag := fs.Int("resolve-retries", 0, "Retry API requests failing with a transient error (network error, 429, 502-504 or a secondary rate limit) up to N times, with exponential backoff")
+	retryJitterFlag := fs.Bool("resolve-retries-jitter", true, "Randomize each --resolve-retries backoff (full jitter) so parallel requests do not retry in lockstep")
+	errorFormatFlag := fs.String("error-format", errorFormatPlain, "How resolution and YAML errors are printed: plain, or parseable (file:line:col: message)")
+	inputFormatFlag := fs.String("input-format", inputFormatAuto, "Expect workflows (top-level jobs), actions (top-level runs) or auto-detect: workflow, action or auto")
+	requireAttestationFlag := fs.String("require-attestation", "", "Check each pin for a published build provenance attestation: warn, or fail to leave unattested actions unchanged")
+	noResolveAnnotatedFlag := fs.Bool("no-resolve-annotated", false, "Skip dereferencing annotated tags, saving a request per action; annotated tags are pinned to the tag object SHA (with a warning)")
+	tagsPerPageFlag := fs.Int("tags-per-page", maxTagsPerPage, "Page size (1-100) when listing a repository's tags")
+	warnArchivedFlag := fs.Bool("warn-archived", false, "Warn about actions whose repository is archived (one extra API call per repository)")
+	excludeArchivedFlag := fs.Bool("exclude-archived-from-pin", false, "Refuse to pin actions whose repository is archived, leaving them untouched and reporting them")
+	groupByActionFlag := fs.Bool("group-by-action", false, "Collapse identical planned updates into one line listing every affected position")
+	actionsDirFlag := fs.String("actions-dir", "", "Resolve actions from local mirrors under this directory (<owner>/<repo>.git) instead of the GitHub API")
+	useLsRemoteFlag := fs.Bool("use-git-ls-remote", false, "Resolve actions from the tags git ls-remote lists for each repository instead of the GitHub API (no token or rate limit)")
+	pinFileFlag := fs.String("pin-file", "", "Also resolve the owner/repo@ref lines in this file and print their pins")
+	resolveCacheFileFlag := fs.String("resolve-cache-file", "", "JSON file of resolutions loaded at start and merged back at the end, to share across CI jobs")
+	resolveCacheTTLFlag := fs.Duration("resolve-cache-ttl", 24*time.Hour, "Ignore --resolve-cache-file entries older than this (0 keeps them forever)")
+	sinceLastRunFlag := fs.Duration("since-last-run", 0, "Reuse --resolve-cache-file resolutions made within this long instead of resolving those actions again")
+	normalizeRefsFlag := fs.Bool("normalize-refs", false, "Pin every occurrence of an action to the highest version any of its ref forms resolves to")
+	commentChangesNoopFlag := fs.Bool("comment-changes-are-noop", false, "With --dry-run, do not count changes that only touch version comments")
+	hostWorkers := hostWorkersFlag{}
+	fs.Var(hostWorkers, "resolve-workers-per-host", "Maximum parallel resolutions against one host (host=N, e.g. ghes.example.com=2); repeatable. Default: --concurrency shared across hosts")
+	repoOverrides := repoOverrideFlag{}
+	fs.Var(repoOverrides, "repo-override", "Resolve owner/repo against otherowner/otherrepo (owner/repo=otherowner/otherrepo); repeatable")
+	rewriteOverridesFlag := fs.Bool("rewrite-overrides", false, "Also rewrite the owner/repo of overridden actions to the override target")
+	exactTagsPinnedFlag := fs.Bool("treat-exact-tags-as-pinned", false, "Leave refs on an exact semver tag (e.g. v4.2.2) alone; only pin moving tags, branches and SHAs")
+	pinContainersFlag := fs.Bool("pin-containers", false, "Also pin docker://ghcr.io image tags to their digest, authenticating with the GitHub token")
+	emitRenovateFlag := fs.Bool("emit-renovate-config", false, "Print a suggested Renovate config that keeps the discovered actions' pins updated, instead of pinning")
+	printDiscoveredJSONFlag := fs.Bool("print-discovered-json", false, "Print the discovered action references as JSON (owner, repo, ref, position, ref kind) without resolving anything")
+	printCurrentFlag := fs.Bool("print-current", false, "Print each occurrence's current ref and # version comment without resolving anything")
+	failOnMovingTagFlag := fs.Bool("fail-on-moving-tag", false, "Only check that lines changed since --diff-base add no moving tag or branch refs, instead of pinning")
+	diffBaseFlag := fs.String("diff-base", "HEAD", "Git ref that --fail-on-moving-tag diffs the files against (e.g. origin/main)")
+	outputFlag := fs.String("output", "", "With --emit-renovate-config, write the config to this file instead of stdout")
+	onErrorFlag := fs.String("on-error", onErrorContinue, "When references fail to resolve: continue, abort (stop the run) or skip-file (leave that file unchanged)")
+	explainRateLimitFlag := fs.Bool("explain-rate-limit", false, "Print the remaining API quota and whether this run is expected to fit in it before resolving")
+	followLocalReusableFlag := fs.Bool("follow-local-reusable", false, "Also process the local reusable workflows the files call (uses: ./.github/workflows/x.yml), recursively")
+	configFlag := fs.String("config", "", "YAML file of defaults (expand_major); flags on the command line override it")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
 		}
-		return "", "", err
+		return 1
 	}
 
-	var bestVersion *semver.Version
-	var bestTagName string
+	codes := exitCodes{NoOp: *noOpExitCodeFlag, Changes: *changesExitCodeFlag, Error: *errorExitCodeFlag}
+	if err := codes.validate(); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	// From here on every outcome is reported through the configured exit codes
+	defer func() { code = codes.apply(code) }()
 
-	for _, t := range tags {
-		name := t.GetName()
-		v, parseErr := semver.NewVersion(name)
-		if parseErr != nil {
-			continue
+	// Settings from --config apply unless the flag itself was given, so --expand-major=false
+	// turns off expand_major: true
+	expandMajor := *expandMajorFlag
+	if *configFlag != "" {
+		cfg, err := loadConfig(*configFlag)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: --config: %v\n", err)
+			return 1
 		}
-		if bestVersion == nil || v.GreaterThan(bestVersion) {
-			bestVersion = v
-			bestTagName = name
+		if cfg.ExpandMajor != nil && !flagSet(fs, "expand-major") {
+			expandMajor = *cfg.ExpandMajor
 		}
 	}
 
-	chosen := ""
-	if bestVersion != nil {
-		chosen = bestTagName
-	} else {
-		// Fallback to newest tag as returned by API (assumed newest first)
-		chosen = tags[0].GetName()
-	}
+	nonInteractiveApply := *yesFlag || *writeFlag || *fixFlag
 
-	sha, tagName, err := resolveTagToCommitSHA(ctx, client, owner, repo, chosen)
-	if err != nil {
-		return "", "", err
+	if *dryRunFlag && nonInteractiveApply {
+		fmt.Fprintf(stderr, "Error: --dry-run cannot be used with --yes/--write/--fix\n")
+		return 1
 	}
-	return sha, tagName, nil
-}
+	// Like linters, --check only reports and --check --fix applies.
+	dryRun := *dryRunFlag || (*checkFlag && !nonInteractiveApply)
 
-// parseMajor extracts the major version number from a ref string.
-// Accepts forms like "v4", "4", or full semver tags like "v4.2.2".
-func parseMajor(ref string) (int, bool) {
-	if isMovingMajorTag(ref) {
-		r := strings.TrimPrefix(ref, "v")
-		v, err := strconv.Atoi(r)
-		if err != nil {
-			return 0, false
-		}
-		return v, true
+	if *maxParallelFilesFlag < 1 {
+		fmt.Fprintf(stderr, "Error: --max-parallel-files must be at least 1, got %d\n", *maxParallelFilesFlag)
+		return 1
 	}
-	if v, err := semver.NewVersion(ref); err == nil {
-		return int(v.Major()), true
+	if *confirmEachFileFlag && dryRun {
+		fmt.Fprintf(stderr, "Error: --confirm-each-file cannot be used with --dry-run or --check, which write nothing\n")
+		return 1
 	}
-	return 0, false
+	if *confirmEachFileFlag && *maxParallelFilesFlag > 1 {
+		fmt.Fprintf(stderr, "Error: --confirm-each-file cannot be used with --max-parallel-files, whose files cannot take turns at the prompt\n")
+		return 1
+	}
+	if *maxParallelFilesFlag > 1 && !dryRun && !nonInteractiveApply {
+		// Files processed at once cannot take turns at the confirmation prompt
+		fmt.Fprintf(stderr, "Error: --max-parallel-files needs --dry-run, --check or --yes\n")
+		return 1
+	}
+
+	if fs.NArg() == 0 && *pinFileFlag == "" && *fromRefFlag == "" {
+		fs.Usage()
+		return 1
+	}
+
+	outputModes := 0
+	for _, set := range []bool{*summaryOnlyFlag, *exportEnvFlag, *printSHAsFlag, *jsonFlag} {
+		if set {
+			outputModes++
+		}
+	}
+	if outputModes > 1 {
+		fmt.Fprintf(stderr, "Error: --summary-only, --export-env, --print-shas and --json cannot be combined\n")
+		return 1
+	}
+	if *jsonFlag && !dryRun {
+		fmt.Fprintf(stderr, "Error: --json requires --dry-run\n")
+		return 1
+	}
+	jsonPretty := isTerminal(stdout)
+	if flagSet(fs, "json-pretty") {
+		if !*jsonFlag {
+			fmt.Fprintf(stderr, "Error: --json-pretty requires --json\n")
+			return 1
+		}
+		jsonPretty = *jsonPrettyFlag
+	}
+
+	cacheTTL := *resolveCacheTTLFlag
+	if flagSet(fs, "since-last-run") {
+		switch {
+		case *sinceLastRunFlag <= 0:
+			fmt.Fprintf(stderr, "Error: --since-last-run must be a positive duration\n")
+			return 1
+		case *resolveCacheFileFlag == "":
+			fmt.Fprintf(stderr, "Error: --since-last-run requires --resolve-cache-file, which records when each action was last resolved\n")
+			return 1
+		case flagSet(fs, "resolve-cache-ttl"):
+			fmt.Fprintf(stderr, "Error: --since-last-run and --resolve-cache-ttl cannot be combined\n")
+			return 1
+		}
+		cacheTTL = *sinceLastRunFlag
+	}
+
+	if *postWriteShellFlag && *postWriteCmdFlag == "" {
+		fmt.Fprintf(stderr, "Error: --post-write-shell requires --post-write-cmd\n")
+		return 1
+	}
+
+	readsStdin := false
+	for _, arg := range fs.Args() {
+		readsStdin = readsStdin || arg == stdinPath
+	}
+	if readsStdin && fs.NArg() > 1 {
+		fmt.Fprintf(stderr, "Error: - cannot be combined with other paths\n")
+		return 1
+	}
+	if *statsJSONFlag == "-" && (readsStdin || *jsonFlag) {
+		fmt.Fprintf(stderr, "Error: --stats-json - cannot be combined with --json or a - workflow, which already write to stdout\n")
+		return 1
+	}
+	if *fromRefFlag != "" {
+		if fs.NArg() > 0 {
+			fmt.Fprintf(stderr, "Error: --from-ref cannot be combined with paths\n")
+			return 1
+		}
+		if rev, path, ok := strings.Cut(*fromRefFlag, ":"); !ok || rev == "" || path == "" {
+			fmt.Fprintf(stderr, "Error: invalid --from-ref %q, want <git-ref>:<path>\n", *fromRefFlag)
+			return 1
+		}
+	}
+	// Both print the pinned workflow to stdout instead of writing a file
+	printsWorkflow := readsStdin || *fromRefFlag != ""
+	if printsWorkflow && (*summaryOnlyFlag || *exportEnvFlag || *printSHAsFlag) {
+		fmt.Fprintf(stderr, "Error: - and --from-ref write the pinned workflow to stdout and cannot be used with --summary-only, --export-env or --print-shas\n")
+		return 1
+	}
+	if *confirmEachFileFlag && printsWorkflow {
+		fmt.Fprintf(stderr, "Error: --confirm-each-file cannot be used with - or --from-ref, which print the workflow instead of writing it\n")
+		return 1
+	}
+	if *rangeFormatFlag {
+		if !printsWorkflow {
+			fmt.Fprintf(stderr, "Error: --range-format requires - or --from-ref\n")
+			return 1
+		}
+		// Only the ref rewrites are expressed as edits
+		if *commentOnlyFlag || *commentAlignmentFlag || *pinContainersFlag {
+			fmt.Fprintf(stderr, "Error: --range-format cannot be combined with --update-comment-only, --comment-alignment or --pin-containers\n")
+			return 1
+		}
+	}
+
+	stdinToken := ""
+	if *tokenStdinFlag {
+		if readsStdin {
+			fmt.Fprintf(stderr, "Error: --token-stdin cannot be used with - (stdin is used for the token)\n")
+			return 1
+		}
+		// Keep the buffered reader so that anything after the token line is still available to
+		// the confirmation prompt.
+		buffered := bufio.NewReader(stdin)
+		token, err := readTokenFromStdin(buffered)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+		stdinToken, stdin = token, buffered
+	}
+
+	if *tagsPerPageFlag < 1 || *tagsPerPageFlag > maxTagsPerPage {
+		fmt.Fprintf(stderr, "Error: --tags-per-page must be between 1 and %d, got %d\n", maxTagsPerPage, *tagsPerPageFlag)
+		return 1
+	}
+
+	inputFormat, err := parseInputFormat(*inputFormatFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	errorFormat, err := parseErrorFormat(*errorFormatFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if *retriesFlag < 0 {
+		fmt.Fprintf(stderr, "Error: --resolve-retries must not be negative\n")
+		return 1
+	}
+
+	tagFirst, err := parseMode(*modeFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	if tagFirst && (*commentOnlyFlag || *preferReleaseNameFlag) {
+		// Comment-only passes keep SHA refs, and release display names are no valid refs
+		fmt.Fprintf(stderr, "Error: --mode tag-first cannot be combined with --update-comment-only or --prefer-release-tag-name\n")
+		return 1
+	}
+	commentStyle, err := parseCommentStyle(*commentStyleFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	strip := commentStyle == commentStyleNoneStrip
+	if strip && (tagFirst || *commentOnlyFlag || strings.TrimSpace(*commentPrefixFlag) != "") {
+		// Each of these is about the comment: tag-first keeps the SHA in it
+		fmt.Fprintf(stderr, "Error: --comment-style none-strip cannot be combined with --mode tag-first, --update-comment-only or --comment-prefix\n")
+		return 1
+	}
+	if commentStyle != commentStyleVersion && !strip && (tagFirst || strings.TrimSpace(*commentPrefixFlag) != "") {
+		fmt.Fprintf(stderr, "Error: --comment-style %s cannot be combined with --mode tag-first or --comment-prefix\n", commentStyle)
+		return 1
+	}
+	var pinDate string
+	if commentStyle == commentStyleDated {
+		pinDate = time.Now().Format(pinDateLayout)
+	}
+
+	attestation, err := parseAttestationMode(*requireAttestationFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	promptDefaultYes, err := parsePromptDefault(*promptDefaultFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	onError, err := parseOnError(*onErrorFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	concurrency, autoConcurrencyEnabled, err := parseConcurrency(*concurrencyFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if *actionsDirFlag != "" && (*warnArchivedFlag || *excludeArchivedFlag || *explainRateLimitFlag || autoConcurrencyEnabled || attestation != "") {
+		fmt.Fprintf(stderr, "Error: --actions-dir cannot be used with --warn-archived, --exclude-archived-from-pin, --explain-rate-limit, --concurrency auto or --require-attestation, which need the GitHub API\n")
+		return 1
+	}
+	if *useLsRemoteFlag && (*actionsDirFlag != "" || *warnArchivedFlag || *excludeArchivedFlag || *explainRateLimitFlag || autoConcurrencyEnabled || attestation != "") {
+		fmt.Fprintf(stderr, "Error: --use-git-ls-remote cannot be used with --actions-dir, or with --warn-archived, --exclude-archived-from-pin, --explain-rate-limit, --concurrency auto or --require-attestation, which need the GitHub API\n")
+		return 1
+	}
+	if *actionsDirFlag != "" || *useLsRemoteFlag {
+		if _, err := exec.LookPath("git"); err != nil {
+			fmt.Fprintf(stderr, "Error: --actions-dir and --use-git-ls-remote need the git executable: %v\n", err)
+			return 1
+		}
+	}
+	lsRemoteBase := ""
+	if *useLsRemoteFlag {
+		lsRemoteBase = gitHubRemote
+	}
+
+	// Determine effective update policy (default to latest major) from flag only
+	effectivePolicy := UpdatePolicyMajor
+	if p, err := parsePolicy(*policyFlag); err == nil {
+		effectivePolicy = p
+	}
+
+	if *ignoreFileFlag != "" {
+		patterns, err := readIgnoreFile(*ignoreFileFlag)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+		ignores = append(ignores, patterns...)
+	}
+	var allowlist []allowEntry
+	if *allowlistFileFlag != "" {
+		allowlist, err = readAllowlistFile(*allowlistFileFlag)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+	} else if *auditLogFlag != "" {
+		fmt.Fprintf(stderr, "Error: --audit-log requires --owner-allowlist-file\n")
+		return 1
+	}
+
+	files, err := expandPaths(fs.Args(), *includeHiddenFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *fromRefFlag != "" {
+		files = append(files, *fromRefFlag)
+	}
+
+	if *followLocalReusableFlag {
+		if readsStdin || *fromRefFlag != "" {
+			fmt.Fprintf(stderr, "Error: --follow-local-reusable needs workflow files in the working tree, not - or --from-ref\n")
+			return 1
+		}
+		files = followLocalReusable(files, scanOptions{includeCommented: *includeCommentedFlag, anyCaseUses: *caseInsensitiveUsesFlag}, stderr)
+	}
+
+	if *outputFlag != "" && !*emitRenovateFlag {
+		fmt.Fprintf(stderr, "Error: --output requires --emit-renovate-config\n")
+		return 1
+	}
+	scan := scanOptions{includeCommented: *includeCommentedFlag, anyCaseUses: *caseInsensitiveUsesFlag}
+	if *failOnMovingTagFlag {
+		if readsStdin || *fromRefFlag != "" {
+			fmt.Fprintf(stderr, "Error: --fail-on-moving-tag needs workflow files in the working tree, not - or --from-ref\n")
+			return 1
+		}
+		return failOnMovingTag(context.Background(), files, *diffBaseFlag, scan, stdout, stderr)
+	}
+	if *printCurrentFlag {
+		style := CommentStyle{Prefix: strings.TrimSpace(*commentPrefixFlag)}
+		return printCurrent(context.Background(), files, *fromRefFlag, *stdinFilenameFlag, scan, stdin, stdout, stderr, style)
+	}
+	if *printDiscoveredJSONFlag {
+		return printDiscoveredJSON(context.Background(), files, *fromRefFlag, *stdinFilenameFlag, scan, stdin, stdout, stderr)
+	}
+	if *emitRenovateFlag {
+		return emitRenovateConfig(context.Background(), files, *fromRefFlag, scan, stdin, stdout, stderr, *outputFlag, CommentStyle{Prefix: strings.TrimSpace(*commentPrefixFlag)})
+	}
+
+	p := &pinner{
+		opts: ResolveOptions{
+			Policy:             effectivePolicy,
+			ExpandMajor:        expandMajor,
+			PreferReleaseName:  *preferReleaseNameFlag,
+			CommentOnly:        *commentOnlyFlag,
+			RegistryToken:      getRegistryToken(*registryTokenFlag),
+			Concurrency:        concurrency,
+			TagsPerPage:        *tagsPerPageFlag,
+			ActionsDir:         *actionsDirFlag,
+			GitRemote:          lsRemoteBase,
+			RepoOverrides:      repoOverrides,
+			HostWorkers:        hostWorkers,
+			RewriteOverrides:   *rewriteOverridesFlag,
+			ActionTimeout:      *actionTimeoutFlag,
+			NoResolveAnnotated: *noResolveAnnotatedFlag,
+		},
+		autoConcurrency:    autoConcurrencyEnabled,
+		headers:            http.Header(headers),
+		retries:            *retriesFlag,
+		retryJitter:        *retryJitterFlag,
+		stdinToken:         stdinToken,
+		dryRun:             dryRun,
+		nonInteractive:     nonInteractiveApply,
+		confirmEachFile:    *confirmEachFileFlag,
+		explainCacheKey:    *explainCacheKeyFlag,
+		lintCheckout:       *lintCheckoutFlag,
+		allowDowngrade:     *allowDowngradeFlag,
+		validate:           *validateFlag,
+		warnArchived:       *warnArchivedFlag,
+		excludeArchived:    *excludeArchivedFlag,
+		groupByAction:      *groupByActionFlag,
+		cacheFile:          *resolveCacheFileFlag,
+		normalizeRefs:      *normalizeRefsFlag,
+		commentChangesNoop: *commentChangesNoopFlag,
+		exactTagsPinned:    *exactTagsPinnedFlag,
+		containers:         *pinContainersFlag,
+		onError:            onError,
+		jsonReport:         *jsonFlag,
+		jsonPretty:         jsonPretty,
+		alignComments:      *commentAlignmentFlag,
+		stdinFilename:      *stdinFilenameFlag,
+		rangeFormat:        *rangeFormatFlag,
+		fromRef:            *fromRefFlag,
+		postWriteCmd:       strings.TrimSpace(*postWriteCmdFlag),
+		postWriteShell:     *postWriteShellFlag,
+		cacheTTL:           cacheTTL,
+		sinceLastRun:       flagSet(fs, "since-last-run"),
+		style:              CommentStyle{Prefix: strings.TrimSpace(*commentPrefixFlag), TagFirst: tagFirst, Strip: strip, Ratchet: commentStyle == commentStyleRatchet, Date: pinDate},
+		excludeOwners:      splitList(*excludeOwnersFlag),
+		ignore:             ignores,
+		allowlist:          allowlist,
+		exactOwnerMatch:    !*ownerCaseInsensitiveFlag,
+		onlyChanged:        *reportOnlyChangedFlag,
+		promptDefaultYes:   promptDefaultYes,
+		scan:               scan,
+		attestation:        attestation,
+		inputFormat:        inputFormat,
+		errorFormat:        errorFormat,
+		statsJSON:          *statsJSONFlag,
+		stdin:              stdin,
+		stdout:             stdout,
+		stderr:             stderr,
+		out:                stdout,
+		promptOut:          stdout,
+	}
+	if *allowlistFileFlag != "" {
+		p.audit = &auditLog{path: *auditLogFlag, user: auditUser()}
+	}
+
+	// With --summary-only, --export-env or --print-shas all progress output is dropped; the
+	// prompt (if any) moves to stderr so that stdout carries nothing but the final lines.
+	switch {
+	case *summaryOnlyFlag:
+		p.printFinal = printPinnedActions
+	case *exportEnvFlag:
+		p.printFinal = printEnvExports
+	case *printSHAsFlag:
+		p.printFinal = printSHAs
+	}
+	if p.printFinal != nil || p.jsonReport {
+		p.out, p.promptOut = io.Discard, stderr
+	} else if printsWorkflow {
+		// stdout carries the pinned workflow; progress goes to stderr
+		p.out = stderr
+	}
+	if p.autoConcurrency || *explainRateLimitFlag {
+		p.totalActions = countDistinctActions(files, p.scan)
+	}
+
+	ctx := context.Background()
+	if *explainRateLimitFlag {
+		p.explainRateLimit(ctx)
+	}
+	exitCode := p.processFiles(ctx, files, *maxParallelFilesFlag)
+	if *pinFileFlag != "" {
+		exitCode = mergeExitCodes(exitCode, p.processPinFile(ctx, *pinFileFlag))
+	}
+	if p.audit != nil && p.audit.rejected > 0 {
+		fmt.Fprintf(stderr, "Error: %d occurrence(s) resolved outside --owner-allowlist-file and were left unchanged\n", p.audit.rejected)
+		exitCode = 1
+	}
+	if p.cacheFile != "" && p.resolver != nil {
+		if err := saveResolveCache(p.cacheFile, p.resolver.exportResults()); err != nil {
+			fmt.Fprintf(stderr, "Warning: could not write %s: %v\n", p.cacheFile, err)
+		}
+	}
+	if *cacheStatsFlag && p.resolver != nil {
+		printCacheStats(p.out, p.resolver.CacheStats())
+	}
+	if p.statsJSON != "" {
+		if err := writeStatsJSON(p.statsJSON, stdout, p.resolver.Stats(time.Since(started))); err != nil {
+			fmt.Fprintf(stderr, "Warning: could not write --stats-json: %v\n", err)
+		}
+	}
+	return exitCode
 }
 
-// selectTagBySameMajor finds the highest semver tag within the specified major.
-func selectTagBySameMajor(ctx context.Context, client *github.Client, owner, repo string, major int) (string, string, error) {
-	page := 1
-	var bestVersion *semver.Version
-	var bestTagName string
-	foundMatchInPriorPages := false
+// renovateConfig is the Renovate configuration suggested by --emit-renovate-config: the
+// github-actions manager keeps `@sha # version` pins up to date, and a regex manager covers
+// version comments it cannot read (those with a --comment-prefix).
+type renovateConfig struct {
+	Schema         string                  `json:"$schema"`
+	Extends        []string                `json:"extends"`
+	PackageRules   []renovatePackageRule   `json:"packageRules"`
+	CustomManagers []renovateCustomManager `json:"customManagers,omitempty"`
+}
 
-	for {
-		opts := &github.ListOptions{PerPage: 100, Page: page}
-		tags, resp, err := client.Repositories.ListTags(ctx, owner, repo, opts)
+type renovatePackageRule struct {
+	MatchManagers     []string `json:"matchManagers"`
+	MatchPackageNames []string `json:"matchPackageNames"`
+	PinDigests        bool     `json:"pinDigests"`
+}
+
+type renovateCustomManager struct {
+	CustomType          string   `json:"customType"`
+	ManagerFilePatterns []string `json:"managerFilePatterns"`
+	MatchStrings        []string `json:"matchStrings"`
+	DatasourceTemplate  string   `json:"datasourceTemplate"`
+}
+
+// newRenovateConfig suggests a config for the given owner/repo actions, written in style.
+func newRenovateConfig(actions []string, style CommentStyle) renovateConfig {
+	config := renovateConfig{
+		Schema:  "https://docs.renovatebot.com/renovate-schema.json",
+		Extends: []string{"helpers:pinGitHubActionDigests"},
+		PackageRules: []renovatePackageRule{{
+			MatchManagers:     []string{"github-actions"},
+			MatchPackageNames: actions,
+			PinDigests:        true,
+		}},
+	}
+	if style.Prefix != "" {
+		config.PackageRules[0].MatchManagers = append(config.PackageRules[0].MatchManagers, "custom.regex")
+		config.CustomManagers = []renovateCustomManager{{
+			CustomType:          "regex",
+			ManagerFilePatterns: []string{`/(^|/)\.github/workflows/[^/]+\.ya?ml$/`},
+			MatchStrings: []string{`uses:\s+["']?(?<depName>[^@/\s"']+/[^@/\s"']+)[^@\s"']*@(?<currentDigest>[0-9a-f]{40})["']?\s+#\s*` +
+				regexp.QuoteMeta(style.Prefix) + `\s*(?<currentValue>\S+)`},
+			DatasourceTemplate: "github-tags",
+		}}
+	}
+	return config
+}
+
+// emitRenovateConfig implements --emit-renovate-config: it collects the actions used by files,
+// without resolving anything, and writes the suggested config to output, or stdout. Actions
+// referenced by full URL are left out, since they don't live on github.com, where Renovate's
+// github-tags datasource looks them up.
+func emitRenovateConfig(ctx context.Context, files []string, fromRef string, scan scanOptions, stdin io.Reader, stdout, stderr io.Writer, output string, style CommentStyle) int {
+	seen := make(map[string]bool)
+	actions := []string{}
+	for _, file := range files {
+		content, err := readInput(ctx, file, fromRef, stdin)
 		if err != nil {
-			return "", "", err
+			fmt.Fprintf(stderr, "Error reading %s: %v\n", file, err)
+			return 1
+		}
+		for _, occ := range scanOccurrences(string(content), scan) {
+			if occ.Host != "" {
+				continue
+			}
+			// Renovate names actions by repository, without a subpath
+			name := repoSlug(occ)
+			if !seen[name] {
+				seen[name] = true
+				actions = append(actions, name)
+			}
 		}
+	}
+	sort.Strings(actions)
 
-		// Track whether this page contained any tags matching the requested major.
-		// If none are found on a page and tags are ordered newest-first by the API,
-		// we can stop early once we have already seen at least one match in prior pages.
-		foundMatchOnCurrentPage := false
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(newRenovateConfig(actions, style)); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	if output == "" {
+		_, _ = b.WriteTo(stdout)
+		return 0
+	}
+	if err := os.WriteFile(output, b.Bytes(), 0644); err != nil {
+		fmt.Fprintf(stderr, "Error writing %s: %v\n", output, err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "Wrote Renovate config for %d action(s) to %s\n", len(actions), output)
+	return 0
+}
 
-		for _, t := range tags {
-			name := t.GetName()
-			v, parseErr := semver.NewVersion(name)
-			if parseErr != nil {
+// readInput reads a workflow for the modes that only scan files: - reads stdin and the
+// --from-ref path is read from git.
+func readInput(ctx context.Context, file, fromRef string, stdin io.Reader) ([]byte, error) {
+	switch {
+	case file == stdinPath:
+		return io.ReadAll(stdin)
+	case fromRef != "" && file == fromRef:
+		return gitShow(ctx, file)
+	default:
+		return os.ReadFile(file)
+	}
+}
+
+// printCurrent implements --print-current: an inventory of the refs files are pinned to right
+// now, read from the files alone without any API call.
+func printCurrent(ctx context.Context, files []string, fromRef, stdinFilename string, scan scanOptions, stdin io.Reader, stdout, stderr io.Writer, style CommentStyle) int {
+	for _, file := range files {
+		content, err := readInput(ctx, file, fromRef, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading %s: %v\n", file, err)
+			return 1
+		}
+		name := file
+		if file == stdinPath {
+			name = stdinFilename
+		}
+		printCurrentPins(stdout, name, scanOccurrences(string(content), scan), style)
+	}
+	return 0
+}
+
+// printCurrentPins prints one `file:line:column: owner/repo@ref` line per occurrence, followed
+// by `# version` when the occurrence has a trailing version comment.
+func printCurrentPins(w io.Writer, file string, occurrences []ActionOccurrence, style CommentStyle) {
+	for _, occ := range occurrences {
+		line := fmt.Sprintf("%s:%d:%d: %s@%s", file, occ.Line, occ.Column, occ.Action, occ.RequestedRef)
+		if version := style.parse(occ.Comment); version != "" {
+			if _, err := semver.NewVersion(version); err == nil {
+				line += " # " + version
+			}
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// discoveredSchemaVersion is the schemaVersion of --print-discovered-json, versioned like --json.
+const discoveredSchemaVersion = 1
+
+// discoveredFile is the --print-discovered-json document of one file.
+type discoveredFile struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	File          string      `json:"file"`
+	Actions       []discovery `json:"actions"`
+}
+
+// discovery is one uses: reference as written, classified by refKind.
+type discovery struct {
+	Host    string `json:"host,omitempty"`
+	Owner   string `json:"owner"`
+	Repo    string `json:"repo"`
+	Ref     string `json:"ref"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	RefKind string `json:"refKind"`
+}
+
+// The kinds of ref a uses: reference can be pinned to, as reported by refKind.
+const (
+	refKindSHA      = "sha"       // full commit SHA
+	refKindShortSHA = "short-sha" // abbreviated commit SHA
+	refKindMajor    = "major"     // moving major tag: v4 or 4
+	refKindMinor    = "minor"     // moving minor tag: v4.2
+	refKindVersion  = "version"   // exact semver tag: v4.2.2
+	refKindOther    = "other"     // a branch or a tag that is not semver
+)
+
+var minorTagRe = regexp.MustCompile(`^v?\d+\.\d+$`)
+
+// refKind classifies ref without looking it up, so a branch named like a tag or a SHA is
+// reported as one.
+func refKind(ref string) string {
+	switch {
+	case isFullSHA(ref):
+		return refKindSHA
+	case isShortSHA(ref):
+		return refKindShortSHA
+	case isMovingMajorTag(ref):
+		return refKindMajor
+	case minorTagRe.MatchString(ref):
+		return refKindMinor
+	case isExactSemverTag(ref):
+		return refKindVersion
+	}
+	return refKindOther
+}
+
+// printDiscoveredJSON implements --print-discovered-json: one JSON document per file listing its
+// action references as written, read from the files alone without any API call.
+func printDiscoveredJSON(ctx context.Context, files []string, fromRef, stdinFilename string, scan scanOptions, stdin io.Reader, stdout, stderr io.Writer) int {
+	enc := json.NewEncoder(stdout)
+	enc.SetEscapeHTML(false)
+	for _, file := range files {
+		content, err := readInput(ctx, file, fromRef, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading %s: %v\n", file, err)
+			return 1
+		}
+		name := file
+		if file == stdinPath {
+			name = stdinFilename
+		}
+		if err := enc.Encode(newDiscoveredFile(name, scanOccurrences(string(content), scan))); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func newDiscoveredFile(file string, occurrences []ActionOccurrence) discoveredFile {
+	doc := discoveredFile{SchemaVersion: discoveredSchemaVersion, File: file, Actions: []discovery{}}
+	for _, occ := range occurrences {
+		doc.Actions = append(doc.Actions, discovery{
+			Host: occ.Host, Owner: occ.Owner, Repo: occ.Repo, Ref: occ.RequestedRef,
+			Line: occ.Line, Column: occ.Column, RefKind: refKind(occ.RequestedRef),
+		})
+	}
+	return doc
+}
+
+// exitCodes maps the three outcomes of a run to process exit codes, so pipelines can match
+// their own CI semantics. Internally runs always use 0 (nothing pending, including after a
+// successful write), 2 (--dry-run found changes) and 1 (error).
+type exitCodes struct {
+	NoOp    int
+	Changes int
+	Error   int
+}
+
+func (c exitCodes) validate() error {
+	for _, v := range []int{c.NoOp, c.Changes, c.Error} {
+		if v < 0 || v > 125 {
+			return fmt.Errorf("exit codes must be between 0 and 125, got %d", v)
+		}
+	}
+	if c.Error == c.NoOp {
+		return fmt.Errorf("--error-exit-code must differ from --no-op-exit-code")
+	}
+	return nil
+}
+
+// apply translates an internal exit code to the configured one.
+func (c exitCodes) apply(code int) int {
+	switch code {
+	case 0:
+		return c.NoOp
+	case 2:
+		return c.Changes
+	default:
+		return c.Error
+	}
+}
+
+// mergeExitCodes combines per-file exit codes: errors (1) win over pending changes (2),
+// which win over success (0).
+func mergeExitCodes(a, b int) int {
+	if a == 1 || b == 1 {
+		return 1
+	}
+	if a == 2 || b == 2 {
+		return 2
+	}
+	return 0
+}
+
+// expandPaths turns the command-line arguments into the list of workflow files to process.
+// Directories are searched recursively for .yml/.yaml files and arguments that don't exist
+// are expanded as glob patterns. Each file appears once, in order of first appearance,
+// even when several arguments (or overlapping globs) refer to it. Hidden directories below a
+// directory argument are skipped, except .github, unless includeHidden is set; .git never
+// is searched.
+func expandPaths(args []string, includeHidden bool) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = filepath.Clean(path)
+		}
+		if seen[abs] {
+			return
+		}
+		seen[abs] = true
+		files = append(files, path)
+	}
+
+	for _, arg := range args {
+		info, statErr := os.Stat(arg)
+		switch {
+		case statErr == nil && info.IsDir():
+			err := filepath.WalkDir(arg, func(path string, d os.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					if d.Name() == ".git" {
+						return filepath.SkipDir
+					}
+					if path != arg && !includeHidden && isHiddenDir(d.Name()) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if isWorkflowFile(path) {
+					add(path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		case statErr != nil && strings.ContainsAny(arg, "*?["):
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", arg)
+			}
+			for _, m := range matches {
+				add(m)
+			}
+		default:
+			// Plain files (including missing ones, which are reported when processed)
+			add(arg)
+		}
+	}
+	return files, nil
+}
+
+// followLocalReusable appends to files the local reusable workflows they call, such as
+// uses: ./.github/workflows/build.yml, then the ones those call, and so on. Each file is added
+// once, which also ends call cycles. Missing targets are warned about on stderr.
+func followLocalReusable(files []string, scan scanOptions, stderr io.Writer) []string {
+	seen := make(map[string]bool)
+	key := func(path string) string {
+		if abs, err := filepath.Abs(path); err == nil {
+			return abs
+		}
+		return filepath.Clean(path)
+	}
+	for _, file := range files {
+		seen[key(file)] = true
+	}
+	out := append([]string(nil), files...)
+	for i := 0; i < len(out); i++ {
+		content, err := os.ReadFile(out[i])
+		if err != nil {
+			continue // reported when the file is processed
+		}
+		for _, ref := range findUnpinnable(string(content), scan, false) {
+			if ref.Reason != unpinnableLocal || !isWorkflowFile(ref.Uses) {
 				continue
 			}
-			if int(v.Major()) != major {
+			target := filepath.Join(repoRoot(out[i]), filepath.FromSlash(ref.Uses))
+			if seen[key(target)] {
 				continue
 			}
-			foundMatchOnCurrentPage = true
-			if bestVersion == nil || v.GreaterThan(bestVersion) {
-				bestVersion = v
-				bestTagName = name
+			seen[key(target)] = true
+			if _, err := os.Stat(target); err != nil {
+				fmt.Fprintf(stderr, "Warning: %s (L%d:C%d): reusable workflow %s not found\n", out[i], ref.Line, ref.Column, target)
+				continue
 			}
+			out = append(out, target)
 		}
+	}
+	return out
+}
 
-		// Early stop heuristic: only stop when the current page has no matches AND we
-		// previously saw at least one matching tag on an earlier page.
-		if !foundMatchOnCurrentPage && foundMatchInPriorPages {
-			break
+// repoRoot returns the directory local uses: paths in file are relative to: the parent of the
+// .github directory file is in, or the current directory for files outside one.
+func repoRoot(file string) string {
+	for dir := filepath.Dir(file); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if filepath.Base(dir) == ".github" {
+			return filepath.Dir(dir)
+		}
+	}
+	return "."
+}
+
+// isHiddenDir reports whether a directory below a searched directory is hidden. .github is
+// where workflows live, so it never counts as hidden.
+func isHiddenDir(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".." && name != ".github"
+}
+
+func isWorkflowFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yml" || ext == ".yaml"
+}
+
+// isTerminal reports whether w is a terminal (a character device) rather than a pipe or file.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+// gitShow reads the file named by a <git-ref>:<path> spec from the repository in the current
+// directory without checking it out.
+func gitShow(ctx context.Context, spec string) ([]byte, error) {
+	return runGit(ctx, "show", spec)
+}
+
+// runGit runs a git subcommand and returns its stdout; errors carry git's own message.
+func runGit(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "git", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// addedLines returns the 1-based numbers of the lines of file that were added or changed since
+// the git ref base. all is set when file does not exist at base, so that every line is new.
+func addedLines(ctx context.Context, base, file string) (lines map[int]bool, all bool, err error) {
+	tracked, err := runGit(ctx, "ls-tree", "--name-only", base, "--", file)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(bytes.TrimSpace(tracked)) == 0 {
+		return nil, true, nil
+	}
+	diff, err := runGit(ctx, "diff", "--unified=0", "--no-color", "--no-ext-diff", base, "--", file)
+	if err != nil {
+		return nil, false, err
+	}
+	lines = make(map[int]bool)
+	for _, line := range strings.Split(string(diff), "\n") {
+		// Hunk headers: @@ -a[,b] +c[,d] @@, where d is the number of new lines (1 if omitted)
+		m := hunkHeaderRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		start, _ := strconv.Atoi(m[1])
+		count := 1
+		if m[2] != "" {
+			count, _ = strconv.Atoi(m[2])
+		}
+		for n := start; n < start+count; n++ {
+			lines[n] = true
+		}
+	}
+	return lines, false, nil
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// isMovingRef reports whether ref can move under a workflow: anything but a full commit SHA
+// or an exact semver tag, i.e. a major or minor tag, a branch or an abbreviated SHA.
+func isMovingRef(ref string) bool {
+	return ref != "" && !isFullSHA(ref) && !isExactSemverTag(ref)
+}
+
+// failOnMovingTag implements --fail-on-moving-tag: a PR gate that resolves nothing and only
+// reports uses: references with a moving ref on lines added or changed since base. Unpinned
+// references that were already there are left to --check. It returns 2 when any were found.
+func failOnMovingTag(ctx context.Context, files []string, base string, scan scanOptions, stdout, stderr io.Writer) int {
+	found := 0
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading %s: %v\n", file, err)
+			return 1
+		}
+		lines, all, err := addedLines(ctx, base, file)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error diffing %s against %s: %v\n", file, base, err)
+			return 1
+		}
+		for _, occ := range scanOccurrences(string(content), scan) {
+			if !(all || lines[occ.Line]) || !isMovingRef(occ.RequestedRef) {
+				continue
+			}
+			found++
+			fmt.Fprintf(stdout, "%s:%d:%d: %s@%s is not pinned to a commit SHA\n", file, occ.Line, occ.Column, occ.Action, occ.RequestedRef)
+		}
+	}
+	if found > 0 {
+		fmt.Fprintf(stderr, "%s %d new moving ref(s) since %s; pin them to commit SHAs\n", bold("Failed:"), found, base)
+		return 2
+	}
+	return 0
+}
+
+// stdinPath is the path argument that reads a workflow from stdin and writes it to stdout.
+const stdinPath = "-"
+
+// countDistinctActions counts the distinct owner/repo@ref references across files, as scan
+// finds them.
+func countDistinctActions(files []string, scan scanOptions) int {
+	unique := make(map[string]bool)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, occ := range scanOccurrences(string(content), scan) {
+			unique[occ.Action+"@"+occ.RequestedRef] = true
+		}
+	}
+	return len(unique)
+}
+
+// pinner carries the options and output streams shared by every file processed in a run.
+type pinner struct {
+	opts               ResolveOptions
+	autoConcurrency    bool
+	totalActions       int // distinct actions across all files, for --concurrency auto
+	headers            http.Header
+	retries            int
+	retryJitter        bool
+	stdinToken         string // from --token-stdin; takes precedence over the environment
+	dryRun             bool
+	nonInteractive     bool
+	confirmEachFile    bool
+	explainCacheKey    bool
+	lintCheckout       bool
+	allowDowngrade     bool
+	validate           bool
+	warnArchived       bool
+	excludeArchived    bool // --exclude-archived-from-pin
+	groupByAction      bool
+	cacheFile          string // --resolve-cache-file
+	statsJSON          string // --stats-json path, or - for stdout
+	attestation        string // --require-attestation: warn or fail
+	inputFormat        string // --input-format
+	errorFormat        string // --error-format
+	cacheTTL           time.Duration
+	sinceLastRun       bool
+	normalizeRefs      bool
+	commentChangesNoop bool // --comment-changes-are-noop
+	exactTagsPinned    bool // --treat-exact-tags-as-pinned
+	containers         bool // --pin-containers
+	onError            string
+	unresolved         bool // set by stopOnErrors for the file being processed
+	jsonReport         bool // --json
+	jsonPretty         bool
+	alignComments      bool // --comment-alignment
+	rangeFormat        bool // --range-format
+	stdinFilename      string
+	fromRef            string // --from-ref <git-ref>:<path>
+	postWriteCmd       string // --post-write-cmd
+	postWriteShell     bool
+	style              CommentStyle
+	scan               scanOptions
+	excludeOwners      []string
+	ignore             []string
+	allowlist          []allowEntry
+	exactOwnerMatch    bool // --owner-case-insensitive=false
+	onlyChanged        bool // --report-only-changed
+	promptDefaultYes   bool // --prompt-default yes
+	printFinal         func(io.Writer, []ActionInfo)
+
+	stdin     io.Reader
+	stdout    io.Writer
+	stderr    io.Writer
+	out       io.Writer // progress output; discarded in summary modes
+	promptOut io.Writer
+
+	resolver *Resolver     // created on first use and shared across files
+	registry *ghcrRegistry // likewise, for --pin-containers
+	progress *fileProgress // --max-parallel-files on a terminal
+	audit    *auditLog     // with --owner-allowlist-file, shared across files
+}
+
+// getResolver returns the run's Resolver, creating the API client on first use so that
+// runs without any action references never need a token.
+func (p *pinner) getResolver(ctx context.Context) (*Resolver, error) {
+	if p.resolver != nil {
+		return p.resolver, nil
+	}
+	if p.opts.ActionsDir != "" || p.opts.GitRemote != "" {
+		// Offline or over git: no token and no API client
+		return p.setResolver(NewResolver(nil, p.opts)), nil
+	}
+	token, err := getGitHubToken(p.stdinToken)
+	if err != nil {
+		return nil, err
+	}
+	client := withRetries(newGitHubClient(ctx, token, p.headers), p.retries, p.retryJitter)
+
+	opts := p.opts
+	if p.autoConcurrency {
+		limits, _, rlErr := client.RateLimit.Get(ctx)
+		if rlErr != nil || limits.GetCore() == nil {
+			opts.Concurrency = 4
+			fmt.Fprintf(p.stderr, "Warning: could not read rate limits (%v); using concurrency %d\n", rlErr, opts.Concurrency)
+		} else {
+			core := limits.GetCore()
+			opts.Concurrency, opts.Pace = autoConcurrency(core.Remaining, p.totalActions, time.Until(core.Reset.Time))
+			fmt.Fprintf(p.out, "  Concurrency: auto → %d worker(s), %d of %d requests remaining (resets %s)\n",
+				opts.Concurrency, core.Remaining, core.Limit, core.Reset.Time.Local().Format(time.Kitchen))
+			if opts.Pace > 0 {
+				fmt.Fprintf(p.out, "  Budget is low: pacing resolutions %s apart\n", opts.Pace.Round(time.Second))
+			}
+		}
+	}
+	r := NewResolver(client, opts)
+	if p.statsJSON != "" {
+		r.countAPICalls()
+	}
+	r.hostClient = func(ctx context.Context, host string) (*github.Client, error) {
+		token, err := getHostToken(host)
+		if err != nil {
+			return nil, err
+		}
+		client, err := newHostClient(ctx, host, token, p.headers)
+		if err != nil {
+			return nil, err
+		}
+		return withRetries(client, p.retries, p.retryJitter), nil
+	}
+	return p.setResolver(r), nil
+}
+
+// setResolver installs r as the run's Resolver, seeding it from --resolve-cache-file.
+func (p *pinner) setResolver(r *Resolver) *Resolver {
+	p.resolver = r
+	if p.cacheFile == "" {
+		return r
+	}
+	entries, err := loadResolveCache(p.cacheFile)
+	if err != nil {
+		fmt.Fprintf(p.stderr, "Warning: ignoring resolve cache: %v\n", err)
+		return r
+	}
+	n := r.seedResults(entries, p.cacheTTL, time.Now())
+	switch {
+	case p.sinceLastRun:
+		fmt.Fprintf(p.out, "  Reusing %d resolution(s) made in the last %s from %s; other actions are resolved again\n", n, p.cacheTTL, p.cacheFile)
+	case n > 0:
+		fmt.Fprintf(p.out, "  Loaded %d cached resolution(s) from %s\n", n, p.cacheFile)
+	}
+	return r
+}
+
+// explainRateLimit prints the remaining core API quota and whether the distinct actions of
+// this run are expected to fit in it. It is informational only and never aborts the run.
+func (p *pinner) explainRateLimit(ctx context.Context) {
+	r, err := p.getResolver(ctx)
+	if err != nil {
+		fmt.Fprintf(p.stderr, "Warning: rate limit preflight skipped: %v\n", err)
+		return
+	}
+	limits, _, err := r.client.RateLimit.Get(ctx)
+	if err != nil || limits.GetCore() == nil {
+		fmt.Fprintf(p.stderr, "Warning: could not read rate limits: %v\n", err)
+		return
+	}
+	printRateLimitEstimate(p.out, p.stderr, limits.GetCore(), p.totalActions)
+}
+
+// printRateLimitEstimate compares the remaining quota against the estimated number of API
+// calls for actions distinct actions; a shortfall is reported as a warning on errW.
+func printRateLimitEstimate(w, errW io.Writer, core *github.Rate, actions int) {
+	needed := actions * estimatedCallsPerAction
+	fmt.Fprintln(w, bold("Rate limit:\n"))
+	fmt.Fprintf(w, "  Remaining: %d of %d core requests (resets %s)\n",
+		core.Remaining, core.Limit, core.Reset.Time.Local().Format(time.Kitchen))
+	fmt.Fprintf(w, "  Estimated: up to %d request(s) for %d distinct action(s)\n", needed, actions)
+	if core.Remaining >= needed {
+		fmt.Fprintf(w, "  This run should fit in the remaining quota\n\n")
+		return
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(errW, "Warning: this run may need up to %d requests but only %d remain until %s; "+
+		"consider --concurrency auto to pace resolutions, or pin fewer files per run\n",
+		needed, core.Remaining, core.Reset.Time.Local().Format(time.Kitchen))
+}
+
+// processFiles runs processFile for every file, up to parallel files at once. In parallel each
+// file's output is buffered and written in file order once it is done, so the output does
+// not depend on scheduling. With --on-error abort no file is started after references failed
+// to resolve; other errors, such as an unreadable file, do not stop the run.
+func (p *pinner) processFiles(ctx context.Context, files []string, parallel int) int {
+	if parallel <= 1 || len(files) <= 1 {
+		exitCode := 0
+		for i, file := range files {
+			p.unresolved = false
+			exitCode = mergeExitCodes(exitCode, p.processFile(ctx, file))
+			if p.unresolved && p.onError == onErrorAbort {
+				p.reportAborted(len(files) - i - 1)
+				break
+			}
+		}
+		return exitCode
+	}
+
+	// Create the shared clients up front; the per-file copies of p below cannot set them.
+	if countDistinctActions(files, p.scan) > 0 {
+		if _, err := p.getResolver(ctx); err != nil {
+			fmt.Fprintf(p.stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	if p.containers {
+		p.getRegistry()
+	}
+	if isTerminal(p.stderr) {
+		p.progress = newFileProgress(p.stderr, files)
+	}
+
+	type fileResult struct {
+		code                int
+		stdout, stderr, out bytes.Buffer // out: progress written anywhere else, e.g. io.Discard
+	}
+	results := make([]*fileResult, len(files))
+	var aborted atomic.Bool
+	runLimited(len(files), parallel, func(i int) {
+		if aborted.Load() {
+			return
+		}
+		res := &fileResult{}
+		fp := *p
+		fp.stdout, fp.stderr = &res.stdout, &res.stderr
+		switch p.out {
+		case p.stdout:
+			fp.out = &res.stdout
+		case p.stderr:
+			fp.out = &res.stderr
+		default:
+			fp.out = &res.out
+		}
+		res.code = fp.processFile(ctx, files[i])
+		p.progress.fileDone()
+		if fp.unresolved && p.onError == onErrorAbort {
+			aborted.Store(true)
+		}
+		results[i] = res
+	})
+	p.progress.finish()
+
+	exitCode, skipped := 0, 0
+	for _, res := range results {
+		if res == nil {
+			skipped++
+			continue
+		}
+		_, _ = res.out.WriteTo(p.out)
+		_, _ = res.stdout.WriteTo(p.stdout)
+		_, _ = res.stderr.WriteTo(p.stderr)
+		exitCode = mergeExitCodes(exitCode, res.code)
+	}
+	p.reportAborted(skipped)
+	return exitCode
+}
+
+func (p *pinner) reportAborted(remaining int) {
+	if remaining > 0 {
+		fmt.Fprintf(p.stderr, "Aborting: %d file(s) not processed (--on-error abort)\n", remaining)
+	}
+}
+
+// runLimited calls fn(0) … fn(n-1) with at most limit calls running at once and returns
+// when all of them have.
+func runLimited(n, limit int, fn func(i int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, limit)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// fileProgress shows "file 3/10, action 5/12" on a terminal while --max-parallel-files runs,
+// overwriting one line. A nil *fileProgress shows nothing.
+type fileProgress struct {
+	mu                   sync.Mutex
+	w                    io.Writer
+	files, filesDone     int
+	actions, actionsDone int
+}
+
+func newFileProgress(w io.Writer, files []string) *fileProgress {
+	pr := &fileProgress{w: w, files: len(files)}
+	for _, file := range files {
+		if content, err := os.ReadFile(file); err == nil {
+			pr.actions += len(extractOccurrences(string(content)))
+		}
+	}
+	return pr
+}
+
+// resolved counts n more action occurrences as resolved.
+func (pr *fileProgress) resolved(n int) {
+	if pr == nil {
+		return
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.actionsDone += n
+	pr.show()
+}
+
+func (pr *fileProgress) fileDone() {
+	if pr == nil {
+		return
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.filesDone++
+	pr.show()
+}
+
+// finish clears the progress line so the buffered output starts on a clean line.
+func (pr *fileProgress) finish() {
+	if pr == nil {
+		return
+	}
+	fmt.Fprint(pr.w, "\r\033[K")
+}
+
+func (pr *fileProgress) show() {
+	fmt.Fprintf(pr.w, "\r\033[KProgress: file %d/%d, action %d/%d", pr.filesDone, pr.files, min(pr.actionsDone, pr.actions), pr.actions)
+}
+
+// reported narrows occurrences and their infos to what the outputs list: every resolution, or
+// with --report-only-changed only those that get a new SHA.
+func (p *pinner) reported(occurrences []ActionOccurrence, actionInfos []ActionInfo) ([]ActionOccurrence, []ActionInfo) {
+	if !p.onlyChanged {
+		return occurrences, actionInfos
+	}
+	return changedOnly(occurrences, actionInfos, p.style)
+}
+
+// processFile scans, resolves and (depending on the options) rewrites a single workflow file,
+// returning its exit code: 0 on success (including a file without actions), 1 on error and 2
+// when a dry run found changes.
+func (p *pinner) processFile(ctx context.Context, workflowFile string) (code int) {
+	out, stderr := p.out, p.stderr
+	name := workflowFile
+
+	// With --json every file gets a document, also one that ends early (without actions,
+	// with nothing to pin or on an error): it reports what is known by then, possibly nothing.
+	var occurrences []ActionOccurrence
+	var actionInfos []ActionInfo
+	var unpinnable []unpinnableRef
+	if p.jsonReport {
+		defer func() {
+			report := newDryRunReport(name, occurrences, actionInfos, p.style)
+			if len(unpinnable) > 0 {
+				report.Unpinnable = unpinnable
+			}
+			if err := writeDryRunReport(p.stdout, report, p.jsonPretty); err != nil {
+				fmt.Fprintf(stderr, "Error: %v\n", err)
+				code = 1
+			}
+		}()
+	}
+
+	var content, printed []byte
+	var err error
+	printsWorkflow := workflowFile == stdinPath || (p.fromRef != "" && workflowFile == p.fromRef)
+	if printsWorkflow {
+		if workflowFile == stdinPath {
+			name = p.stdinFilename
+		}
+		fmt.Fprintf(out, "\n%s %s\n\n", bold("Scanning workflow"), name)
+		if workflowFile == stdinPath {
+			content, err = io.ReadAll(p.stdin)
+		} else {
+			content, err = gitShow(ctx, workflowFile)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading %s: %v\n", name, err)
+			return 1
+		}
+		// The workflow is printed to stdout instead of written: the pinned content, or the
+		// input as is when nothing was pinned. Dry runs and errors print nothing.
+		printed = content
+		if p.rangeFormat {
+			printed = formatEdits(name, nil)
+		}
+		defer func() {
+			if code != 1 && !p.dryRun {
+				_, _ = p.stdout.Write(printed)
+			}
+		}()
+	} else {
+		info, err := os.Stat(workflowFile)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(stderr, "Error: File '%s' not found\n", workflowFile)
+			return 1
+		}
+		if err == nil && info.Size() > streamThreshold && p.canStream() {
+			return p.processLargeFile(ctx, workflowFile)
+		}
+
+		fmt.Fprintf(out, "\n%s %s\n\n", bold("Scanning workflow"), workflowFile)
+
+		if content, err = os.ReadFile(workflowFile); err != nil {
+			fmt.Fprintf(stderr, "Error reading file: %v\n", err)
+			return 1
+		}
+	}
+
+	format := p.inputFormat
+	if format == inputFormatAuto {
+		format = detectInputFormat(string(content))
+	} else if err := checkInputFormat(string(content), format); err != nil {
+		fmt.Fprintf(stderr, "Error: %s: %v (--input-format %s)\n", name, err, format)
+		return 1
+	}
+
+	occurrences = scanOccurrences(string(content), p.scan)
+	actions := discoveredActions(scanActions(string(content), p.scan), occurrences)
+	for _, occ := range miscasedUses(string(content), p.scan) {
+		p.warnMiscased(name, occ, usesKey(string(content), occ))
+	}
+	containers := 0
+	if p.containers {
+		containers = len(scanContainerOccurrences(string(content), p.scan))
+	}
+	unpinnable = findUnpinnable(string(content), p.scan, p.containers)
+	printUnpinnable(out, unpinnable)
+	if p.lintCheckout {
+		printCheckoutLint(stderr, lintCheckout(string(content)))
+	}
+	if len(actions) == 0 && containers == 0 {
+		fmt.Fprintf(out, "%s %s\n", bold("No actions:"), noActionsMessage(string(content), format, name))
+		return 0
+	}
+
+	fmt.Fprintln(out, bold("Discovered actions:\n"))
+	for _, action := range actions {
+		fmt.Fprintf(out, "  - %s\n", action)
+	}
+	fmt.Fprintln(out)
+
+	occurrences, reason := p.skipOccurrences(occurrences)
+	if len(occurrences) == 0 && containers == 0 && reason != "" {
+		fmt.Fprintln(out, bold("Nothing to pin:"), reason)
+		return 0
+	}
+
+	fmt.Fprintln(out, bold("Resolving latest versions and SHAs (parallel)...\n"))
+
+	resolver, err := p.getResolver(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	actionInfos = resolver.getActionInfosForOccurrences(ctx, occurrences)
+	p.progress.resolved(len(occurrences))
+	if p.attestation != "" {
+		resolver.checkAttestations(ctx, out, stderr, actionInfos, p.attestation)
+	}
+
+	if len(actionInfos) == 0 && containers == 0 {
+		fmt.Fprintln(out, bold("No action information retrieved."))
+		return 1
+	}
+	reportedOccs, reportedInfos := p.reported(occurrences, actionInfos)
+	printResolvedActions(out, reportedOccs, reportedInfos)
+	if p.errorFormat == errorFormatParseable {
+		printParseableFailures(stderr, name, occurrences, actionInfos)
+	} else {
+		printFailedActions(stderr, occurrences, actionInfos)
+	}
+	printFallbacks(stderr, occurrences, actionInfos)
+	if p.explainCacheKey {
+		printCacheKeys(stderr, occurrences, actionInfos)
+	}
+	if code, stop := p.stopOnErrors(countFailed(actionInfos), name); stop {
+		return code
+	}
+	for _, warning := range resolver.tagObjectWarnings(actionInfos) {
+		fmt.Fprintf(stderr, "Warning: %s\n", warning)
+	}
+	for _, warning := range resolver.suspiciousResolutions(ctx, occurrences, actionInfos) {
+		fmt.Fprintf(stderr, "Warning: %s\n", warning)
+	}
+
+	if p.normalizeRefs && !p.opts.CommentOnly {
+		if changes := normalizeActionInfos(occurrences, actionInfos); len(changes) > 0 {
+			fmt.Fprintln(out, bold("\nNormalized refs:\n"))
+			for _, n := range changes {
+				fmt.Fprintf(out, "  - %s (L%d:C%d): %s → %s\n", n.Action, n.Line, n.Column, n.From, n.To)
+			}
+		}
+	}
+
+	if p.warnArchived || p.excludeArchived {
+		for _, warning := range resolver.markArchived(ctx, actionInfos) {
+			fmt.Fprintf(stderr, "Warning: %v\n", warning)
+		}
+		warned := make(map[string]bool)
+		for i := range actionInfos {
+			info := &actionInfos[i]
+			name := info.Owner + "/" + info.Repo
+			if !info.Archived {
+				continue
+			}
+			if p.excludeArchived {
+				// Left untouched like a failed resolution, reported once per occurrence
+				fmt.Fprintf(stderr, "Warning: skipping %s (L%d:C%d): %s is archived (--exclude-archived-from-pin)\n",
+					occurrences[i].Action, occurrences[i].Line, occurrences[i].Column, name)
+				info.Error = fmt.Errorf("%s is archived; not pinning it", name)
+				continue
+			}
+			if !warned[name] {
+				warned[name] = true
+				fmt.Fprintf(stderr, "Warning: %s is archived (read-only and likely unmaintained)\n", name)
+			}
+		}
+	}
+
+	p.enforceAllowlist(name, occurrences, actionInfos)
+	if !p.opts.CommentOnly {
+		p.refuseDowngrades(occurrences, actionInfos)
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "%s %s\n", bold("Updating file"), name)
+
+	var updatedContent string
+	var edits []textEdit
+	if p.opts.CommentOnly {
+		var added, corrected int
+		updatedContent, added, corrected = updateComments(string(content), occurrences, actionInfos, p.style)
+		fmt.Fprintln(out)
+		printPlannedCommentChanges(out, occurrences, actionInfos, p.style)
+		fmt.Fprintf(out, "\n%s %d added, %d corrected\n", bold("Version comments:"), added, corrected)
+	} else {
+		edits = contentEdits(string(content), occurrences, actionInfos, p.style)
+		updatedContent = applyEdits(string(content), edits)
+
+		// Always show planned updates for a clear from → to view
+		fmt.Fprintln(out)
+		if p.groupByAction {
+			printGroupedPlannedChanges(out, occurrences, actionInfos, p.style)
+		} else {
+			printPlannedChanges(out, occurrences, actionInfos, p.style)
+		}
+		if containers > 0 {
+			var failed int
+			updatedContent, failed = p.pinContainers(ctx, updatedContent)
+			if code, stop := p.stopOnErrors(failed, name); stop {
+				return code
+			}
+		}
+	}
+
+	if p.alignComments {
+		updatedContent = alignComments(updatedContent)
+	}
+
+	if p.validate && updatedContent != string(content) {
+		if err := validateYAML(updatedContent); err != nil {
+			if p.errorFormat == errorFormatParseable {
+				line, msg := yamlErrorLine(err)
+				fmt.Fprintf(stderr, "%s:%d:1: updated content is not valid YAML, not writing: %s\n", name, line, msg)
+			} else {
+				fmt.Fprintf(stderr, "Error: updated %s is not valid YAML, not writing: %v\n", name, err)
+			}
+			return 1
+		}
+	}
+
+	// Dry-run: exit after preview without prompting or writing. Exit code 2 if changes would be made.
+	if p.dryRun {
+		if string(content) == updatedContent {
+			return 0
+		}
+		if p.commentChangesNoop && stripUsesComments(string(content)) == stripUsesComments(updatedContent) {
+			fmt.Fprintln(out, bold("\nComment-only changes:"), "no pinned refs would change; not counted as changes")
+			return 0
+		}
+		return 2
+	}
+
+	if string(content) == updatedContent {
+		return p.reportUpToDate(occurrences, actionInfos)
+	}
+
+	fmt.Fprintln(out)
+	if printsWorkflow {
+		printed = []byte(updatedContent)
+		if p.rangeFormat {
+			printed = formatEdits(name, edits)
+		}
+		fmt.Fprintf(out, "%s %s\n", bold("\nPinned"), name)
+		return 0
+	}
+	// If --yes is set, skip the prompt and apply immediately, unless --confirm-each-file
+	// asks for every file anyway
+	if p.confirmEachFile {
+		if !promptConfirmation(p.stdin, p.promptOut, bold("Apply changes to "+name+"?")+" "+promptHint(p.promptDefaultYes)+" ", p.promptDefaultYes) {
+			fmt.Fprintln(out, bold("\nNo changes applied to"), name)
+			return 0
+		}
+	} else if !p.nonInteractive {
+		if !promptConfirmation(p.stdin, p.promptOut, bold("Apply changes?")+" "+promptHint(p.promptDefaultYes)+" ", p.promptDefaultYes) {
+			fmt.Fprintln(out, bold("\nNo changes applied."))
+			return 0
+		}
+	}
+
+	err = os.WriteFile(workflowFile, []byte(updatedContent), 0644)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error writing file: %v\n", err)
+		return 1
+	}
+
+	return p.reportWritten(ctx, workflowFile, occurrences, actionInfos)
+}
+
+// streamThreshold is the size in bytes above which processFile streams a workflow file line
+// by line instead of reading it whole. Tests lower it.
+var streamThreshold int64 = 32 << 20
+
+// canStream reports whether the run's options work on a streamed file. Those that need the
+// whole content (--validate, --comment-alignment, --pin-containers, the --json report and the
+// like) keep large files in memory.
+func (p *pinner) canStream() bool {
+	return (p.dryRun || p.nonInteractive) && !p.confirmEachFile && !p.opts.CommentOnly && !p.validate && !p.alignComments &&
+		!p.containers && !p.jsonReport && !p.commentChangesNoop && !p.normalizeRefs &&
+		!p.warnArchived && !p.excludeArchived && p.attestation == "" && p.inputFormat == inputFormatAuto && !p.lintCheckout
+}
+
+// processLargeFile is processFile for a file over streamThreshold, which generated workflows
+// can reach: it is scanned and rewritten line by line, so memory does not grow with its size.
+// Occurrence offsets are relative to their line; a uses: value on the line after its key is
+// not found.
+func (p *pinner) processLargeFile(ctx context.Context, workflowFile string) int {
+	out, stderr := p.out, p.stderr
+	fmt.Fprintf(out, "\n%s %s (streamed line by line)\n\n", bold("Scanning workflow"), workflowFile)
+
+	occurrences, err := p.streamOccurrences(workflowFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading file: %v\n", err)
+		return 1
+	}
+	if len(occurrences) == 0 {
+		fmt.Fprintf(out, "%s No GitHub Actions references found in %s\n", bold("No actions:"), workflowFile)
+		return 0
+	}
+	fmt.Fprintln(out, bold("Discovered actions:\n"))
+	for _, action := range discoveredActions(nil, occurrences) {
+		fmt.Fprintf(out, "  - %s\n", action)
+	}
+	fmt.Fprintln(out)
+
+	occurrences, reason := p.skipOccurrences(occurrences)
+	if len(occurrences) == 0 {
+		fmt.Fprintln(out, bold("Nothing to pin:"), reason)
+		return 0
+	}
+
+	fmt.Fprintln(out, bold("Resolving latest versions and SHAs (parallel)...\n"))
+	resolver, err := p.getResolver(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	actionInfos := resolver.getActionInfosForOccurrences(ctx, occurrences)
+	p.progress.resolved(len(occurrences))
+	reportedOccs, reportedInfos := p.reported(occurrences, actionInfos)
+	printResolvedActions(out, reportedOccs, reportedInfos)
+	if p.errorFormat == errorFormatParseable {
+		printParseableFailures(stderr, workflowFile, occurrences, actionInfos)
+	} else {
+		printFailedActions(stderr, occurrences, actionInfos)
+	}
+	printFallbacks(stderr, occurrences, actionInfos)
+	if p.explainCacheKey {
+		printCacheKeys(stderr, occurrences, actionInfos)
+	}
+	if code, stop := p.stopOnErrors(countFailed(actionInfos), workflowFile); stop {
+		return code
+	}
+	p.enforceAllowlist(workflowFile, occurrences, actionInfos)
+	p.refuseDowngrades(occurrences, actionInfos)
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "%s %s\n", bold("Updating file"), workflowFile)
+	fmt.Fprintln(out)
+	if p.groupByAction {
+		printGroupedPlannedChanges(out, occurrences, actionInfos, p.style)
+	} else {
+		printPlannedChanges(out, occurrences, actionInfos, p.style)
+	}
+
+	if p.dryRun {
+		edits, err := streamEdits(workflowFile, io.Discard, occurrences, actionInfos, p.style)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading file: %v\n", err)
+			return 1
+		}
+		if edits == 0 {
+			return 0
+		}
+		return 2
+	}
+	edits, err := rewriteStreamed(workflowFile, occurrences, actionInfos, p.style)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error writing file: %v\n", err)
+		return 1
+	}
+	if edits == 0 {
+		return p.reportUpToDate(occurrences, actionInfos)
+	}
+	fmt.Fprintln(out)
+	return p.reportWritten(ctx, workflowFile, occurrences, actionInfos)
+}
+
+// streamOccurrences scans path line by line. Each occurrence's offsets are relative to its
+// line and Line is its line in the file.
+func (p *pinner) streamOccurrences(path string) ([]ActionOccurrence, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var occurrences []ActionOccurrence
+	err = eachLine(f, func(n int, line string) {
+		for _, occ := range scanOccurrences(line, p.scan) {
+			occ.Line = n
+			occurrences = append(occurrences, occ)
+		}
+		for _, occ := range miscasedUses(line, p.scan) {
+			key := usesKey(line, occ)
+			occ.Line = n
+			p.warnMiscased(path, occ, key)
+		}
+	})
+	return occurrences, err
+}
+
+// eachLine calls fn with each line of r, including its line ending, numbered from 1. Lines of
+// any length are read whole.
+func eachLine(r io.Reader, fn func(n int, line string)) error {
+	br := bufio.NewReaderSize(r, 64<<10)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			fn(n, line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// streamEdits copies path to w line by line, pinning the occurrences streamOccurrences found
+// on each line, and returns how many replacements it made.
+func streamEdits(path string, w io.Writer, occurrences []ActionOccurrence, actionInfos []ActionInfo, style CommentStyle) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	bw := bufio.NewWriterSize(w, 64<<10)
+	edits, next := 0, 0
+	var writeErr error
+	err = eachLine(f, func(n int, line string) {
+		first := next
+		for next < len(occurrences) && occurrences[next].Line == n {
+			next++
+		}
+		if first < next {
+			lineEdits := contentEdits(line, occurrences[first:next], actionInfos[first:next], style)
+			edits += len(lineEdits)
+			line = applyEdits(line, lineEdits)
+		}
+		if _, err := bw.WriteString(line); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if writeErr != nil {
+		return 0, writeErr
+	}
+	return edits, bw.Flush()
+}
+
+// rewriteStreamed pins path in place through streamEdits: the output goes to a temporary file
+// that replaces path only when something changed.
+func rewriteStreamed(path string, occurrences []ActionOccurrence, actionInfos []ActionInfo, style CommentStyle) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	edits, err := streamEdits(path, tmp, occurrences, actionInfos, style)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || edits == 0 {
+		return 0, err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return 0, err
+	}
+	return edits, os.Rename(tmp.Name(), path)
+}
+
+// warnMiscased warns about an occurrence under a uses: key spelled in another case, found in
+// file under key, and whether it is pinned anyway.
+func (p *pinner) warnMiscased(file string, occ ActionOccurrence, key string) {
+	outcome := "not pinned (use --case-insensitive-uses to pin it anyway)"
+	if p.scan.anyCaseUses {
+		outcome = "pinned anyway (--case-insensitive-uses)"
+	}
+	fmt.Fprintf(p.stderr, "Warning: %s (L%d:C%d): GitHub only accepts a lowercase uses: key, not %s; %s@%s %s\n",
+		file, occ.Line, occ.Column, key+":", occ.Action, occ.RequestedRef, outcome)
+}
+
+// skipOccurrences drops the occurrences excluded by --exclude-owners, --ignore and
+// --treat-exact-tags-as-pinned, and under --update-comment-only those not pinned to a SHA,
+// reporting each on p.out. When one of them leaves nothing to
+// pin, reason says which.
+func (p *pinner) skipOccurrences(occurrences []ActionOccurrence) (kept []ActionOccurrence, reason string) {
+	out := p.out
+	if len(p.excludeOwners) > 0 {
+		var skipped int
+		occurrences, skipped = excludeOwners(occurrences, p.excludeOwners, p.exactOwnerMatch)
+		if skipped > 0 {
+			fmt.Fprintf(out, "%s %d occurrence(s) owned by %s\n\n", bold("Skipping:"), skipped, strings.Join(p.excludeOwners, ", "))
+		}
+		if len(occurrences) == 0 {
+			return occurrences, "all actions belong to excluded owners."
+		}
+	}
+	if len(p.ignore) > 0 {
+		var skipped int
+		occurrences, skipped = ignoreActions(occurrences, p.ignore, p.exactOwnerMatch)
+		if skipped > 0 {
+			fmt.Fprintf(out, "%s %d occurrence(s) matching %s\n\n", bold("Ignoring:"), skipped, strings.Join(p.ignore, ", "))
+		}
+		if len(occurrences) == 0 {
+			return occurrences, "all actions are ignored."
+		}
+	}
+	if p.exactTagsPinned {
+		var skipped int
+		occurrences, skipped = excludeExactTags(occurrences)
+		if skipped > 0 {
+			fmt.Fprintf(out, "%s %d occurrence(s) already on an exact version tag\n\n", bold("Skipping:"), skipped)
+		}
+		if len(occurrences) == 0 {
+			return occurrences, "all actions use exact version tags."
+		}
+	}
+	if p.opts.CommentOnly {
+		var skipped int
+		occurrences, skipped = excludeUnpinned(occurrences)
+		if skipped > 0 {
+			fmt.Fprintf(out, "%s %d occurrence(s) not pinned to a commit SHA (--update-comment-only)\n\n", bold("Skipping:"), skipped)
+		}
+		if len(occurrences) == 0 {
+			return occurrences, "no actions are pinned to a commit SHA."
+		}
+	}
+	return occurrences, ""
+}
+
+// enforceAllowlist turns every resolution --owner-allowlist-file does not approve into an
+// error, leaving the occurrence unchanged, and records it in the audit log.
+func (p *pinner) enforceAllowlist(file string, occurrences []ActionOccurrence, actionInfos []ActionInfo) {
+	if p.audit == nil {
+		return
+	}
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) || actionInfos[i].Error != nil {
+			continue
+		}
+		info := &actionInfos[i]
+		slug := info.Owner + "/" + info.Repo
+		if isAllowed(p.allowlist, slug, *info, p.exactOwnerMatch) {
+			continue
+		}
+		fmt.Fprintf(p.stderr, "Warning: skipping %s (L%d:C%d): %s@%s is not in the allowlist (--owner-allowlist-file)\n",
+			occ.Action, occ.Line, occ.Column, slug, info.Version)
+		err := p.audit.record(auditEntry{
+			Time: time.Now().UTC(), User: p.audit.user, File: file, Line: occ.Line, Column: occ.Column,
+			Action: occ.Action, Ref: occ.RequestedRef, Version: info.Version, SHA: info.SHA,
+		})
+		if err != nil {
+			fmt.Fprintf(p.stderr, "Error: could not write --audit-log: %v\n", err)
+		}
+		info.Error = fmt.Errorf("%s@%s is not in the allowlist", slug, info.Version)
+	}
+}
+
+// refuseDowngrades turns every resolution older than its occurrence's current version into an
+// error, leaving it unchanged, unless --allow-downgrade is set.
+func (p *pinner) refuseDowngrades(occurrences []ActionOccurrence, actionInfos []ActionInfo) {
+	if p.allowDowngrade {
+		return
+	}
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) || !isDowngrade(occ, actionInfos[i], p.style) {
+			continue
+		}
+		fmt.Fprintf(p.stderr, "Warning: skipping %s (L%d:C%d): resolved %s is older than current %s (use --allow-downgrade to pin it anyway)\n",
+			occ.Action, occ.Line, occ.Column, actionInfos[i].Version, currentVersion(occ, p.style))
+		actionInfos[i].Error = fmt.Errorf("refusing to downgrade from %s to %s", currentVersion(occ, p.style), actionInfos[i].Version)
+	}
+}
+
+// reportUpToDate reports a file that needs no changes.
+func (p *pinner) reportUpToDate(occurrences []ActionOccurrence, actionInfos []ActionInfo) int {
+	fmt.Fprintln(p.out)
+	fmt.Fprintln(p.out, bold("\nUp to date:"), "All actions are already pinned to the latest versions.")
+	if p.printFinal != nil {
+		_, reported := p.reported(occurrences, actionInfos)
+		p.printFinal(p.stdout, reported)
+	}
+	return 0
+}
+
+// reportWritten runs --post-write-cmd for a file just written and reports its pins.
+func (p *pinner) reportWritten(ctx context.Context, workflowFile string, occurrences []ActionOccurrence, actionInfos []ActionInfo) int {
+	out := p.out
+	fmt.Fprintf(out, "%s %s\n", bold("\nUpdated file"), workflowFile)
+	if p.postWriteCmd != "" {
+		if err := p.runPostWrite(ctx, workflowFile); err != nil {
+			fmt.Fprintf(p.stderr, "Error: --post-write-cmd for %s: %v\n", workflowFile, err)
+			return 1
+		}
+	}
+	_, reported := p.reported(occurrences, actionInfos)
+	if p.printFinal != nil {
+		p.printFinal(p.stdout, reported)
+		return 0
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, bold("Pinned actions:\n"))
+	for _, info := range reported {
+		if info.Error == nil {
+			fmt.Fprintf(out, "  %s/%s@%s # %s%s\n", info.Owner, info.Repo, info.SHA, info.Version, archivedNote(info))
+		}
+	}
+	return 0
+}
+
+// dryRunSchemaVersion is the schemaVersion of the --json report. It is bumped when a field is
+// removed, renamed or changes meaning; new fields are added without a bump.
+const dryRunSchemaVersion = 1
+
+// dryRunReport is the --dry-run --json document for one workflow file.
+type dryRunReport struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	File          string          `json:"file"`
+	Changes       []plannedChange `json:"changes"`
+	Failures      []failedRef     `json:"failures"`
+	Unpinnable    []unpinnableRef `json:"unpinnable"`
+}
+
+// plannedChange is one occurrence whose ref would be replaced.
+type plannedChange struct {
+	Action  string `json:"action"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Version string `json:"version"`
+	SHA     string `json:"sha"`
+	// ResolvedVia is the resolution step the SHA came from, e.g. latest-release or same-major.
+	ResolvedVia string `json:"resolvedVia,omitempty"`
+}
+
+// failedRef is one occurrence that could not be resolved and is left unchanged.
+type failedRef struct {
+	Action string `json:"action"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Ref    string `json:"ref"`
+	Error  string `json:"error"`
+}
+
+// newDryRunReport collects the same changes printPlannedChanges lists, plus the failures.
+func newDryRunReport(file string, occurrences []ActionOccurrence, actionInfos []ActionInfo, style CommentStyle) dryRunReport {
+	report := dryRunReport{SchemaVersion: dryRunSchemaVersion, File: file, Changes: []plannedChange{}, Failures: []failedRef{}, Unpinnable: []unpinnableRef{}}
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) {
+			continue
+		}
+		info := actionInfos[i]
+		if info.Error != nil {
+			report.Failures = append(report.Failures, failedRef{
+				Action: occ.Action, Line: occ.Line, Column: occ.Column, Ref: occ.RequestedRef, Error: describeResolveError(info.Error),
+			})
+			continue
+		}
+		if !isPlannedChange(occ, info, style) {
+			continue
+		}
+		to, _ := style.pin(occ, info)
+		report.Changes = append(report.Changes, plannedChange{
+			Action: occ.Action, Line: occ.Line, Column: occ.Column, From: occ.RequestedRef, To: to, Version: info.Version, SHA: info.SHA,
+			ResolvedVia: info.ResolvedVia,
+		})
+	}
+	return report
+}
+
+// writeDryRunReport writes report as a single line of JSON, so several files form JSON Lines,
+// or indented over several lines when pretty is set.
+func writeDryRunReport(w io.Writer, report dryRunReport, pretty bool) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(report)
+}
+
+// runPostWrite runs --post-write-cmd for a written file. Without --post-write-shell the command
+// is split on whitespace and {file} is substituted into the arguments, so the path is never
+// interpreted by a shell; with it, the command runs under sh -c with the path single-quoted.
+func (p *pinner) runPostWrite(ctx context.Context, file string) error {
+	var cmd *exec.Cmd
+	if p.postWriteShell {
+		cmd = exec.CommandContext(ctx, "sh", "-c", strings.ReplaceAll(p.postWriteCmd, "{file}", shellQuote(file)))
+	} else {
+		args := strings.Fields(p.postWriteCmd)
+		for i := range args {
+			args[i] = strings.ReplaceAll(args[i], "{file}", file)
+		}
+		cmd = exec.CommandContext(ctx, args[0], args[1:]...)
+	}
+	cmd.Stdout, cmd.Stderr = p.out, p.stderr
+	return cmd.Run()
+}
+
+// shellQuote quotes s as a single sh word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// archivedNote marks archived actions in human-readable summaries.
+func archivedNote(info ActionInfo) string {
+	if info.Archived {
+		return " (archived)"
+	}
+	return ""
+}
+
+// readPinFile parses a list of owner/repo@ref lines. Blank lines and lines starting with # are
+// ignored; occurrence line numbers refer to the list file.
+func readPinFile(path string) ([]ActionOccurrence, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var occurrences []ActionOccurrence
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		found := extractOccurrences("uses: " + line)
+		if len(found) != 1 || found[0].RequestedRef == "" {
+			return nil, fmt.Errorf("%s:%d: expected owner/repo@ref, got %q", path, i+1, line)
+		}
+		occ := found[0]
+		occ.Line, occ.Column = i+1, 1
+		occurrences = append(occurrences, occ)
+	}
+	return occurrences, nil
+}
+
+// processPinFile resolves the references listed in a --pin-file and prints them in the
+// run's output format (pin lines by default), without touching any workflow.
+func (p *pinner) processPinFile(ctx context.Context, path string) int {
+	occurrences, err := readPinFile(path)
+	if err != nil {
+		fmt.Fprintf(p.stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(occurrences) == 0 {
+		fmt.Fprintf(p.stderr, "Error: no action references in %s\n", path)
+		return 1
+	}
+	resolver, err := p.getResolver(ctx)
+	if err != nil {
+		fmt.Fprintf(p.stderr, "Error: %v\n", err)
+		return 1
+	}
+	actionInfos := resolver.getActionInfosForOccurrences(ctx, occurrences)
+
+	exitCode := 0
+	if countFailed(actionInfos) > 0 {
+		exitCode = 1
+	}
+	if p.errorFormat == errorFormatParseable {
+		printParseableFailures(p.stderr, path, occurrences, actionInfos)
+	} else {
+		for i, info := range actionInfos {
+			if info.Error != nil {
+				fmt.Fprintf(p.stderr, "Error: %s:%d: %s: %v\n", path, occurrences[i].Line, occurrences[i].Action, info.Error)
+			}
+		}
+	}
+	printFinal := p.printFinal
+	if printFinal == nil {
+		printFinal = printPinnedActions
+	}
+	_, reported := p.reported(occurrences, actionInfos)
+	printFinal(p.stdout, reported)
+	return exitCode
+}
+
+// Input formats (--input-format): what kind of file a path is expected to be.
+const (
+	inputFormatAuto     = "auto"     // detect from the top-level keys
+	inputFormatWorkflow = "workflow" // a workflow, with top-level jobs
+	inputFormatAction   = "action"   // an action metadata file (action.yml), with top-level runs
+)
+
+// Error formats (--error-format): how errors tied to a position in a file are printed.
+const (
+	errorFormatPlain     = "plain"     // grouped, human-readable sections
+	errorFormatParseable = "parseable" // one file:line:col: message line each, for quickfix lists
+)
+
+func parseErrorFormat(value string) (string, error) {
+	switch format := strings.ToLower(strings.TrimSpace(value)); format {
+	case errorFormatPlain, errorFormatParseable:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid --error-format %q, want plain or parseable", value)
+}
+
+func parseInputFormat(value string) (string, error) {
+	switch format := strings.ToLower(strings.TrimSpace(value)); format {
+	case inputFormatAuto, inputFormatWorkflow, inputFormatAction:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid --input-format %q, want workflow, action or auto", value)
+}
+
+// topLevelKeys returns the top-level mapping of content's first YAML document, or nil when it
+// does not parse as a mapping.
+func topLevelKeys(content string) map[string]any {
+	var doc map[string]any
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		return nil
+	}
+	return doc
+}
+
+// detectInputFormat tells workflows (top-level jobs) from action metadata (top-level runs),
+// returning "" when content looks like neither.
+func detectInputFormat(content string) string {
+	doc := topLevelKeys(content)
+	switch {
+	case doc["runs"] != nil:
+		return inputFormatAction
+	case doc["jobs"] != nil:
+		return inputFormatWorkflow
+	}
+	return ""
+}
+
+// checkInputFormat reports an error when content lacks the top-level structure of format.
+func checkInputFormat(content, format string) error {
+	doc := topLevelKeys(content)
+	switch format {
+	case inputFormatWorkflow:
+		if _, ok := doc["jobs"].(map[string]any); !ok {
+			return fmt.Errorf("not a workflow: no top-level jobs mapping")
+		}
+	case inputFormatAction:
+		if _, ok := doc["runs"].(map[string]any); !ok {
+			return fmt.Errorf("not an action: no top-level runs mapping")
+		}
+	}
+	return nil
+}
+
+// noActionsMessage explains why a file of the given format has nothing to pin.
+func noActionsMessage(content, format, name string) string {
+	switch format {
+	case inputFormatWorkflow:
+		return fmt.Sprintf("No GitHub Actions references found in the steps of workflow %s", name)
+	case inputFormatAction:
+		runs, _ := topLevelKeys(content)["runs"].(map[string]any)
+		if using, _ := runs["using"].(string); using != "" && using != "composite" {
+			return fmt.Sprintf("%s is a %s action; only composite actions use other actions", name, using)
+		}
+		return fmt.Sprintf("No GitHub Actions references found in the steps of composite action %s", name)
+	}
+	return fmt.Sprintf("No GitHub Actions references found in %s", name)
+}
+
+// validateYAML checks that every document in content still parses as YAML.
+func validateYAML(content string) error {
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkoutFinding is a --lint-checkout advisory for an actions/checkout step, positioned at
+// the step's first key.
+type checkoutFinding struct {
+	Line, Column int
+	Message      string
+}
+
+// lintCheckout finds the actions/checkout steps in content that keep the token in the
+// repository's git config: persist-credentials is set to true, or left out and so defaults to
+// true. Any step that runs later in the job can then read the token from .git/config. Values
+// given as an expression are not judged.
+func lintCheckout(content string) []checkoutFinding {
+	var findings []checkoutFinding
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n.Kind == yaml.MappingNode {
+			if f, ok := checkoutStep(n); ok {
+				findings = append(findings, f)
+			}
+		}
+		for _, child := range n.Content {
+			walk(child)
+		}
+	}
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			return findings
+		}
+		walk(&doc)
+	}
+}
+
+// checkoutStep returns the finding for step when it is an actions/checkout step that
+// persists credentials.
+func checkoutStep(step *yaml.Node) (checkoutFinding, bool) {
+	var uses string
+	var with *yaml.Node
+	for i := 0; i+1 < len(step.Content); i += 2 {
+		switch key, value := step.Content[i], step.Content[i+1]; key.Value {
+		case "uses":
+			uses = value.Value
+		case "with":
+			with = value
+		}
+	}
+	_, slug := splitHost(uses)
+	if !strings.HasPrefix(strings.ToLower(slug), "actions/checkout@") {
+		return checkoutFinding{}, false
+	}
+	finding := checkoutFinding{Line: step.Line, Column: step.Column}
+	if with != nil && with.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(with.Content); i += 2 {
+			if with.Content[i].Value != "persist-credentials" {
+				continue
+			}
+			// false, or an expression decided at run time, is left alone
+			if !strings.EqualFold(strings.TrimSpace(with.Content[i+1].Value), "true") {
+				return checkoutFinding{}, false
+			}
+			finding.Message = "persist-credentials: true keeps the token in .git/config for every later step; set it to false unless a later step pushes"
+			return finding, true
+		}
+	}
+	finding.Message = "persist-credentials defaults to true, keeping the token in .git/config for every later step; set persist-credentials: false unless a later step pushes"
+	return finding, true
+}
+
+// printCheckoutLint prints the --lint-checkout advisories.
+func printCheckoutLint(w io.Writer, findings []checkoutFinding) {
+	for _, f := range findings {
+		fmt.Fprintf(w, "Warning: actions/checkout (L%d:C%d): %s\n", f.Line, f.Column, f.Message)
+	}
+}
+
+// printPinnedActions writes the canonical owner/repo@sha # version line for every resolved action.
+func printPinnedActions(w io.Writer, actionInfos []ActionInfo) {
+	for _, info := range actionInfos {
+		if info.Error == nil {
+			fmt.Fprintf(w, "%s/%s@%s # %s\n", info.Owner, info.Repo, info.SHA, info.Version)
+		}
+	}
+}
+
+// printSHAs writes one tab-separated "owner/repo sha version" line per resolved occurrence,
+// for awk/cut pipelines.
+func printSHAs(w io.Writer, actionInfos []ActionInfo) {
+	for _, info := range actionInfos {
+		if info.Error == nil {
+			fmt.Fprintf(w, "%s/%s\t%s\t%s\n", info.Owner, info.Repo, info.SHA, info.Version)
+		}
+	}
+}
+
+// printEnvExports writes one NAME=sha line per resolved action, for `eval` or $GITHUB_ENV.
+// When several occurrences map to the same name the first one wins.
+func printEnvExports(w io.Writer, actionInfos []ActionInfo) {
+	seen := make(map[string]bool)
+	for _, info := range actionInfos {
+		if info.Error != nil {
+			continue
+		}
+		name := envName(info.Owner + "/" + info.Repo)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		fmt.Fprintf(w, "%s=%s\n", name, info.SHA)
+	}
+}
+
+// envName turns an action slug into a valid environment variable name: PIN_ followed by the
+// slug with every character outside [A-Za-z0-9_] replaced by an underscore.
+func envName(action string) string {
+	var b strings.Builder
+	b.WriteString("PIN_")
+	for _, c := range action {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// isLocalActionPath reports whether a uses: value is a path to an action in the repository
+// itself (./dir, ../dir, or a Windows-style .\dir) rather than owner/repo. Backslashes never
+// occur in owner or repository names, so any value containing one is treated as a path.
+func isLocalActionPath(action string) bool {
+	return strings.HasPrefix(action, "./") || strings.HasPrefix(action, "../") || strings.Contains(action, "\\")
+}
+
+// isContainerRef reports whether a uses: value runs a container image (docker://...).
+func isContainerRef(action string) bool {
+	return strings.HasPrefix(action, "docker://")
+}
+
+// Reason codes of references the tool cannot pin, reported in the unpinnable list.
+const (
+	unpinnableLocal      = "local"      // ./path: an action in the repository itself
+	unpinnableContainer  = "container"  // docker://image:tag, unless --pin-containers pins it
+	unpinnableExpression = "expression" // ${{ ... }}: only known when the workflow runs
+	unpinnableNoRef      = "no-ref"     // owner/repo without an @ref
+)
+
+// unpinnableRef is a uses: value the tool leaves alone, with the reason code why.
+type unpinnableRef struct {
+	Uses   string `json:"uses"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Reason string `json:"reason"`
+}
+
+// findUnpinnable lists the uses: values that are not pinnable action references: local
+// actions, container images (except those --pin-containers pins, given pinContainers), dynamic
+// expressions and references without an @ref. Container images already pinned to a digest
+// are not listed.
+func findUnpinnable(content string, opts scanOptions, pinContainers bool) []unpinnableRef {
+	var refs []unpinnableRef
+	for _, idxs := range usesValueRe.match(opts).FindAllStringSubmatchIndex(content, -1) {
+		if !opts.includeCommented && commentedOut(content, idxs[0]) {
+			continue
+		}
+		value := content[idxs[2]:idxs[3]]
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		var reason string
+		switch {
+		case value == "":
+			continue
+		case strings.Contains(value, "${{"):
+			reason = unpinnableExpression
+		case isLocalActionPath(value):
+			reason = unpinnableLocal
+		case isContainerRef(value):
+			if strings.Contains(value, "@sha256:") || pinContainers && len(extractContainerOccurrences("uses: "+value)) > 0 {
+				continue
+			}
+			reason = unpinnableContainer
+		case !strings.Contains(value, "@"):
+			reason = unpinnableNoRef
+		default:
+			continue
+		}
+		line, col := computeLineCol(content, idxs[2])
+		refs = append(refs, unpinnableRef{Uses: value, Line: line, Column: col, Reason: reason})
+	}
+	return refs
+}
+
+// printUnpinnable lists the references findUnpinnable found, with their reason codes.
+func printUnpinnable(w io.Writer, refs []unpinnableRef) {
+	if len(refs) == 0 {
+		return
+	}
+	fmt.Fprintln(w, bold("Unpinnable references:\n"))
+	for _, ref := range refs {
+		fmt.Fprintf(w, "  - %s (L%d:C%d): %s\n", ref.Uses, ref.Line, ref.Column, ref.Reason)
+	}
+	fmt.Fprintln(w)
+}
+
+// ContainerOccurrence is a `uses: docker://ghcr.io/<image>:<tag>` entry that can be pinned to
+// a digest. ReplaceStart is the ':' before the tag; ReplaceEnd covers any trailing comment.
+type ContainerOccurrence struct {
+	Image        string // path on ghcr.io, e.g. owner/image
+	Tag          string
+	ReplaceStart int
+	ReplaceEnd   int
+	Line         int
+	Column       int
+}
+
+// extractContainerOccurrences finds GHCR image references pinned to a tag. References that
+// already carry a digest, or have no tag, are left out.
+func extractContainerOccurrences(content string) []ContainerOccurrence {
+	return scanContainerOccurrences(content, scanOptions{})
+}
+
+// scanContainerOccurrences is extractContainerOccurrences, widened by opts as scanOccurrences is.
+func scanContainerOccurrences(content string, opts scanOptions) []ContainerOccurrence {
+	var occurrences []ContainerOccurrence
+	for _, idxs := range containerRe.match(opts).FindAllStringSubmatchIndex(content, -1) {
+		if !opts.includeCommented && commentedOut(content, idxs[0]) {
+			continue
+		}
+		ref := content[idxs[4]:idxs[5]]
+		if !strings.HasPrefix(ref, ":") || strings.Contains(ref, "@") || len(ref) < 2 {
+			continue
+		}
+		line, col := computeLineCol(content, idxs[2])
+		occurrences = append(occurrences, ContainerOccurrence{
+			Image:        content[idxs[2]:idxs[3]],
+			Tag:          ref[1:],
+			ReplaceStart: idxs[4],
+			ReplaceEnd:   idxs[1],
+			Line:         line,
+			Column:       col,
+		})
+	}
+	return occurrences
+}
+
+// ghcrBaseURL is the GitHub Container Registry endpoint; tests point it at a fake registry.
+var ghcrBaseURL = "https://ghcr.io"
+
+// manifestMediaTypes are accepted when looking up a digest, multi-arch indexes first so that
+// the pin covers every platform.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+var digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// ghcrRegistry resolves GHCR image tags to manifest digests. GHCR accepts GitHub tokens, so
+// the same token that talks to the API works here; lookups are memoized per image:tag.
+type ghcrRegistry struct {
+	client  *http.Client
+	baseURL string
+	token   string
+
+	mu      sync.Mutex
+	digests map[string]string
+}
+
+func newGHCRRegistry(token string) *ghcrRegistry {
+	return &ghcrRegistry{client: http.DefaultClient, baseURL: ghcrBaseURL, token: token, digests: make(map[string]string)}
+}
+
+// digest returns the sha256 digest the tag currently points to.
+func (g *ghcrRegistry) digest(ctx context.Context, image, tag string) (string, error) {
+	key := image + ":" + tag
+	g.mu.Lock()
+	d, ok := g.digests[key]
+	g.mu.Unlock()
+	if ok {
+		return d, nil
+	}
+
+	bearer, err := g.pullToken(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.baseURL+"/v2/"+image+"/manifests/"+tag, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	req.Header.Set("Authorization", "Bearer "+bearer)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s for GET %s", resp.Status, req.URL)
+	}
+	d = resp.Header.Get("Docker-Content-Digest")
+	if d == "" {
+		// The digest is the hash of the manifest exactly as served
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		d = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+	if !digestPattern.MatchString(d) {
+		return "", fmt.Errorf("unexpected digest %q for %s", d, key)
+	}
+	g.mu.Lock()
+	g.digests[key] = d
+	g.mu.Unlock()
+	return d, nil
+}
+
+// pullToken exchanges the GitHub token (or nothing, for public images) for a registry token
+// scoped to pulling image.
+func (g *ghcrRegistry) pullToken(ctx context.Context, image string) (string, error) {
+	u := g.baseURL + "/token?service=ghcr.io&scope=" + url.QueryEscape("repository:"+image+":pull")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	if g.token != "" {
+		req.SetBasicAuth("token", g.token)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s for GET %s", resp.Status, req.URL.Redacted())
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Token == "" {
+		return "", fmt.Errorf("no registry token in response from %s", g.baseURL)
+	}
+	return body.Token, nil
+}
+
+// updateContainers rewrites each resolved image tag to `@<digest> # <tag>` (just `@<digest>`
+// with --comment-style none-strip); digests holds the digest per occurrence, with "" for
+// occurrences to leave alone.
+func updateContainers(content string, occurrences []ContainerOccurrence, digests []string, style CommentStyle) string {
+	var b strings.Builder
+	prev := 0
+	for i, occ := range occurrences {
+		if i >= len(digests) || digests[i] == "" || occ.ReplaceStart < prev || occ.ReplaceEnd > len(content) {
+			continue
+		}
+		b.WriteString(content[prev:occ.ReplaceStart])
+		b.WriteString("@" + digests[i] + style.trailer("docker://ghcr.io/"+occ.Image, occ.Tag))
+		prev = occ.ReplaceEnd
+	}
+	b.WriteString(content[prev:])
+	return b.String()
+}
+
+// getRegistry returns the run's GHCR client, creating it on first use.
+func (p *pinner) getRegistry() *ghcrRegistry {
+	if p.registry == nil {
+		token := p.opts.RegistryToken
+		if token == "" {
+			// GHCR accepts GitHub tokens; without any token public images still resolve
+			token, _ = getGitHubToken(p.stdinToken)
+		}
+		p.registry = newGHCRRegistry(token)
+	}
+	return p.registry
+}
+
+// pinContainers resolves the GHCR image tags in content to digests and returns the rewritten
+// content. Failures are reported and leave the reference unchanged.
+func (p *pinner) pinContainers(ctx context.Context, content string) (string, int) {
+	occurrences := scanContainerOccurrences(content, p.scan)
+	if len(occurrences) == 0 {
+		return content, 0
+	}
+	registry := p.getRegistry()
+
+	fmt.Fprintln(p.out, bold("\nContainer images:\n"))
+	digests := make([]string, len(occurrences))
+	failed := 0
+	for i, occ := range occurrences {
+		d, err := registry.digest(ctx, occ.Image, occ.Tag)
+		if err != nil {
+			fmt.Fprintf(p.stderr, "Error: ghcr.io/%s:%s (L%d:C%d): %v\n", occ.Image, occ.Tag, occ.Line, occ.Column, err)
+			failed++
+			continue
+		}
+		digests[i] = d
+		fmt.Fprintf(p.out, "  ghcr.io/%s:%s -> %s\n", occ.Image, occ.Tag, d)
+	}
+	return updateContainers(content, occurrences, digests, p.style), failed
+}
+
+// On-error modes (--on-error) decide what happens when references fail to resolve.
+const (
+	onErrorContinue = "continue"  // leave failed references unchanged, write the rest
+	onErrorAbort    = "abort"     // stop the whole run at the first failure, writing nothing more
+	onErrorSkipFile = "skip-file" // leave a file with any failure unchanged, go on with the others
+)
+
+func parseOnError(value string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case onErrorContinue, onErrorAbort, onErrorSkipFile:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid --on-error %q, want continue, abort or skip-file", value)
+}
+
+// parsePromptDefault parses --prompt-default and reports whether Enter means yes.
+func parsePromptDefault(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "y":
+		return true, nil
+	case "no", "n":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid --prompt-default %q, want yes or no", value)
+}
+
+func countFailed(actionInfos []ActionInfo) int {
+	failed := 0
+	for _, info := range actionInfos {
+		if info.Error != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// stopOnErrors reports whether processing of file must stop because failed references
+// failed to resolve under the --on-error mode, and with which exit code.
+func (p *pinner) stopOnErrors(failed int, file string) (int, bool) {
+	if failed == 0 || p.onError == onErrorContinue {
+		return 0, false
+	}
+	fmt.Fprintf(p.stderr, "Error: %d reference(s) failed to resolve; leaving %s unchanged (--on-error %s)\n", failed, file, p.onError)
+	p.unresolved = true
+	return 1, true
+}
+
+// scanOptions widens what counts as a uses: reference beyond extractActions/extractOccurrences.
+type scanOptions struct {
+	includeCommented bool // --include-commented: also in commented-out lines
+	anyCaseUses      bool // --case-insensitive-uses: also under Uses:, USES: and the like
+}
+
+// usesPattern is the uses: key, in any letter case when anyCaseUses is set.
+func (o scanOptions) usesPattern() string {
+	if o.anyCaseUses {
+		return `(?i:uses):`
+	}
+	return `uses:`
+}
+
+// occurrenceTail matches what follows the uses: key of an occurrence: owner/repo, @ref, an
+// optional closing quote and trailing comment.
+const occurrenceTail = `\s+["']?([^@/\s"']+/[^@\s"']+)@([^\s#"']+)(["']?)([ \t]*#[^\r\n]*)?[ \t]*`
+
+// usesRegexp is a pattern that follows the uses: key, compiled once for each letter case
+// scanOptions can ask for, since streamed files are scanned line by line.
+type usesRegexp struct {
+	exact, anyCase *regexp.Regexp
+}
+
+func compileUsesRegexp(tail string) usesRegexp {
+	return usesRegexp{
+		exact:   regexp.MustCompile(scanOptions{}.usesPattern() + tail),
+		anyCase: regexp.MustCompile(scanOptions{anyCaseUses: true}.usesPattern() + tail),
+	}
+}
+
+// match returns the pattern compiled for o.
+func (u usesRegexp) match(o scanOptions) *regexp.Regexp {
+	if o.anyCaseUses {
+		return u.anyCase
+	}
+	return u.exact
+}
+
+// The patterns of scanOccurrences, scanActions, findUnpinnable and scanContainerOccurrences.
+var (
+	occurrenceRe = compileUsesRegexp(occurrenceTail)
+	actionRe     = compileUsesRegexp(`\s+["']?([^@/\s"']+/[^@\s"']+)`)
+	usesValueRe  = compileUsesRegexp(`[ \t]+([^\r\n]*)`)
+	containerRe  = compileUsesRegexp(`\s+docker://ghcr\.io/([^\s#:@]+)([^\s#]*)([ \t]*#[^\r\n]*)?[ \t]*`)
+)
+
+func extractActions(content string) []string {
+	return scanActions(content, scanOptions{})
+}
+
+// scanActions is extractActions, widened by opts.
+func scanActions(content string, opts scanOptions) []string {
+	// Preserve order of first appearance while de-duplicating
+	matches := actionRe.match(opts).FindAllStringSubmatchIndex(content, -1)
+
+	seen := make(map[string]bool)
+	actions := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if len(match) < 4 || (!opts.includeCommented && commentedOut(content, match[0])) {
+			continue
+		}
+		action := content[match[2]:match[3]]
+		if isLocalActionPath(action) || isContainerRef(action) || seen[action] {
+			continue
+		}
+		seen[action] = true
+		actions = append(actions, action)
+	}
+	return actions
+}
+
+// discoveredActions orders the distinct actions of a file the way the resolution output lists
+// them: by first occurrence, however the parallel resolutions complete. Actions that only appear
+// without an @ref, and so are never resolved, follow in order of appearance.
+func discoveredActions(actions []string, occurrences []ActionOccurrence) []string {
+	seen := make(map[string]bool, len(actions))
+	ordered := make([]string, 0, len(actions))
+	for _, occ := range occurrences {
+		if !seen[occ.Action] {
+			seen[occ.Action] = true
+			ordered = append(ordered, occ.Action)
+		}
+	}
+	for _, action := range actions {
+		if !seen[action] {
+			seen[action] = true
+			ordered = append(ordered, action)
+		}
+	}
+	return ordered
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// isExactSemverTag reports whether ref is a complete semver version such as v4.2.2 or
+// 1.0.0-rc.1, as opposed to a moving major/minor tag, branch or SHA.
+func isExactSemverTag(ref string) bool {
+	_, err := semver.StrictNewVersion(strings.TrimPrefix(ref, "v"))
+	return err == nil
+}
+
+// excludeExactTags drops occurrences pinned to an exact semver tag and reports how many
+// were dropped.
+func excludeExactTags(occurrences []ActionOccurrence) ([]ActionOccurrence, int) {
+	kept := make([]ActionOccurrence, 0, len(occurrences))
+	for _, occ := range occurrences {
+		if isExactSemverTag(occ.RequestedRef) {
+			continue
+		}
+		kept = append(kept, occ)
+	}
+	return kept, len(occurrences) - len(kept)
+}
+
+// excludeUnpinned drops occurrences not pinned to a full commit SHA, which --update-comment-only
+// leaves alone, and reports how many were dropped.
+func excludeUnpinned(occurrences []ActionOccurrence) ([]ActionOccurrence, int) {
+	kept := make([]ActionOccurrence, 0, len(occurrences))
+	for _, occ := range occurrences {
+		if isFullSHA(occ.RequestedRef) {
+			kept = append(kept, occ)
+		}
+	}
+	return kept, len(occurrences) - len(kept)
+}
+
+// excludeOwners drops occurrences whose owner is in owners and reports how many were dropped.
+// Owners match regardless of case, as on GitHub, unless caseSensitive is set.
+func excludeOwners(occurrences []ActionOccurrence, owners []string, caseSensitive bool) ([]ActionOccurrence, int) {
+	fold := strings.ToLower
+	if caseSensitive {
+		fold = func(s string) string { return s }
+	}
+	excluded := make(map[string]bool, len(owners))
+	for _, owner := range owners {
+		excluded[fold(owner)] = true
+	}
+	kept := make([]ActionOccurrence, 0, len(occurrences))
+	for _, occ := range occurrences {
+		if excluded[fold(occ.Owner)] {
+			continue
+		}
+		kept = append(kept, occ)
+	}
+	return kept, len(occurrences) - len(kept)
+}
+
+// ignoreActions drops occurrences whose owner/repo matches any of the globs and reports how
+// many were dropped. Matching ignores case, as GitHub does for repository names, unless
+// caseSensitive is set.
+func ignoreActions(occurrences []ActionOccurrence, patterns []string, caseSensitive bool) ([]ActionOccurrence, int) {
+	kept := make([]ActionOccurrence, 0, len(occurrences))
+	for _, occ := range occurrences {
+		if !matchesAny(occ.Owner+"/"+occ.Repo, patterns, caseSensitive) {
+			kept = append(kept, occ)
+		}
+	}
+	return kept, len(occurrences) - len(kept)
+}
+
+// matchesAny reports whether slug matches any of the globs, regardless of case unless
+// caseSensitive is set.
+func matchesAny(slug string, patterns []string, caseSensitive bool) bool {
+	if !caseSensitive {
+		slug = strings.ToLower(slug)
+	}
+	for _, pattern := range patterns {
+		if !caseSensitive {
+			pattern = strings.ToLower(pattern)
+		}
+		if ok, _ := path.Match(pattern, slug); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// extractOccurrences finds each `uses: owner/repo@ref` occurrence along with positions.
+// The match extends over a trailing comment and any trailing whitespace on the same line
+// (but never a CR of a CRLF line ending), so a rewrite leaves no stray whitespace behind.
+// Quoted values (`uses: "owner/repo@ref"`) are matched without their quotes.
+func extractOccurrences(content string) []ActionOccurrence {
+	return scanOccurrences(content, scanOptions{})
+}
+
+// scanOccurrences is extractOccurrences, also matching `uses:` in commented-out lines
+// (`# - uses: owner/repo@ref`) when opts.includeCommented is set (--include-commented) and
+// miscased keys such as `Uses:` when opts.anyCaseUses is set (--case-insensitive-uses).
+func scanOccurrences(content string, opts scanOptions) []ActionOccurrence {
+	indices := occurrenceRe.match(opts).FindAllStringSubmatchIndex(content, -1)
+	occurrences := make([]ActionOccurrence, 0, len(indices))
+	lines := newLineCounter(content)
+
+	for _, idxs := range indices {
+		if len(idxs) < 6 {
+			continue
+		}
+		matchStart, matchEnd := idxs[0], idxs[1]
+		if !opts.includeCommented && commentedOut(content, matchStart) {
+			continue
+		}
+		ownerRepoStart, ownerRepoEnd := idxs[2], idxs[3]
+		refStart, refEnd := idxs[4], idxs[5]
+		action := content[ownerRepoStart:ownerRepoEnd]
+		if isLocalActionPath(action) || isContainerRef(action) {
+			continue
+		}
+		host, slug := splitHost(action)
+		parts := strings.SplitN(slug, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		owner, repo := parts[0], parts[1]
+		requestedRef := normalizeRef(content[refStart:refEnd])
+		if requestedRef == "" {
+			continue
+		}
+		comment := ""
+		if len(idxs) >= 10 && idxs[8] >= 0 {
+			comment = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(content[idxs[8]:idxs[9]]), "#"))
+		}
+		// '@' should be right after ownerRepoEnd
+		replaceStart := ownerRepoEnd
+		replaceEnd := matchEnd
+
+		line, col := lines.at(ownerRepoStart)
+
+		occurrences = append(occurrences, ActionOccurrence{
+			Owner:        owner,
+			Repo:         repo,
+			Action:       action,
+			Host:         host,
+			RequestedRef: requestedRef,
+			Comment:      comment,
+			Quote:        content[idxs[6]:idxs[7]],
+			MatchStart:   matchStart,
+			MatchEnd:     matchEnd,
+			ReplaceStart: replaceStart,
+			ReplaceEnd:   replaceEnd,
+			RefEnd:       refEnd,
+			Line:         line,
+			Column:       col,
+		})
+	}
+	return occurrences
+}
+
+// splitHost splits a full-URL action reference, https://host/owner/repo, into its host and
+// owner/repo. github.com URLs and plain owner/repo references have an empty host.
+func splitHost(action string) (string, string) {
+	rest, ok := strings.CutPrefix(action, "https://")
+	if !ok {
+		return "", action
+	}
+	host, slug, _ := strings.Cut(rest, "/")
+	if strings.EqualFold(host, "github.com") {
+		host = ""
+	}
+	return strings.ToLower(host), slug
+}
+
+// miscasedUses returns the references under a uses: key spelled in another letter case, such as
+// `Uses:`, which GitHub rejects, whether or not opts pins them.
+func miscasedUses(content string, opts scanOptions) []ActionOccurrence {
+	opts.anyCaseUses = true
+	var miscased []ActionOccurrence
+	for _, occ := range scanOccurrences(content, opts) {
+		if key := usesKey(content, occ); key != "uses" {
+			miscased = append(miscased, occ)
+		}
+	}
+	return miscased
+}
+
+// usesKey returns the key an occurrence was found under, as written.
+func usesKey(content string, occ ActionOccurrence) string {
+	return content[occ.MatchStart : occ.MatchStart+len("uses")]
+}
+
+// commentedOut reports whether pos is inside a YAML comment: a '#' earlier on its line at the
+// start of the line or after whitespace. Quoted scalars containing " #" are not told apart.
+func commentedOut(content string, pos int) bool {
+	lineStart := strings.LastIndexByte(content[:pos], '\n') + 1
+	line := content[lineStart:pos]
+	for i := 0; i < len(line); i++ {
+		if line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return true
+		}
+	}
+	return false
+}
+
+// utf8BOM is the byte order mark some editors write at the start of UTF-8 files.
+const utf8BOM = "\ufeff"
+
+// normalizeRef strips obvious artifacts from generated workflows, such as a trailing slash
+// or dot (`@v4/`, `@v4.`), so the ref can be resolved. The rewrite replaces the ref as written.
+func normalizeRef(ref string) string {
+	return strings.TrimRight(ref, "/.")
+}
+
+// computeLineCol returns 1-based line and column for the given byte offset.
+// A leading UTF-8 BOM is not counted towards the column on the first line.
+func computeLineCol(content string, offset int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(content) {
+		offset = len(content)
+	}
+	line := 1
+	col := 1
+	lastNL := -1
+	for i := 0; i < offset; i++ {
+		if content[i] == '\n' {
+			line++
+			lastNL = i
+		}
+	}
+	col = offset - lastNL
+	if line == 1 && offset >= len(utf8BOM) && strings.HasPrefix(content, utf8BOM) {
+		col -= len(utf8BOM)
+	}
+	return line, col
+}
+
+// lineCounter is computeLineCol for increasing offsets into the same content: each newline
+// is counted once rather than rescanning from the start, which is quadratic on large files.
+type lineCounter struct {
+	content            string
+	pos, line, newline int // newline is the offset of the last '\n' before pos, or -1
+}
+
+func newLineCounter(content string) *lineCounter {
+	return &lineCounter{content: content, line: 1, newline: -1}
+}
+
+// at returns the 1-based line and column of offset. Offsets before an earlier one fall back
+// to computeLineCol.
+func (lc *lineCounter) at(offset int) (int, int) {
+	if offset < lc.pos || offset > len(lc.content) {
+		return computeLineCol(lc.content, offset)
+	}
+	for ; lc.pos < offset; lc.pos++ {
+		if lc.content[lc.pos] == '\n' {
+			lc.line++
+			lc.newline = lc.pos
+		}
+	}
+	col := offset - lc.newline
+	if lc.line == 1 && offset >= len(utf8BOM) && strings.HasPrefix(lc.content, utf8BOM) {
+		col -= len(utf8BOM)
+	}
+	return lc.line, col
+}
+
+// currentVersion returns the version an occurrence is currently pinned to: the trailing
+// `# version` comment when it is semver, otherwise the requested ref when that is semver.
+func currentVersion(occ ActionOccurrence, style CommentStyle) string {
+	if comment := style.parse(occ.Comment); comment != "" {
+		if _, err := semver.NewVersion(comment); err == nil {
+			return comment
+		}
+	}
+	if _, err := semver.NewVersion(occ.RequestedRef); err == nil && !isFullSHA(occ.RequestedRef) {
+		return occ.RequestedRef
+	}
+	return ""
+}
+
+// isDowngrade reports whether the resolved version is semver-lower than the version the
+// occurrence is currently pinned to. Moving major tags (v4) are only compared by major.
+func isDowngrade(occ ActionOccurrence, info ActionInfo, style CommentStyle) bool {
+	if info.Error != nil {
+		return false
+	}
+	current := currentVersion(occ, style)
+	if current == "" {
+		return false
+	}
+	cur, err := semver.NewVersion(current)
+	if err != nil {
+		return false
+	}
+	resolved, err := semver.NewVersion(info.Version)
+	if err != nil {
+		return false
+	}
+	if isMovingMajorTag(current) || isMovingMajorTag(info.Version) {
+		return resolved.Major() < cur.Major()
+	}
+	return resolved.LessThan(cur)
+}
+
+func isMovingMajorTag(ref string) bool {
+	// v4 or 4
+	re := regexp.MustCompile(`^v?\d+$`)
+	return re.MatchString(ref)
+}
+
+// errTagNotFound is returned (wrapped) when a tag ref does not exist in the repository.
+var errTagNotFound = errors.New("tag not found")
+
+// errTagWithoutCommit is returned (wrapped) for a malformed tag whose ref carries no SHA.
+var errTagWithoutCommit = errors.New("no SHA found for tag")
+
+// errNoTagForCommit is returned (wrapped) when no semver tag points at a commit.
+var errNoTagForCommit = errors.New("no semver tag found for commit")
+
+// Resolver resolves action references against the GitHub API. A Resolver is scoped
+// to a single run: it memoizes tag lookups, including tags that turned out not to exist,
+// so repeated occurrences of the same ref don't query the API again.
+type Resolver struct {
+	client *github.Client
+	opts   ResolveOptions
+
+	mu         sync.Mutex
+	tags       map[string]*tagLookup
+	results    map[string]ActionInfo   // successful resolutions keyed by cacheKey
+	repos      map[string]*repoLookup  // repository metadata keyed by owner/repo
+	hidden     map[string]*accessError // repositories the token cannot see, keyed by owner/repo
+	resolvedAt map[string]time.Time    // when each entry of results was resolved
+	nextRun    time.Time               // earliest start of the next resolution when pacing
+	stats      CacheStats
+	tagObjects map[string]string // annotated tag object SHA → owner/repo@tag, with --no-resolve-annotated
+	attested   map[string]int    // attestations per owner/repo@sha, for --require-attestation
+	timings    []actionTiming    // one per resolved occurrence, for --stats-json
+	api        *apiCounter       // set by countAPICalls
+
+	// hostClient builds the client for a GitHub Enterprise Server host on first use; hosts
+	// holds the Resolver created for each such host, sharing opts.
+	hostClient func(ctx context.Context, host string) (*github.Client, error)
+	hosts      map[string]*Resolver
+}
+
+// CacheStats counts lookups served from the Resolver's in-memory caches versus the API.
+type CacheStats struct {
+	ResultHits   int `json:"resultHits"` // occurrences answered from an earlier identical resolution
+	ResultMisses int `json:"resultMisses"`
+	TagHits      int `json:"tagHits"` // tag → commit lookups answered from memory (including known-missing tags)
+	TagMisses    int `json:"tagMisses"`
+	Results      int `json:"results"` // entries currently cached
+	Tags         int `json:"tags"`
+}
+
+// tagLookup is the memoized outcome of resolving a single owner/repo tag. The done
+// channel is closed once the result is available so concurrent callers share one request.
+type tagLookup struct {
+	done    chan struct{}
+	sha     string
+	tagName string
+	err     error
+}
+
+// repoLookup is the memoized repository metadata for one owner/repo, shared like tagLookup.
+type repoLookup struct {
+	done       chan struct{}
+	repository *github.Repository
+	resp       *github.Response
+	err        error
+}
+
+// ResolveOptions controls how a Resolver selects versions and what it records as the version comment.
+type ResolveOptions struct {
+	Policy      UpdatePolicy
+	ExpandMajor bool
+	// PreferReleaseName uses the latest release's display name instead of its tag name
+	// as the version comment (major policy only).
+	PreferReleaseName bool
+	// CommentOnly keeps SHA-pinned refs as they are and only looks up the version tag
+	// pointing at each SHA, so that the trailing comment can be added or corrected.
+	CommentOnly bool
+	// Concurrency caps how many occurrences are resolved at once; 0 means unlimited.
+	Concurrency int
+	// Pace is the minimum delay between starting two resolutions, used to stretch a run over
+	// the remaining rate-limit window.
+	Pace time.Duration
+	// RegistryToken authenticates container registry lookups only; GitHub API requests
+	// always use the client's token.
+	RegistryToken string
+	// TagsPerPage is the page size used when listing tags; 0 means the API maximum of 100.
+	TagsPerPage int
+	// HostWorkers caps concurrent resolutions per host, keyed by host name (github.com for
+	// github.com). Other hosts share Concurrency evenly while several are resolved together.
+	HostWorkers map[string]int
+	// RepoOverrides maps a lowercased owner/repo to the owner/repo it is resolved against.
+	RepoOverrides map[string]string
+	// RewriteOverrides reports overridden actions under their target slug so that the
+	// rewritten line names the target repository; otherwise only the SHA changes.
+	RewriteOverrides bool
+	// ActionsDir, when set, resolves every action from local mirrors under this directory
+	// instead of the GitHub API (see localMirrors).
+	ActionsDir string
+	// GitRemote, when set, resolves every action from the refs git ls-remote lists for
+	// <GitRemote>/<owner>/<repo>.git instead of the GitHub API (see gitRemote).
+	GitRemote string
+	// ActionTimeout bounds the resolution of each occurrence so that one slow repository
+	// fails on its own instead of holding up the run; 0 means no limit.
+	ActionTimeout time.Duration
+	// NoResolveAnnotated skips dereferencing annotated tags, saving a request per action;
+	// such tags are then pinned to the tag object's SHA rather than the commit's.
+	NoResolveAnnotated bool
+}
+
+func NewResolver(client *github.Client, opts ResolveOptions) *Resolver {
+	return &Resolver{
+		client:     client,
+		opts:       opts,
+		tags:       make(map[string]*tagLookup),
+		results:    make(map[string]ActionInfo),
+		repos:      make(map[string]*repoLookup),
+		hidden:     make(map[string]*accessError),
+		resolvedAt: make(map[string]time.Time),
+		tagObjects: make(map[string]string),
+		attested:   make(map[string]int),
+	}
+}
+
+// repository returns the metadata of owner/repo. Results are memoized for the run so each
+// repository is fetched at most once, even by concurrent callers. As with tags, only 404s are remembered
+// among failures.
+func (r *Resolver) repository(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error) {
+	key := owner + "/" + repo
+	r.mu.Lock()
+	if l, ok := r.repos[key]; ok {
+		r.mu.Unlock()
+		<-l.done
+		return l.repository, l.resp, l.err
+	}
+	l := &repoLookup{done: make(chan struct{})}
+	r.repos[key] = l
+	r.mu.Unlock()
+
+	l.repository, l.resp, l.err = r.client.Repositories.Get(ctx, owner, repo)
+	if l.err != nil && (l.resp == nil || l.resp.StatusCode != http.StatusNotFound) {
+		r.mu.Lock()
+		delete(r.repos, key)
+		r.mu.Unlock()
+	}
+	close(l.done)
+	return l.repository, l.resp, l.err
+}
+
+// isArchived reports whether owner/repo is archived.
+func (r *Resolver) isArchived(ctx context.Context, owner, repo string) (bool, error) {
+	repository, _, err := r.repository(ctx, owner, repo)
+	if err != nil {
+		return false, err
+	}
+	return repository.GetArchived(), nil
+}
+
+// resolveDefaultBranch resolves the tip of owner/repo's default branch, whatever it is named
+// (main, master, trunk, develop, ...), and returns its commit SHA and branch name.
+func (r *Resolver) resolveDefaultBranch(ctx context.Context, owner, repo string) (string, string, error) {
+	repository, _, err := r.repository(ctx, owner, repo)
+	if err != nil {
+		return "", "", err
+	}
+	name := repository.GetDefaultBranch()
+	if name == "" {
+		return "", "", fmt.Errorf("no default branch for %s/%s", owner, repo)
+	}
+	branch, _, err := r.client.Repositories.GetBranch(ctx, owner, repo, name, 1)
+	if err != nil {
+		return "", "", err
+	}
+	sha := branch.GetCommit().GetSHA()
+	if sha == "" {
+		return "", "", fmt.Errorf("no SHA found for branch %s", name)
+	}
+	return sha, name, nil
+}
+
+// accessError wraps a 404 from resolving a ref in a repository that the token cannot see
+// either: it is private and the token lacks access, or it does not exist.
+type accessError struct {
+	Owner, Repo string
+	Scopes      string // X-OAuth-Scopes of a classic token; empty for other token types
+	Err         error
+}
+
+func (e *accessError) Error() string {
+	return fmt.Sprintf("%s: %v", e.reason(), e.Err)
+}
+
+func (e *accessError) Unwrap() error { return e.Err }
+
+func (e *accessError) reason() string {
+	reason := fmt.Sprintf("token may lack access to %s/%s (repository not found or private)", e.Owner, e.Repo)
+	if e.Scopes != "" && !hasRepoScope(e.Scopes) {
+		reason += fmt.Sprintf("; token scopes %q do not include repo", e.Scopes)
+	}
+	return reason
+}
+
+func hasRepoScope(scopes string) bool {
+	for _, scope := range strings.Split(scopes, ",") {
+		if strings.TrimSpace(scope) == "repo" {
+			return true
+		}
+	}
+	return false
+}
+
+// explainNotFound tells permission problems apart from missing refs: when err is a 404, it
+// checks whether the token can see owner/repo at all and, if not, returns an *accessError.
+// Visibility is memoized per repository like isArchived.
+func (r *Resolver) explainNotFound(ctx context.Context, owner, repo string, err error) error {
+	var respErr *github.ErrorResponse
+	if r.client == nil || !errors.As(err, &respErr) || respErr.Response == nil || respErr.Response.StatusCode != http.StatusNotFound {
+		return err
+	}
+	key := owner + "/" + repo
+	r.mu.Lock()
+	hidden, checked := r.hidden[key]
+	r.mu.Unlock()
+	if !checked {
+		_, resp, getErr := r.repository(ctx, owner, repo)
+		if getErr != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+			hidden = &accessError{Owner: owner, Repo: repo, Scopes: resp.Header.Get("X-OAuth-Scopes")}
+			r.mu.Lock()
+			r.hidden[key] = hidden
+			r.mu.Unlock()
+		}
+	}
+	if hidden == nil {
+		return err
+	}
+	wrapped := *hidden
+	wrapped.Err = err
+	return &wrapped
+}
+
+// Attestation modes (--require-attestation) decide what a pin without a published build
+// provenance attestation means.
+const (
+	attestationWarn = "warn" // report it and pin anyway
+	attestationFail = "fail" // refuse the pin, as if the action failed to resolve
+)
+
+func parseAttestationMode(value string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case "", attestationWarn, attestationFail:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid --require-attestation %q, want warn or fail", value)
+}
+
+// attestationCount returns how many attestations the repository has published for the commit,
+// looked up with the attestations API by subject digest sha1:<commit>. Lookups are memoized
+// per repository and commit.
+func (r *Resolver) attestationCount(ctx context.Context, owner, repo, sha string) (int, error) {
+	key := owner + "/" + repo + "@" + sha
+	r.mu.Lock()
+	n, ok := r.attested[key]
+	r.mu.Unlock()
+	if ok {
+		return n, nil
+	}
+	req, err := r.client.NewRequest(http.MethodGet, fmt.Sprintf("repos/%s/%s/attestations/sha1:%s", owner, repo, sha), nil)
+	if err != nil {
+		return 0, err
+	}
+	var body struct {
+		Attestations []json.RawMessage `json:"attestations"`
+	}
+	resp, err := r.client.Do(ctx, req, &body)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return 0, err
+	}
+	n = len(body.Attestations) // a 404 means none
+	r.mu.Lock()
+	r.attested[key] = n
+	r.mu.Unlock()
+	return n, nil
+}
+
+// checkAttestations reports the attestation status of every resolved action on w. Missing
+// attestations (or failed lookups) are warnings on errW in warn mode; in fail mode they become
+// the action's error, so it is left unchanged like any failed resolution.
+func (r *Resolver) checkAttestations(ctx context.Context, w, errW io.Writer, actionInfos []ActionInfo, mode string) {
+	fmt.Fprintln(w, bold("\nAttestations:\n"))
+	for i := range actionInfos {
+		info := &actionInfos[i]
+		if info.Error != nil {
+			continue
+		}
+		n, err := r.on(info.Host).attestationCount(ctx, info.Owner, info.Repo, info.SHA)
+		var problem error
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "  - %s/%s@%s: unknown\n", info.Owner, info.Repo, info.Version)
+			problem = fmt.Errorf("could not check build provenance attestations: %w", err)
+		case n == 0:
+			fmt.Fprintf(w, "  - %s/%s@%s: missing\n", info.Owner, info.Repo, info.Version)
+			problem = fmt.Errorf("no build provenance attestation for %s", info.SHA)
+		default:
+			fmt.Fprintf(w, "  - %s/%s@%s: attested (%d)\n", info.Owner, info.Repo, info.Version, n)
+		}
+		if problem == nil {
+			continue
+		}
+		if mode == attestationFail {
+			info.Error = problem
+		} else {
+			fmt.Fprintf(errW, "Warning: %s/%s@%s: %v\n", info.Owner, info.Repo, info.Version, problem)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// markArchived sets Archived on every resolved action whose repository is archived and returns
+// lookup failures as warnings; a failed lookup never fails the resolution itself.
+func (r *Resolver) markArchived(ctx context.Context, actionInfos []ActionInfo) []error {
+	var warnings []error
+	for i := range actionInfos {
+		info := &actionInfos[i]
+		if info.Error != nil {
+			continue
+		}
+		archived, err := r.on(info.Host).isArchived(ctx, info.Owner, info.Repo)
+		if err != nil {
+			warnings = append(warnings, fmt.Errorf("could not check whether %s/%s is archived: %w", info.Owner, info.Repo, err))
+			continue
+		}
+		info.Archived = archived
+	}
+	return warnings
+}
+
+// CacheStats returns a snapshot of cache hits, misses and sizes so far, including those of
+// the Resolvers of other hosts.
+func (r *Resolver) CacheStats() CacheStats {
+	r.mu.Lock()
+	stats := r.stats
+	stats.Results = len(r.results)
+	stats.Tags = len(r.tags)
+	r.mu.Unlock()
+	for _, h := range r.hostResolvers() {
+		hs := h.CacheStats()
+		stats.ResultHits += hs.ResultHits
+		stats.ResultMisses += hs.ResultMisses
+		stats.TagHits += hs.TagHits
+		stats.TagMisses += hs.TagMisses
+		stats.Results += hs.Results
+		stats.Tags += hs.Tags
+	}
+	return stats
+}
+
+// hostResolvers returns the Resolvers created so far for other hosts (see forHost).
+func (r *Resolver) hostResolvers() []*Resolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hosts := make([]*Resolver, 0, len(r.hosts))
+	for _, h := range r.hosts {
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+// statsSchemaVersion is the schemaVersion of the --stats-json document, versioned like --json.
+const statsSchemaVersion = 1
+
+// runStats is the --stats-json document: API usage, cache effectiveness and timings of a run.
+type runStats struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	WallTimeMs    float64        `json:"wallTimeMs"`
+	APICalls      int64          `json:"apiCalls"`
+	APITimeMs     float64        `json:"apiTimeMs"` // summed over requests, which may overlap
+	Cache         CacheStats     `json:"cache"`
+	Actions       []actionTiming `json:"actions"`
+}
+
+// actionTiming is how long resolving one occurrence took; occurrences answered from the
+// cache are marked cached and take no time.
+type actionTiming struct {
+	Action     string  `json:"action"`
+	Ref        string  `json:"ref"`
+	DurationMs float64 `json:"durationMs"`
+	Cached     bool    `json:"cached"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// apiCounter is an http.RoundTripper counting API requests and the time spent in them.
+type apiCounter struct {
+	base  http.RoundTripper
+	calls atomic.Int64
+	nanos atomic.Int64
+}
+
+func (c *apiCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.base.RoundTrip(req)
+	c.calls.Add(1)
+	c.nanos.Add(int64(time.Since(start)))
+	return resp, err
+}
+
+// countAPICalls routes the Resolver's requests through an apiCounter, keeping the client's
+// transport (and with it authentication and extra headers) underneath.
+func (r *Resolver) countAPICalls() {
+	if r.client == nil {
+		return
+	}
+	hc := r.client.Client()
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	r.api = &apiCounter{base: base}
+	hc.Transport = r.api
+	client := github.NewClient(hc)
+	client.BaseURL, client.UploadURL, client.UserAgent = r.client.BaseURL, r.client.UploadURL, r.client.UserAgent
+	r.client = client
+}
+
+// Stats returns the run's statistics so far, over every host; wall is the time since the run
+// started.
+func (r *Resolver) Stats(wall time.Duration) runStats {
+	stats := runStats{SchemaVersion: statsSchemaVersion, WallTimeMs: milliseconds(wall), Actions: []actionTiming{}}
+	if r == nil {
+		return stats
+	}
+	stats.Cache = r.CacheStats()
+	var apiNanos int64
+	for _, h := range append([]*Resolver{r}, r.hostResolvers()...) {
+		if h.api != nil {
+			stats.APICalls += h.api.calls.Load()
+			apiNanos += h.api.nanos.Load()
+		}
+		h.mu.Lock()
+		stats.Actions = append(stats.Actions, h.timings...)
+		h.mu.Unlock()
+	}
+	stats.APITimeMs = milliseconds(time.Duration(apiNanos))
+	sort.SliceStable(stats.Actions, func(i, j int) bool {
+		a, b := stats.Actions[i], stats.Actions[j]
+		if a.Action != b.Action {
+			return a.Action < b.Action
+		}
+		return a.Ref < b.Ref
+	})
+	return stats
+}
+
+// writeStatsJSON writes stats to path, or to stdout when path is "-".
+func writeStatsJSON(path string, stdout io.Writer, stats runStats) error {
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func printCacheStats(w io.Writer, stats CacheStats) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, bold("Cache stats:\n"))
+	fmt.Fprintf(w, "  resolutions: %d hits, %d misses, %d cached\n", stats.ResultHits, stats.ResultMisses, stats.Results)
+	fmt.Fprintf(w, "  tag lookups: %d hits, %d misses, %d cached\n", stats.TagHits, stats.TagMisses, stats.Tags)
+}
+
+// resolveTagToCommitSHA resolves a tag to a commit SHA, reusing earlier results from this run.
+// Negative results are only remembered for 404s; other errors (rate limits, network) are retried
+// by later callers. The memo lives in memory only, since a missing tag may be published later.
+func (r *Resolver) resolveTagToCommitSHA(ctx context.Context, owner, repo, tagName string) (string, string, error) {
+	key := owner + "/" + repo + "@" + tagName
+	r.mu.Lock()
+	if l, ok := r.tags[key]; ok {
+		r.stats.TagHits++
+		r.mu.Unlock()
+		<-l.done
+		return l.sha, l.tagName, l.err
+	}
+	l := &tagLookup{done: make(chan struct{})}
+	r.tags[key] = l
+	r.stats.TagMisses++
+	r.mu.Unlock()
+
+	l.sha, l.tagName, l.err = r.fetchTagCommitSHA(ctx, owner, repo, tagName)
+	if l.err != nil && !isBrokenTag(l.err) {
+		r.mu.Lock()
+		delete(r.tags, key)
+		r.mu.Unlock()
+	}
+	close(l.done)
+	return l.sha, l.tagName, l.err
+}
+
+func (r *Resolver) fetchTagCommitSHA(ctx context.Context, owner, repo, tagName string) (string, string, error) {
+	// Resolve a tag ref to a commit SHA, dereferencing annotated tags
+	ref, resp, err := r.client.Git.GetRef(ctx, owner, repo, "tags/"+tagName)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return "", "", fmt.Errorf("%w: %s", errTagNotFound, tagName)
+		}
+		return "", "", err
+	}
+	sha := ref.GetObject().GetSHA()
+	if ref.GetObject().GetType() == "tag" && r.opts.NoResolveAnnotated && sha != "" {
+		r.mu.Lock()
+		r.tagObjects[sha] = owner + "/" + repo + "@" + tagName
+		r.mu.Unlock()
+		return sha, tagName, nil
+	}
+	if ref.GetObject().GetType() == "tag" {
+		tagObj, _, tagErr := r.client.Git.GetTag(ctx, owner, repo, sha)
+		if tagErr == nil && tagObj != nil && tagObj.GetObject().GetType() == "commit" && tagObj.GetObject().GetSHA() != "" {
+			sha = tagObj.GetObject().GetSHA()
+		}
+	}
+	if sha == "" {
+		return "", "", fmt.Errorf("%w %s", errTagWithoutCommit, tagName)
+	}
+	return sha, tagName, nil
+}
+
+// tagObjectWarnings warns once for each resolved SHA that is an annotated tag object rather
+// than a commit, which only happens with --no-resolve-annotated.
+func (r *Resolver) tagObjectWarnings(actionInfos []ActionInfo) []string {
+	var warnings []string
+	warned := make(map[string]bool)
+	for _, info := range actionInfos {
+		h := r.on(info.Host)
+		h.mu.Lock()
+		tag, ok := h.tagObjects[info.SHA]
+		h.mu.Unlock()
+		if info.Error != nil || !ok || warned[info.SHA] {
+			continue
+		}
+		warned[info.SHA] = true
+		warnings = append(warnings, fmt.Sprintf("%s is an annotated tag; pinned to the tag object %s, not its commit (--no-resolve-annotated)", tag, info.SHA))
+	}
+	return warnings
+}
+
+// isBrokenTag reports whether err means the tag itself cannot be used (missing or without a
+// commit), as opposed to a transient failure such as a rate limit.
+func isBrokenTag(err error) bool {
+	return errors.Is(err, errTagNotFound) || errors.Is(err, errTagWithoutCommit)
+}
+
+// resolveFirstTag resolves the first of candidates, in order, that points at a commit. Broken
+// tags are skipped so one malformed tag doesn't fail the whole action; other errors stop the search.
+func (r *Resolver) resolveFirstTag(ctx context.Context, owner, repo string, candidates []string) (string, string, error) {
+	err := fmt.Errorf("no tags found")
+	for _, name := range candidates {
+		var sha, tagName string
+		sha, tagName, err = r.resolveTagToCommitSHA(ctx, owner, repo, name)
+		if err == nil || !isBrokenTag(err) {
+			return sha, tagName, err
+		}
+	}
+	return "", "", err
+}
+
+// semverTag is a tag name with its parsed version.
+type semverTag struct {
+	name    string
+	version *semver.Version
+}
+
+// highestFirst returns the names of tags ordered from highest to lowest version. Tags with
+// equal versions (v1.0 and v1.0.0) are ordered independently of the API's listing order, so
+// pins are reproducible: the more specific tag (more dot-separated parts) first, then by name.
+func highestFirst(tags []semverTag) []string {
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, b := tags[i], tags[j]
+		if !a.version.Equal(b.version) {
+			return a.version.GreaterThan(b.version)
+		}
+		if da, db := strings.Count(a.name, "."), strings.Count(b.name, "."); da != db {
+			return da > db
+		}
+		return a.name < b.name
+	})
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.name
+	}
+	return names
+}
+
+// tagsPerPage returns the page size for tag list requests.
+func (r *Resolver) tagsPerPage() int {
+	if r.opts.TagsPerPage > 0 {
+		return r.opts.TagsPerPage
+	}
+	return maxTagsPerPage
+}
+
+// maxTagsPerPage is the largest page size the GitHub API accepts for list requests.
+const maxTagsPerPage = 100
+
+func (r *Resolver) selectTagBySemverOrNewest(ctx context.Context, owner, repo string) (string, string, error) {
+	// List tags and pick highest semver; if none parsable, pick newest (first page ordering)
+	opts := &github.ListOptions{PerPage: r.tagsPerPage()}
+	tags, _, err := r.client.Repositories.ListTags(ctx, owner, repo, opts)
+	if err != nil || len(tags) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no tags found")
+		}
+		return "", "", err
+	}
+
+	var semverTags []semverTag
+	for _, t := range tags {
+		name := t.GetName()
+		v, parseErr := semver.NewVersion(name)
+		if parseErr != nil {
+			continue
+		}
+		semverTags = append(semverTags, semverTag{name: name, version: v})
+	}
+
+	var candidates []string
+	if len(semverTags) > 0 {
+		candidates = highestFirst(semverTags)
+	} else {
+		if newest, relErr := r.newestPublishedReleaseTag(ctx, owner, repo); relErr == nil {
+			// No semver tags (e.g. date-based tags): the most recently published release is a
+			// more reliable signal than tag order
+			candidates = append(candidates, newest)
+		}
+		// Fallback to tags as returned by API (assumed newest first)
+		for _, t := range tags {
+			candidates = append(candidates, t.GetName())
+		}
+	}
+	return r.resolveFirstTag(ctx, owner, repo, candidates)
+}
+
+// newestPublishedReleaseTag returns the tag of the most recently published (non-draft) release.
+func (r *Resolver) newestPublishedReleaseTag(ctx context.Context, owner, repo string) (string, error) {
+	releases, _, err := r.client.Repositories.ListReleases(ctx, owner, repo, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return "", err
+	}
+	var newest *github.RepositoryRelease
+	for _, rel := range releases {
+		if rel.GetDraft() || rel.PublishedAt == nil || rel.GetTagName() == "" {
+			continue
+		}
+		if newest == nil || rel.GetPublishedAt().After(newest.GetPublishedAt().Time) {
+			newest = rel
+		}
+	}
+	if newest == nil {
+		return "", fmt.Errorf("no published releases found")
+	}
+	return newest.GetTagName(), nil
+}
+
+// parseMajor extracts the major version number from a ref string.
+// Accepts forms like "v4", "4", or full semver tags like "v4.2.2".
+func parseMajor(ref string) (int, bool) {
+	if isMovingMajorTag(ref) {
+		r := strings.TrimPrefix(ref, "v")
+		v, err := strconv.Atoi(r)
+		if err != nil {
+			return 0, false
+		}
+		return v, true
+	}
+	if v, err := semver.NewVersion(ref); err == nil {
+		return int(v.Major()), true
+	}
+	return 0, false
+}
+
+// selectTagBySameMajor finds the highest semver tag within the specified major.
+func (r *Resolver) selectTagBySameMajor(ctx context.Context, owner, repo string, major int) (string, string, error) {
+	page := 1
+	var matches []semverTag
+	foundMatchInPriorPages := false
+
+	for {
+		opts := &github.ListOptions{PerPage: r.tagsPerPage(), Page: page}
+		tags, resp, err := r.client.Repositories.ListTags(ctx, owner, repo, opts)
+		if err != nil {
+			return "", "", err
+		}
+
+		// Track whether this page contained any tags matching the requested major.
+		// If none are found on a page and tags are ordered newest-first by the API,
+		// we can stop early once we have already seen at least one match in prior pages.
+		foundMatchOnCurrentPage := false
+
+		for _, t := range tags {
+			name := t.GetName()
+			v, parseErr := semver.NewVersion(name)
+			if parseErr != nil {
+				continue
+			}
+			if int(v.Major()) != major {
+				continue
+			}
+			foundMatchOnCurrentPage = true
+			matches = append(matches, semverTag{name: name, version: v})
+		}
+
+		// Early stop heuristic: only stop when the current page has no matches AND we
+		// previously saw at least one matching tag on an earlier page.
+		if !foundMatchOnCurrentPage && foundMatchInPriorPages {
+			break
+		}
+		if foundMatchOnCurrentPage {
+			foundMatchInPriorPages = true
+		}
+
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		page = resp.NextPage
+	}
+
+	if len(matches) == 0 {
+		return "", "", fmt.Errorf("no tags found for major %d", major)
+	}
+	return r.resolveFirstTag(ctx, owner, repo, highestFirst(matches))
+}
+
+// findFullSemverTagForMajorCommit attempts to find the exact full semver tag (e.g., v4.2.2)
+// that currently corresponds to the provided moving major ref (e.g., v4 or 4), by matching
+// the resolved commit SHA of the major tag against all semver tags with the same major.
+func (r *Resolver) findFullSemverTagForMajorCommit(ctx context.Context, owner, repo, majorRef, resolvedCommitSHA string) (string, error) {
+	// Parse major number from ref (strip optional leading 'v')
+	ref := majorRef
+	if strings.HasPrefix(ref, "v") {
+		ref = strings.TrimPrefix(ref, "v")
+	}
+	majorInt, err := strconv.Atoi(ref)
+	if err != nil {
+		return "", fmt.Errorf("not a major ref: %s", majorRef)
+	}
+	name, err := r.findSemverTagForCommit(ctx, owner, repo, resolvedCommitSHA, majorInt)
+	if err != nil {
+		return "", fmt.Errorf("no matching full tag found for %s", majorRef)
+	}
+	return name, nil
+}
+
+// findSemverTagForCommit returns the highest semver tag pointing at commitSHA. When major is
+// non-negative only tags with that major version are considered.
+func (r *Resolver) findSemverTagForCommit(ctx context.Context, owner, repo, commitSHA string, major int) (string, error) {
+	// First pass: collect candidate tags by major and compare lightweight tag SHAs directly
+	var candidates, matches []semverTag
+	page := 1
+	for {
+		opts := &github.ListOptions{PerPage: r.tagsPerPage(), Page: page}
+		tags, resp, listErr := r.client.Repositories.ListTags(ctx, owner, repo, opts)
+		if listErr != nil {
+			return "", listErr
+		}
+		for _, t := range tags {
+			name := t.GetName()
+			v, parseErr := semver.NewVersion(name)
+			if parseErr != nil {
+				continue
+			}
+			if major >= 0 && int(v.Major()) != major {
+				continue
+			}
+			candidates = append(candidates, semverTag{name: name, version: v})
+			// Compare the SHA provided by ListTags (lightweight tags) before dereferencing annotated ones
+			if t.GetCommit() != nil {
+				if sha := t.GetCommit().GetSHA(); sha != "" && strings.EqualFold(sha, commitSHA) {
+					matches = append(matches, semverTag{name: name, version: v})
+				}
+			}
+		}
+		// Several tags may point at the commit (v4.2.1 and v4.2.2) and the API does not promise
+		// to list them together, so every page is read before picking the highest.
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		page = resp.NextPage
+	}
+	if len(matches) > 0 {
+		return highestFirst(matches)[0], nil
+	}
+
+	// Second pass: dereference annotated tags only, highest first
+	for _, name := range highestFirst(candidates) {
+		sha, _, resolveErr := r.resolveTagToCommitSHA(ctx, owner, repo, name)
+		if resolveErr != nil {
+			continue
+		}
+		if strings.EqualFold(sha, commitSHA) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%w %s", errNoTagForCommit, commitSHA)
+}
+
+// sanitizeReleaseName collapses a release display name onto a single line so it can be
+// used as a trailing YAML comment.
+func sanitizeReleaseName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+func normalizeMajorRef(ref string) string {
+	// Ensure we try with leading 'v' first; many repos use that form
+	if strings.HasPrefix(ref, "v") {
+		return ref
+	}
+	return "v" + ref
+}
+
+// resolveActionForPolicy resolves a single occurrence according to the chosen policy.
+func (r *Resolver) resolveActionForPolicy(ctx context.Context, owner, repo, requestedRef string) (ActionInfo, error) {
+	policy := r.opts.Policy
+
+	if r.opts.ActionsDir != "" || r.opts.GitRemote != "" {
+		var info ActionInfo
+		var err error
+		if r.opts.ActionsDir != "" {
+			info, err = localMirrors{root: r.opts.ActionsDir}.resolve(ctx, owner, repo, requestedRef, r.opts)
+		} else {
+			info, err = gitRemote{base: r.opts.GitRemote}.resolve(ctx, owner, repo, requestedRef, r.opts)
+		}
+		if err != nil {
+			return ActionInfo{Owner: owner, Repo: repo, Error: err}, err
+		}
+		return info, nil
+	}
+
+	if r.opts.CommentOnly {
+		if !isFullSHA(requestedRef) {
+			err := fmt.Errorf("not pinned to a commit SHA: %s", requestedRef)
+			return ActionInfo{Owner: owner, Repo: repo, Error: err}, err
+		}
+		tagName, err := r.findSemverTagForCommit(ctx, owner, repo, requestedRef, -1)
+		if err != nil {
+			return ActionInfo{Owner: owner, Repo: repo, Error: err}, err
+		}
+		return ActionInfo{Owner: owner, Repo: repo, Version: tagName, SHA: requestedRef, ResolvedVia: viaRequested}, nil
+	}
+
+	// Policy: Requested
+	if policy == UpdatePolicyRequested {
+		if requestedRef != "" {
+			// If moving major, resolve to the commit that major points to
+			if isMovingMajorTag(requestedRef) {
+				candidates := []string{requestedRef}
+				if !strings.HasPrefix(requestedRef, "v") {
+					candidates = append(candidates, normalizeMajorRef(requestedRef))
+				}
+				var sha, tagName string
+				var err error
+				for _, c := range candidates {
+					sha, tagName, err = r.resolveTagToCommitSHA(ctx, owner, repo, c)
+					if err == nil {
+						break
+					}
+				}
+				if err == nil {
+					resolvedVersion := tagName
+					if r.opts.ExpandMajor {
+						if fullTag, ferr := r.findFullSemverTagForMajorCommit(ctx, owner, repo, requestedRef, sha); ferr == nil && fullTag != "" {
+							resolvedVersion = fullTag
+						}
+					}
+					return ActionInfo{Owner: owner, Repo: repo, Version: resolvedVersion, SHA: sha, ResolvedVia: viaRequested}, nil
+				}
+			}
+			// Else try resolve as an exact tag
+			if sha, tagName, err := r.resolveTagToCommitSHA(ctx, owner, repo, requestedRef); err == nil {
+				return ActionInfo{Owner: owner, Repo: repo, Version: tagName, SHA: sha, ResolvedVia: viaRequested}, nil
+			}
+			// If ref already a SHA, keep it
+			if isFullSHA(requestedRef) {
+				return ActionInfo{Owner: owner, Repo: repo, Version: requestedRef, SHA: requestedRef, ResolvedVia: viaRequested}, nil
+			}
+			// Expand an abbreviated SHA to the full commit, labelled with its tag when one points at it
+			if isShortSHA(requestedRef) {
+				if sha, _, err := r.client.Repositories.GetCommitSHA1(ctx, owner, repo, requestedRef, ""); err == nil && isFullSHA(sha) {
+					version := requestedRef
+					if tagName, tagErr := r.findSemverTagForCommit(ctx, owner, repo, sha, -1); tagErr == nil {
+						version = tagName
+					}
+					return ActionInfo{Owner: owner, Repo: repo, Version: version, SHA: sha, ResolvedVia: viaRequested}, nil
+				}
+			}
+		}
+		// Fall back to major policy if nothing matched
+	}
+
+	// Policy: Head - the default branch tip, labelled with the branch name
+	if policy == UpdatePolicyHead {
+		sha, branch, err := r.resolveDefaultBranch(ctx, owner, repo)
+		if err != nil {
+			return ActionInfo{Owner: owner, Repo: repo, Error: err}, err
+		}
+		return ActionInfo{Owner: owner, Repo: repo, Version: branch, SHA: sha, ResolvedVia: viaBranch}, nil
+	}
+
+	// Why each step was passed over, once a policy had to fall back to the major chain below:
+	// same-major → latest release → highest semver tag → newest release or tag.
+	var fallback []string
+	passOver := func(reason string) {
+		if len(fallback) > 0 {
+			fallback = append(fallback, reason)
+		}
+	}
+	fallenBack := func(info ActionInfo, how string) ActionInfo {
+		if len(fallback) > 0 {
+			info.Fallback = strings.Join(append(fallback, how), "; ")
+		}
+		return info
+	}
+
+	// Policy: Same major
+	if policy == UpdatePolicySameMajor && requestedRef != "" {
+		major, ok := parseMajor(requestedRef)
+		if !ok {
+			fallback = append(fallback, fmt.Sprintf("no major version in %s", requestedRef))
+		} else if sha, tagName, err := r.selectTagBySameMajor(ctx, owner, repo, major); err == nil {
+			return ActionInfo{Owner: owner, Repo: repo, Version: tagName, SHA: sha, ResolvedVia: viaSameMajor}, nil
+		} else {
+			fallback = append(fallback, err.Error())
+		}
+		// continue with the major policy below
+	}
+
+	// Policy: Major (default) - latest release, else highest semver, else newest
+	release, resp, err := r.client.Repositories.GetLatestRelease(ctx, owner, repo)
+	if err == nil && release != nil {
+		version := release.GetTagName()
+		sha, tagName, err := r.resolveTagToCommitSHA(ctx, owner, repo, version)
+		if err == nil {
+			if r.opts.PreferReleaseName {
+				if name := sanitizeReleaseName(release.GetName()); name != "" {
+					tagName = name
+				}
+			}
+			return fallenBack(ActionInfo{Owner: owner, Repo: repo, Version: tagName, SHA: sha, ResolvedVia: viaLatestRelease}, "used the latest release"), nil
+		}
+		// fall back to tags below if resolving tag failed
+		passOver(fmt.Sprintf("latest release tag %s did not resolve", version))
+	} else if resp != nil && resp.StatusCode != http.StatusNotFound {
+		// Unexpected error (not 404). Record and stop for this action.
+		return ActionInfo{Owner: owner, Repo: repo, Error: err}, err
+	} else {
+		passOver("no latest release")
+	}
+
+	sha, tagName, err := r.selectTagBySemverOrNewest(ctx, owner, repo)
+	if err != nil {
+		if len(fallback) > 0 {
+			err = fmt.Errorf("%s: %w", strings.Join(fallback, "; "), err)
+		}
+		return ActionInfo{Owner: owner, Repo: repo, Error: err}, err
+	}
+	how, via := "used the highest semver tag", viaHighestSemver
+	if _, verr := semver.NewVersion(tagName); verr != nil {
+		how, via = "no semver tags, used the newest release or tag", viaNewestFallback
+	}
+	return fallenBack(ActionInfo{Owner: owner, Repo: repo, Version: tagName, SHA: sha, ResolvedVia: via}, how), nil
+}
+
+// waitForPace blocks until the next resolution may start when pacing is enabled.
+func (r *Resolver) waitForPace() {
+	if r.opts.Pace <= 0 {
+		return
+	}
+	r.mu.Lock()
+	now := time.Now()
+	start := r.nextRun
+	if start.Before(now) {
+		start = now
+	}
+	r.nextRun = start.Add(r.opts.Pace)
+	r.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+// estimatedCallsPerAction is a rough upper bound of API requests needed to resolve one action:
+// latest release, tag ref and an annotated tag dereference.
+const estimatedCallsPerAction = 3
+
+// parseConcurrency parses the --concurrency value: empty for unlimited, "auto", or a positive number.
+func parseConcurrency(value string) (int, bool, error) {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "":
+		return 0, false, nil
+	case "auto":
+		return 0, true, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, false, fmt.Errorf("invalid concurrency %q, want a positive number or auto", value)
+	}
+	return n, false, nil
+}
+
+// autoConcurrency derives worker count and pacing from the remaining core rate-limit budget
+// and the number of actions to resolve:
+// - budget covers the run four times over: 16 workers, no pacing
+// - budget covers the run: 4 workers, no pacing
+// - budget falls short: 1 worker, with resolutions spread evenly until the quota resets
+func autoConcurrency(remaining, actions int, untilReset time.Duration) (int, time.Duration) {
+	needed := actions * estimatedCallsPerAction
+	switch {
+	case remaining >= 4*needed:
+		return 16, 0
+	case remaining >= needed:
+		return 4, 0
+	}
+	affordable := remaining / estimatedCallsPerAction
+	if affordable < 1 {
+		affordable = 1
+	}
+	if untilReset < 0 {
+		untilReset = 0
+	}
+	return 1, untilReset / time.Duration(affordable)
+}
+
+// suspiciousResolutions returns warnings for resolutions that look like mistakes: distinct
+// actions resolving to the same commit (e.g. a typo'd fork of the real action), and SHA refs
+// that no semver tag points at (a dangling or unreleased commit). Only SHA refs kept as written,
+// whose version is therefore not a tag, cost an extra tag lookup.
+func (r *Resolver) suspiciousResolutions(ctx context.Context, occurrences []ActionOccurrence, actionInfos []ActionInfo) []string {
+	var warnings []string
+	firstBySHA := make(map[string]ActionOccurrence)
+	checked := make(map[string]bool)
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) || actionInfos[i].Error != nil {
+			continue
+		}
+		info := actionInfos[i]
+		sha := strings.ToLower(info.SHA)
+		if first, ok := firstBySHA[sha]; !ok {
+			firstBySHA[sha] = occ
+		} else if slug := repoSlug(occ); !strings.EqualFold(repoSlug(first), slug) && !checked["same|"+sha+"|"+strings.ToLower(slug)] {
+			checked["same|"+sha+"|"+strings.ToLower(slug)] = true
+			warnings = append(warnings, fmt.Sprintf("%s (L%d:C%d) and %s (L%d:C%d) resolve to the same commit %s; check for a typo",
+				first.Action, first.Line, first.Column, occ.Action, occ.Line, occ.Column, prettyRef(info.SHA)))
+		}
+
+		if info.Version != occ.RequestedRef || !(isFullSHA(occ.RequestedRef) || isShortSHA(occ.RequestedRef)) {
+			continue
+		}
+		owner, repo := r.overrideRepo(occ.Owner, strings.SplitN(occ.Repo, "/", 2)[0])
+		key := "tag|" + occ.Host + "|" + owner + "/" + repo + "|" + sha
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+		dangling := isShortSHA(occ.RequestedRef) // the tag lookup already ran while expanding it
+		if h := r.on(occ.Host); !dangling && h.client != nil {
+			_, err := h.findSemverTagForCommit(ctx, owner, repo, info.SHA, -1)
+			dangling = errors.Is(err, errNoTagForCommit)
+		}
+		if dangling {
+			warnings = append(warnings, fmt.Sprintf("%s@%s (L%d:C%d) is not pointed at by any semver tag; it may be a dangling or unreleased commit",
+				occ.Action, prettyRef(occ.RequestedRef), occ.Line, occ.Column))
+		}
+	}
+	return warnings
+}
+
+// repoSlug returns the owner/repo an occurrence lives in, without the path of an action in a
+// subdirectory (github/codeql-action/init → github/codeql-action).
+func repoSlug(occ ActionOccurrence) string {
+	repo, _, _ := strings.Cut(occ.Repo, "/")
+	return occ.Owner + "/" + repo
+}
+
+// overrideRepo returns the repository an action is resolved against under --repo-override.
+func (r *Resolver) overrideRepo(owner, repo string) (string, string) {
+	if to, ok := r.opts.RepoOverrides[strings.ToLower(owner+"/"+repo)]; ok {
+		toOwner, toRepo, _ := strings.Cut(to, "/")
+		return toOwner, toRepo
+	}
+	return owner, repo
+}
+
+// cacheKey identifies resolutions that are guaranteed to produce the same result within a run.
+func cacheKey(owner, repo string, policy UpdatePolicy, requestedRef string) string {
+	return fmt.Sprintf("%s/%s|%d|%s", owner, repo, policy, requestedRef)
+}
+
+// cachedResolution is one entry of a --resolve-cache-file, keyed by cacheKey. Options records
+// the resolver options that shape the result beyond the key, so that jobs running with
+// different flags never reuse each other's entries.
+type cachedResolution struct {
+	Owner       string    `json:"owner"`
+	Repo        string    `json:"repo"`
+	Version     string    `json:"version"`
+	SHA         string    `json:"sha"`
+	Options     string    `json:"options"`
+	ResolvedAt  time.Time `json:"resolved_at"`
+	ResolvedVia string    `json:"resolved_via,omitempty"`
+}
+
+// optionsKey describes the options that change a resolution for the same cacheKey. Options
+// that only schedule the work (concurrency, pacing, workers, timeouts) are left out.
+func (r *Resolver) optionsKey() string {
+	overrides := make([]string, 0, len(r.opts.RepoOverrides))
+	for from, to := range r.opts.RepoOverrides {
+		overrides = append(overrides, from+"="+to)
+	}
+	sort.Strings(overrides)
+	return fmt.Sprintf("expand-major=%t,prefer-release-name=%t,comment-only=%t,actions-dir=%s,git-remote=%s,tags-per-page=%d,repo-overrides=%s,rewrite-overrides=%t,no-resolve-annotated=%t",
+		r.opts.ExpandMajor, r.opts.PreferReleaseName, r.opts.CommentOnly, r.opts.ActionsDir, r.opts.GitRemote,
+		r.tagsPerPage(), strings.Join(overrides, ";"), r.opts.RewriteOverrides, r.opts.NoResolveAnnotated)
+}
+
+// seedResults adds persisted resolutions to the in-memory cache, skipping entries resolved
+// with other options or older than ttl (0 keeps every entry). It returns how many were added.
+func (r *Resolver) seedResults(entries map[string]cachedResolution, ttl time.Duration, now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	options := r.optionsKey()
+	added := 0
+	for key, e := range entries {
+		if e.Options != options || !isFullSHA(e.SHA) {
+			continue
+		}
+		if isStale(e.ResolvedAt, ttl, now) {
+			continue
+		}
+		r.results[key] = ActionInfo{Owner: e.Owner, Repo: e.Repo, Version: e.Version, SHA: e.SHA, ResolvedVia: e.ResolvedVia}
+		r.resolvedAt[key] = e.ResolvedAt
+		added++
+	}
+	return added
+}
+
+// isStale reports whether a resolution made at resolvedAt is older than maxAge at now and must
+// be resolved again. A zero maxAge never expires; an unknown resolvedAt is always stale then.
+func isStale(resolvedAt time.Time, maxAge time.Duration, now time.Time) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	return resolvedAt.IsZero() || now.Sub(resolvedAt) > maxAge
+}
+
+// exportResults returns the in-memory resolutions in --resolve-cache-file form.
+func (r *Resolver) exportResults() map[string]cachedResolution {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	options := r.optionsKey()
+	entries := make(map[string]cachedResolution, len(r.results))
+	for key, info := range r.results {
+		entries[key] = cachedResolution{
+			Owner:       info.Owner,
+			Repo:        info.Repo,
+			Version:     info.Version,
+			SHA:         info.SHA,
+			Options:     options,
+			ResolvedAt:  r.resolvedAt[key],
+			ResolvedVia: info.ResolvedVia,
+		}
+	}
+	return entries
+}
+
+// loadResolveCache reads a --resolve-cache-file. A missing file is an empty cache.
+func loadResolveCache(path string) (map[string]cachedResolution, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]cachedResolution{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]cachedResolution{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// saveResolveCache merges entries into the cache file at path, keeping the newer resolution
+// when both have the same key (another job may have written the file meanwhile). The file is
+// replaced atomically.
+func saveResolveCache(path string, entries map[string]cachedResolution) error {
+	merged, err := loadResolveCache(path)
+	if err != nil {
+		// An unreadable cache is replaced rather than blocking the run
+		merged = map[string]cachedResolution{}
+	}
+	for key, e := range entries {
+		if old, ok := merged[key]; !ok || old.Options != e.Options || e.ResolvedAt.After(old.ResolvedAt) {
+			merged[key] = e
+		}
+	}
+	data, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// getActionInfosForOccurrences resolves each occurrence independently, against its own host:
+// full-URL references to a GitHub Enterprise Server go to that host's Resolver. Hosts are
+// resolved at the same time, each with its own workers, so a slow one does not hold up the rest.
+func (r *Resolver) getActionInfosForOccurrences(ctx context.Context, occurrences []ActionOccurrence) []ActionInfo {
+	byHost := make(map[string][]int)
+	var hosts []string
+	for i, occ := range occurrences {
+		host := occ.Host
+		if r.opts.ActionsDir != "" {
+			host = "" // local mirrors are looked up by owner/repo alone
+		}
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], i)
+	}
+	if len(hosts) == 0 || len(hosts) == 1 && hosts[0] == "" {
+		return r.resolveOccurrences(ctx, occurrences, r.workers("", 1))
+	}
+
+	infos := make([]ActionInfo, len(occurrences))
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		idxs := byHost[host]
+		target, err := r.forHost(ctx, host)
+		if err != nil {
+			for _, i := range idxs {
+				occ := occurrences[i]
+				infos[i] = ActionInfo{Owner: occ.Owner, Repo: occ.Repo, Host: occ.Host, Error: err}
+			}
+			continue
+		}
+		batch := make([]ActionOccurrence, len(idxs))
+		for j, i := range idxs {
+			batch[j] = occurrences[i]
+		}
+		wg.Add(1)
+		go func(limit int) {
+			defer wg.Done()
+			for j, info := range target.resolveOccurrences(ctx, batch, limit) {
+				infos[idxs[j]] = info
+			}
+		}(r.workers(host, len(hosts)))
+	}
+	wg.Wait()
+	return infos
+}
+
+// workers returns how many occurrences of host (empty for github.com) are resolved at once
+// while n hosts are resolved together: its --resolve-workers-per-host limit, or else an even
+// share of Concurrency, at least 1. 0 means unlimited.
+func (r *Resolver) workers(host string, n int) int {
+	if host == "" {
+		host = "github.com"
+	}
+	if limit, ok := r.opts.HostWorkers[host]; ok {
+		return limit
+	}
+	if r.opts.Concurrency <= 0 || n <= 1 {
+		return r.opts.Concurrency
+	}
+	return max(1, r.opts.Concurrency/n)
+}
+
+// forHost returns the Resolver for host, creating it and its client on first use. The empty
+// host is github.com, r itself.
+func (r *Resolver) forHost(ctx context.Context, host string) (*Resolver, error) {
+	if host == "" {
+		return r, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h, ok := r.hosts[host]; ok {
+		return h, nil
+	}
+	if r.opts.GitRemote != "" {
+		// The host's repositories are listed over git as well
+		opts := r.opts
+		opts.GitRemote = "https://" + host
+		h := NewResolver(nil, opts)
+		if r.hosts == nil {
+			r.hosts = make(map[string]*Resolver)
+		}
+		r.hosts[host] = h
+		return h, nil
+	}
+	if r.hostClient == nil {
+		return nil, fmt.Errorf("no API client for %s", host)
+	}
+	client, err := r.hostClient(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	h := NewResolver(client, r.opts)
+	if r.api != nil {
+		// The host's requests count towards the run's statistics too (see Stats)
+		h.countAPICalls()
+	}
+	if r.hosts == nil {
+		r.hosts = make(map[string]*Resolver)
+	}
+	r.hosts[host] = h
+	return h, nil
+}
+
+// on returns the Resolver that resolved actions of host, for follow-up lookups such as
+// attestations; r itself for github.com or when that host's client could not be created.
+func (r *Resolver) on(host string) *Resolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h, ok := r.hosts[host]; ok {
+		return h
+	}
+	return r
+}
+
+// resolveOccurrences resolves occurrences of one host, at most limit at once (0: unlimited).
+func (r *Resolver) resolveOccurrences(ctx context.Context, occurrences []ActionOccurrence, limit int) []ActionInfo {
+	var wg sync.WaitGroup
+	infos := make([]ActionInfo, len(occurrences))
+	var sem chan struct{}
+	if limit > 0 {
+		sem = make(chan struct{}, limit)
+	}
+
+	for i, occ := range occurrences {
+		wg.Add(1)
+		go func(idx int, o ActionOccurrence) {
+			defer wg.Done()
+			if sem != nil {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+			}
+			owner, repo := r.overrideRepo(o.Owner, o.Repo)
+			key := cacheKey(owner, repo, r.opts.Policy, o.RequestedRef)
+			defer func() {
+				if !r.opts.RewriteOverrides {
+					infos[idx].Owner, infos[idx].Repo = o.Owner, o.Repo
+				}
+				infos[idx].Host = o.Host
+				infos[idx].CacheKey = key
+			}()
+
+			// Reuse an identical earlier resolution to avoid duplicate network calls.
+			r.mu.Lock()
+			if info, exists := r.results[key]; exists {
+				r.stats.ResultHits++
+				r.timings = append(r.timings, actionTiming{Action: o.Action, Ref: o.RequestedRef, Cached: true})
+				r.mu.Unlock()
+				info.Cached = true
+				infos[idx] = info
+				return
+			}
+			r.stats.ResultMisses++
+			r.mu.Unlock()
+
+			r.waitForPace()
+			start := time.Now()
+			info := r.resolveWithTimeout(ctx, owner, repo, o.RequestedRef)
+			if info.Error != nil {
+				info.Error = r.explainNotFound(ctx, owner, repo, info.Error)
+			}
+			infos[idx] = info
+			timing := actionTiming{Action: o.Action, Ref: o.RequestedRef, DurationMs: milliseconds(time.Since(start))}
+			if info.Error != nil {
+				timing.Error = describeResolveError(info.Error)
+			}
+			r.mu.Lock()
+			r.timings = append(r.timings, timing)
+			r.mu.Unlock()
+
+			if info.Error == nil {
+				r.mu.Lock()
+				r.results[key] = info
+				r.resolvedAt[key] = time.Now()
+				r.mu.Unlock()
+			}
+		}(i, occ)
+	}
+
+	wg.Wait()
+	return infos
+}
+
+// resolveWithTimeout calls resolveActionForPolicy under the per-action timeout, if any, and
+// reports running out of time as the action's error.
+func (r *Resolver) resolveWithTimeout(ctx context.Context, owner, repo, requestedRef string) ActionInfo {
+	if r.opts.ActionTimeout <= 0 {
+		info, _ := r.resolveActionForPolicy(ctx, owner, repo, requestedRef)
+		return info
+	}
+	actionCtx, cancel := context.WithTimeout(ctx, r.opts.ActionTimeout)
+	defer cancel()
+	info, err := r.resolveActionForPolicy(actionCtx, owner, repo, requestedRef)
+	if err != nil && errors.Is(actionCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		info.Error = fmt.Errorf("timed out after %s (--resolve-timeout-per-action)", r.opts.ActionTimeout)
+	}
+	return info
+}
+
+// printResolvedActions lists each successfully resolved occurrence in file order.
+func printResolvedActions(w io.Writer, occurrences []ActionOccurrence, actionInfos []ActionInfo) {
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) || actionInfos[i].Error != nil {
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %s -> %s\n", occ.Action, actionInfos[i].Version, actionInfos[i].SHA)
+	}
+}
+
+// refNormalization records one occurrence moved to its action's common version.
+type refNormalization struct {
+	Action       string
+	Line, Column int
+	From, To     string
+}
+
+// normalizeActionInfos makes every occurrence of the same action use one resolution: the
+// highest semver version any of its ref forms (e.g. @v4 and @v4.0.0) resolved to. Actions
+// whose versions are not all semver are left alone. It returns the occurrences it changed.
+func normalizeActionInfos(occurrences []ActionOccurrence, actionInfos []ActionInfo) []refNormalization {
+	best := make(map[string]ActionInfo)
+	bestVersion := make(map[string]*semver.Version)
+	skip := make(map[string]bool)
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) || actionInfos[i].Error != nil {
+			continue
+		}
+		v, err := semver.NewVersion(actionInfos[i].Version)
+		if err != nil {
+			skip[occ.Action] = true
+			continue
+		}
+		if cur := bestVersion[occ.Action]; cur == nil || v.GreaterThan(cur) {
+			best[occ.Action], bestVersion[occ.Action] = actionInfos[i], v
+		}
+	}
+
+	var changes []refNormalization
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) || actionInfos[i].Error != nil || skip[occ.Action] {
+			continue
+		}
+		target := best[occ.Action]
+		if actionInfos[i].SHA == target.SHA && actionInfos[i].Version == target.Version {
+			continue
+		}
+		changes = append(changes, refNormalization{
+			Action: occ.Action, Line: occ.Line, Column: occ.Column,
+			From: occ.RequestedRef, To: target.Version,
+		})
+		actionInfos[i] = target
+	}
+	return changes
+}
+
+// stripUsesComments removes the trailing comment (and whitespace) from every uses: line, so
+// that two versions of a file can be compared for changes that affect what runs.
+func stripUsesComments(content string) string {
+	var b strings.Builder
+	prev := 0
+	for _, occ := range extractOccurrences(content) {
+		b.WriteString(content[prev:occ.valueEnd()])
+		prev = occ.ReplaceEnd
+	}
+	b.WriteString(content[prev:])
+	return b.String()
+}
+
+// printFailedActions lists every occurrence that could not be resolved, with its position and
+// the concrete reason, so that bad refs and API failures are easy to tell apart.
+func printFailedActions(w io.Writer, occurrences []ActionOccurrence, actionInfos []ActionInfo) {
+	header := false
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) || actionInfos[i].Error == nil {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w, bold("\nFailed to resolve:\n"))
+			header = true
+		}
+		fmt.Fprintf(w, "  - %s@%s (L%d:C%d): %s\n", occ.Action, occ.RequestedRef, occ.Line, occ.Column, describeResolveError(actionInfos[i].Error))
+	}
+}
+
+// printParseableFailures is printFailedActions for --error-format parseable: one
+// file:line:col: message line per occurrence that could not be resolved.
+func printParseableFailures(w io.Writer, file string, occurrences []ActionOccurrence, actionInfos []ActionInfo) {
+	for i, occ := range occurrences {
+		if i < len(actionInfos) && actionInfos[i].Error != nil {
+			fmt.Fprintf(w, "%s:%d:%d: %s@%s failed to resolve: %s\n", file, occ.Line, occ.Column, occ.Action, occ.RequestedRef, describeResolveError(actionInfos[i].Error))
+		}
+	}
+}
+
+// yamlErrorLine splits a yaml.v3 error ("yaml: line 3: did not find expected key") into its
+// line number and message. The line is 1 when the error names none.
+func yamlErrorLine(err error) (int, string) {
+	msg := strings.TrimPrefix(err.Error(), "yaml: ")
+	if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return line, m[2]
+	}
+	return 1, msg
+}
+
+var yamlLineRe = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// printFallbacks lists every occurrence resolved by falling back from its policy, with why.
+func printFallbacks(w io.Writer, occurrences []ActionOccurrence, actionInfos []ActionInfo) {
+	for i, occ := range occurrences {
+		if i < len(actionInfos) && actionInfos[i].Error == nil && actionInfos[i].Fallback != "" {
+			fmt.Fprintf(w, "Note: %s@%s (L%d:C%d): %s → %s\n", occ.Action, occ.RequestedRef, occ.Line, occ.Column, actionInfos[i].Fallback, actionInfos[i].Version)
+		}
+	}
+}
+
+// printCacheKeys lists the cacheKey each occurrence was resolved under, and whether an earlier
+// identical resolution answered it, to explain why occurrences did or did not share one.
+func printCacheKeys(w io.Writer, occurrences []ActionOccurrence, actionInfos []ActionInfo) {
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) || actionInfos[i].CacheKey == "" {
+			continue
+		}
+		source := "resolved"
+		if actionInfos[i].Cached {
+			source = "cached"
+		}
+		fmt.Fprintf(w, "Cache key: %s@%s (L%d:C%d): %s (%s)\n", occ.Action, occ.RequestedRef, occ.Line, occ.Column, actionInfos[i].CacheKey, source)
+	}
+}
+
+// describeResolveError turns API errors into "<status> for <method> <url>", e.g.
+// "404 Not Found for GET https://api.github.com/repos/o/r/git/ref/tags/v9"; rate limit errors
+// also say when the limit resets, and leave out the request when the response lacks it. Other
+// errors are returned as is.
+func describeResolveError(err error) string {
+	var accessErr *accessError
+	if errors.As(err, &accessErr) {
+		return accessErr.reason() + ": " + describeResolveError(accessErr.Err)
+	}
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) && rateErr.Response != nil {
+		return fmt.Sprintf("%s rate limited%s (resets %s)", rateErr.Response.Status,
+			forRequest(rateErr.Response), rateErr.Rate.Reset.Time.Local().Format(time.Kitchen))
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) && abuseErr.Response != nil {
+		return fmt.Sprintf("%s secondary rate limit%s", abuseErr.Response.Status, forRequest(abuseErr.Response))
+	}
+	var respErr *github.ErrorResponse
+	if errors.As(err, &respErr) && respErr.Response != nil && respErr.Response.Request != nil {
+		return fmt.Sprintf("%s for %s %s", respErr.Response.Status, respErr.Response.Request.Method, respErr.Response.Request.URL)
+	}
+	return err.Error()
+}
+
+// forRequest returns " for <method> <url>" naming the request resp answered, or nothing when
+// the response does not record it.
+func forRequest(resp *http.Response) string {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return ""
+	}
+	return fmt.Sprintf(" for %s %s", resp.Request.Method, resp.Request.URL)
+}
+
+func updateContent(content string, occurrences []ActionOccurrence, actionInfos []ActionInfo, style CommentStyle) string {
+	return applyEdits(content, contentEdits(content, occurrences, actionInfos, style))
+}
+
+// textEdit replaces the bytes [Start, End) of the original content with Text.
+type textEdit struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// contentEdits computes the replacements updateContent makes, ordered by offset and without
+// overlaps, so that editors can apply them as minimal edits (--range-format).
+func contentEdits(content string, occurrences []ActionOccurrence, actionInfos []ActionInfo, style CommentStyle) []textEdit {
+	// Build replacements for occurrences with successful resolutions
+	type repl struct {
+		start int
+		end   int
+		text  string
+	}
+	repls := make([]repl, 0)
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) {
+			continue
+		}
+		info := actionInfos[i]
+		if info.Error != nil || strings.TrimSpace(info.SHA) == "" || occ.ReplaceStart < 0 || occ.ReplaceEnd <= occ.ReplaceStart {
+			continue
+		}
+		// Spans that do not fit the content (stale or hand-built occurrences) are skipped
+		if occ.ReplaceEnd > len(content) || occ.RefEnd <= occ.ReplaceStart || occ.RefEnd > occ.ReplaceEnd {
+			continue
+		}
+		// An info naming another repository (--repo-override with --rewrite-overrides) also
+		// replaces the owner/repo slug, keeping the host of a full-URL reference
+		slug := info.Owner + "/" + info.Repo
+		_, current := splitHost(occ.Action)
+		rewriteSlug := info.Owner != "" && info.Repo != "" && slug != current && occ.ReplaceStart >= len(current)
+		ref, comment := style.pin(occ, info)
+		name := current
+		if rewriteSlug {
+			name = slug
+		}
+		// If the target ref equals the current ref as written, skip; with --mode tag-first the
+		// comment must record the SHA as well
+		written := content[occ.ReplaceStart+1 : occ.RefEnd]
+		if written == ref && (!style.tagFirst(info) || style.parse(occ.Comment) == info.SHA) && !rewriteSlug {
+			continue
+		}
+		r := repl{
+			start: occ.ReplaceStart,
+			end:   occ.ReplaceEnd,
+			text:  "@" + ref + occ.Quote + style.trailer(name, comment),
+		}
+		if rewriteSlug {
+			r.start -= len(current)
+			r.text = slug + r.text
+		}
+		repls = append(repls, r)
+	}
+	// Sort by start ascending to rebuild content; stable so that of two spans starting at the
+	// same offset the earlier occurrence wins
+	sort.SliceStable(repls, func(i, j int) bool { return repls[i].start < repls[j].start })
+	edits := make([]textEdit, 0, len(repls))
+	prev := 0
+	for _, r := range repls {
+		// Adjacent spans (r.start == prev) are rewritten back to back
+		if r.start < prev {
+			// overlapping; skip defensively
+			continue
+		}
+		edits = append(edits, textEdit{Start: r.start, End: r.end, Text: r.text})
+		prev = r.end
+	}
+	return edits
+}
+
+// rangeEdits is the --range-format document: the edits that turn the input into the pinned
+// workflow, with byte offsets into the input.
+type rangeEdits struct {
+	File  string     `json:"file"`
+	Edits []textEdit `json:"edits"`
+}
+
+// formatEdits renders the --range-format document for file as one line of JSON.
+func formatEdits(file string, edits []textEdit) []byte {
+	if edits == nil {
+		edits = []textEdit{}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(rangeEdits{File: file, Edits: edits})
+	return buf.Bytes()
+}
+
+// applyEdits applies edits, ordered and non-overlapping as from contentEdits, to content.
+func applyEdits(content string, edits []textEdit) string {
+	if len(edits) == 0 {
+		return content
+	}
+	var b strings.Builder
+	prev := 0
+	for _, e := range edits {
+		b.WriteString(content[prev:e.Start])
+		b.WriteString(e.Text)
+		prev = e.End
+	}
+	b.WriteString(content[prev:])
+	return b.String()
+}
+
+// CommentStyle controls how the trailing version comment is written and how an existing one is
+// read back on later runs, so that rewriting stays idempotent.
+type CommentStyle struct {
+	// Prefix namespaces the version, e.g. "pinned:" produces `# pinned: v4.2.2`.
+	Prefix string
+	// TagFirst (--mode tag-first) keeps the tag as written for the ref and records the SHA as
+	// the comment, `@v4 # 11bd719…`.
+	TagFirst bool
+	// Strip (--comment-style none-strip) writes no comment, removing any existing one from
+	// the lines it rewrites.
+	Strip bool
+	// Ratchet (--comment-style ratchet) writes the comment the way ratchet does, naming the
+	// action: `# ratchet:actions/checkout@v4.2.2`.
+	Ratchet bool
+	// Date (--comment-style dated), a pinDateLayout date, follows the version in comments
+	// written by this run: `# v4.2.2 (2024-01-15)`.
+	Date string
+}
+
+// Comment styles (--comment-style): whether rewritten lines carry a trailing comment, and
+// what it holds.
+const (
+	commentStyleVersion   = "version"    // @<sha> # <version>
+	commentStyleRatchet   = "ratchet"    // @<sha> # ratchet:<owner>/<repo>@<version>
+	commentStyleDated     = "dated"      // @<sha> # <version> (<date pinned>)
+	commentStyleNoneStrip = "none-strip" // @<sha>, dropping any existing comment
+)
+
+// pinDateLayout is the date format of --comment-style dated.
+const pinDateLayout = "2006-01-02"
+
+// ratchetPrefix starts a ratchet-style comment.
+const ratchetPrefix = "ratchet:"
+
+// datedSuffix matches the date a dated comment ends in.
+var datedSuffix = regexp.MustCompile(`\s+\(\d{4}-\d{2}-\d{2}\)$`)
+
+func parseCommentStyle(value string) (string, error) {
+	switch style := strings.ToLower(strings.TrimSpace(value)); style {
+	case commentStyleVersion, commentStyleRatchet, commentStyleDated, commentStyleNoneStrip:
+		return style, nil
+	}
+	return "", fmt.Errorf("invalid --comment-style %q, want version, ratchet, dated or none-strip", value)
+}
+
+// Pin modes (--mode): which of the SHA and the version is the ref and which the comment.
+const (
+	modeSHAFirst = "sha-first" // @<sha> # <version>
+	modeTagFirst = "tag-first" // @<tag> # <sha>
+)
+
+func parseMode(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case modeSHAFirst:
+		return false, nil
+	case modeTagFirst:
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid --mode %q, want sha-first or tag-first", value)
+}
+
+// tagFirst reports whether info is written tag first. A version that is itself a full SHA
+// (a commit no tag points at) is written SHA first in either mode.
+func (cs CommentStyle) tagFirst(info ActionInfo) bool {
+	return cs.TagFirst && !isFullSHA(info.Version)
+}
+
+// pin returns the ref and the comment text (before format) written for the resolution of occ.
+func (cs CommentStyle) pin(occ ActionOccurrence, info ActionInfo) (string, string) {
+	if cs.tagFirst(info) {
+		return tagFirstRef(occ.RequestedRef, info.Version), info.SHA
+	}
+	return info.SHA, info.Version
+}
+
+// tagFirstRef returns the ref --mode tag-first writes: the requested ref when the resolved
+// version is the one it names (v4 for v4.2.2), so the tag stays as written. A SHA, or a ref
+// the policy moved past (v4 resolved to v5.0.0), is replaced by the version, so that the ref
+// and the SHA in the comment agree.
+func tagFirstRef(requested, version string) string {
+	if requested == version || (!isFullSHA(requested) && strings.HasPrefix(version, requested+".")) {
+		return requested
+	}
+	return version
+}
+
+// trailer returns what follows a rewritten ref of name: ` # <text>` in the chosen format, or
+// nothing with Strip.
+func (cs CommentStyle) trailer(name, text string) string {
+	if cs.Strip {
+		return ""
+	}
+	return " # " + cs.format(name, text)
+}
+
+// format returns the comment text (without the leading '#') recorded for version of name,
+// an owner/repo or docker:// image that only the ratchet style writes out.
+func (cs CommentStyle) format(name, version string) string {
+	switch {
+	case cs.Ratchet && strings.HasPrefix(name, "docker://"):
+		return ratchetPrefix + name + ":" + version
+	case cs.Ratchet:
+		return ratchetPrefix + name + "@" + version
+	case cs.Date != "":
+		return version + " (" + cs.Date + ")"
+	case cs.Prefix != "":
+		return cs.Prefix + " " + version
+	}
+	return version
+}
+
+// records reports whether an existing comment already records version of name in this
+// style. A dated comment counts whatever day it was written on, so later runs keep it.
+func (cs CommentStyle) records(comment, name, version string) bool {
+	if cs.Date != "" {
+		return datedSuffix.MatchString(comment) && cs.parse(comment) == version
+	}
+	return comment == cs.format(name, version)
+}
+
+// parse returns the version recorded in an existing comment, accepting both the styled
+// and the plain form.
+func (cs CommentStyle) parse(comment string) string {
+	switch {
+	case cs.Ratchet && strings.HasPrefix(comment, ratchetPrefix):
+		constraint := strings.TrimPrefix(comment, ratchetPrefix)
+		if at := strings.LastIndexByte(constraint, '@'); at >= 0 {
+			return constraint[at+1:]
+		}
+		return constraint
+	case cs.Date != "":
+		return datedSuffix.ReplaceAllString(comment, "")
+	case cs.Prefix != "" && strings.HasPrefix(comment, cs.Prefix):
+		return strings.TrimSpace(strings.TrimPrefix(comment, cs.Prefix))
+	}
+	return comment
+}
+
+// alignComments pads the space after each SHA-pinned ref that has a trailing comment so all
+// such comments in content start in the same column, one space past the longest line prefix.
+// Widths are counted in runes. Running it on its own output changes nothing.
+func alignComments(content string) string {
+	type span struct{ refEnd, hash, width int }
+	var spans []span
+	widest := 0
+	for _, occ := range extractOccurrences(content) {
+		if !isFullSHA(occ.RequestedRef) {
+			continue
 		}
-		if foundMatchOnCurrentPage {
-			foundMatchInPriorPages = true
+		end := occ.valueEnd()
+		hash := strings.IndexByte(content[end:occ.MatchEnd], '#')
+		if hash < 0 {
+			continue
 		}
+		lineStart := strings.LastIndexByte(content[:end], '\n') + 1
+		width := utf8.RuneCountInString(content[lineStart:end])
+		spans = append(spans, span{refEnd: end, hash: end + hash, width: width})
+		widest = max(widest, width)
+	}
+	if len(spans) == 0 {
+		return content
+	}
+	var b strings.Builder
+	last := 0
+	for _, sp := range spans {
+		b.WriteString(content[last:sp.refEnd])
+		b.WriteString(strings.Repeat(" ", widest-sp.width+1))
+		last = sp.hash
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
 
-		if resp == nil || resp.NextPage == 0 {
-			break
+// updateComments adds or corrects the trailing `# version` comment of SHA-pinned occurrences
+// without touching the pinned ref itself. It returns the updated content along with the number
+// of comments that were added (none before) and corrected (different before).
+func updateComments(content string, occurrences []ActionOccurrence, actionInfos []ActionInfo, style CommentStyle) (string, int, int) {
+	var b strings.Builder
+	prev, added, corrected := 0, 0, 0
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) {
+			continue
+		}
+		info := actionInfos[i]
+		if info.Error != nil || strings.TrimSpace(info.Version) == "" || info.SHA != occ.RequestedRef {
+			continue
+		}
+		if style.records(occ.Comment, occ.Owner+"/"+occ.Repo, info.Version) {
+			continue
+		}
+		// Keep everything up to and including the ref; replace whatever trailing comment follows
+		refEnd := occ.valueEnd()
+		if refEnd < prev || refEnd > occ.ReplaceEnd {
+			continue
+		}
+		b.WriteString(content[prev:refEnd])
+		b.WriteString(" # " + style.format(occ.Owner+"/"+occ.Repo, info.Version))
+		prev = occ.ReplaceEnd
+		if occ.Comment == "" {
+			added++
+		} else {
+			corrected++
 		}
-		page = resp.NextPage
 	}
+	b.WriteString(content[prev:])
+	return b.String(), added, corrected
+}
 
-	if bestVersion == nil || bestTagName == "" {
-		return "", "", fmt.Errorf("no tags found for major %d", major)
+// printPlannedCommentChanges prints the old → new version comment for each occurrence
+// that updateComments will touch.
+func printPlannedCommentChanges(w io.Writer, occurrences []ActionOccurrence, actionInfos []ActionInfo, style CommentStyle) {
+	fmt.Fprintln(w, bold("Planned comment updates:\n"))
+	hadChange := false
+	for i, occ := range occurrences {
+		if i >= len(actionInfos) {
+			continue
+		}
+		info := actionInfos[i]
+		action := fmt.Sprintf("%s/%s", occ.Owner, occ.Repo)
+		if info.Error != nil || info.SHA != occ.RequestedRef || style.records(occ.Comment, action, info.Version) {
+			continue
+		}
+		fmt.Fprintf(w, "  - %s (L%d:C%d): # %s → # %s\n", action, occ.Line, occ.Column, prettyRef(occ.Comment), style.format(action, info.Version))
+		hadChange = true
 	}
-	sha, tagName, err := resolveTagToCommitSHA(ctx, client, owner, repo, bestTagName)
-	if err != nil {
-		return "", "", err
+	if !hadChange {
+		fmt.Fprintln(w, "  No changes needed. All version comments are accurate.")
 	}
-	return sha, tagName, nil
 }
 
-// findFullSemverTagForMajorCommit attempts to find the exact full semver tag (e.g., v4.2.2)
-// that currently corresponds to the provided moving major ref (e.g., v4 or 4), by matching
-// the resolved commit SHA of the major tag against all semver tags with the same major.
-func findFullSemverTagForMajorCommit(ctx context.Context, client *github.Client, owner, repo, majorRef, resolvedCommitSHA string) (string, error) {
-	// Parse major number from ref (strip optional leading 'v')
-	ref := majorRef
-	if strings.HasPrefix(ref, "v") {
-		ref = strings.TrimPrefix(ref, "v")
+// Diff preview removed
+
+// promptConfirmation asks a yes/no question and reports whether it was answered yes. An empty
+// answer (just Enter) takes defaultYes; running out of input never does, so a closed stdin
+// always means no.
+func promptConfirmation(in io.Reader, out io.Writer, prompt string, defaultYes bool) bool {
+	fmt.Fprint(out, prompt)
+	answer, ok := readAnswer(in)
+	if !ok {
+		return false
 	}
-	majorInt, err := strconv.Atoi(ref)
-	if err != nil {
-		return "", fmt.Errorf("not a major ref: %s", majorRef)
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	case "":
+		return defaultYes
 	}
+	return false
+}
 
-	// First pass: collect candidate tags by major and compare lightweight tag SHAs directly
-	candidates := make([]string, 0, 100)
-	page := 1
+// readAnswer reads one line from in a byte at a time, so that nothing past it is consumed and
+// the next prompt (one per file with --confirm-each-file) gets the next line. It reports false
+// when the input ended before any answer.
+func readAnswer(in io.Reader) (string, bool) {
+	var line []byte
+	buf := make([]byte, 1)
 	for {
-		opts := &github.ListOptions{PerPage: 100, Page: page}
-		tags, resp, listErr := client.Repositories.ListTags(ctx, owner, repo, opts)
-		if listErr != nil {
-			return "", listErr
-		}
-		for _, t := range tags {
-			name := t.GetName()
-			v, parseErr := semver.NewVersion(name)
-			if parseErr != nil {
-				continue
-			}
-			if int(v.Major()) != majorInt {
-				continue
-			}
-			candidates = append(candidates, name)
-			// Compare the SHA provided by ListTags (lightweight tags) before dereferencing annotated ones
-			if t.GetCommit() != nil {
-				if sha := t.GetCommit().GetSHA(); sha != "" && sha == resolvedCommitSHA {
-					return name, nil
-				}
+		n, err := in.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				return string(line), true
 			}
+			line = append(line, buf[0])
 		}
-		if resp == nil || resp.NextPage == 0 {
-			break
+		if err != nil {
+			return string(line), len(line) > 0
 		}
-		page = resp.NextPage
 	}
+}
 
-	// Second pass: dereference annotated tags only
-	for _, name := range candidates {
-		sha, _, resolveErr := resolveTagToCommitSHA(ctx, client, owner, repo, name)
-		if resolveErr != nil {
-			continue
-		}
-		if sha == resolvedCommitSHA {
-			return name, nil
-		}
+// promptHint is the answer hint shown after a question, with the default capitalized.
+func promptHint(defaultYes bool) string {
+	if defaultYes {
+		return "[Y/n]"
 	}
-	return "", fmt.Errorf("no matching full tag found for %s", majorRef)
+	return "[y/N]"
 }
 
-func normalizeMajorRef(ref string) string {
-	// Ensure we try with leading 'v' first; many repos use that form
-	if strings.HasPrefix(ref, "v") {
-		return ref
+// localMirrors resolves actions from mirrored repositories on disk, for air-gapped runners
+// without GitHub access. A mirror of owner/repo lives at <root>/<owner>/<repo>.git (a bare
+// clone, e.g. `git clone --mirror`) or <root>/<owner>/<repo> (a clone with a working tree);
+// the git executable, which run checks for up front, is used to read it. There are no
+// releases offline, so the major policy picks the highest semver tag.
+type localMirrors struct {
+	root string
+}
+
+// mirrorTag is a tag in a local mirror and the commit it (after peeling) points to.
+type mirrorTag struct {
+	name   string
+	commit string
+}
+
+// repoDir returns the git directory of the mirror of owner/repo: the bare clone itself, or the
+// .git directory of a clone with a working tree. Owner and repo must be plain names, so that
+// no reference reaches outside the root.
+func (m localMirrors) repoDir(owner, repo string) (string, error) {
+	if !isPlainName(owner) || !isPlainName(repo) {
+		return "", fmt.Errorf("no local mirror of %s/%s: not a plain owner and repository name", owner, repo)
 	}
-	return "v" + ref
+	for _, dir := range []string{
+		filepath.Join(m.root, owner, repo+".git"),
+		filepath.Join(m.root, owner, repo, ".git"),
+	} {
+		// A .git file (a worktree or submodule) names the git directory; git follows it
+		if _, err := os.Stat(dir); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no local mirror of %s/%s under %s", owner, repo, m.root)
 }
 
-// resolveActionForPolicy resolves a single occurrence according to the chosen policy.
-func resolveActionForPolicy(ctx context.Context, client *github.Client, owner, repo, requestedRef string, expandMajor bool, policy UpdatePolicy) (ActionInfo, error) {
+// isPlainName reports whether name is a single path segment other than . and ..
+func isPlainName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
 
-	// Policy: Requested
-	if policy == UpdatePolicyRequested {
-		if requestedRef != "" {
-			// If moving major, resolve to the commit that major points to
-			if isMovingMajorTag(requestedRef) {
-				candidates := []string{requestedRef}
-				if !strings.HasPrefix(requestedRef, "v") {
-					candidates = append(candidates, normalizeMajorRef(requestedRef))
-				}
-				var sha, tagName string
-				var err error
-				for _, c := range candidates {
-					sha, tagName, err = resolveTagToCommitSHA(ctx, client, owner, repo, c)
-					if err == nil {
-						break
-					}
-				}
-				if err == nil {
-					resolvedVersion := tagName
-					if expandMajor {
-						if fullTag, ferr := findFullSemverTagForMajorCommit(ctx, client, owner, repo, requestedRef, sha); ferr == nil && fullTag != "" {
-							resolvedVersion = fullTag
-						}
-					}
-					return ActionInfo{Owner: owner, Repo: repo, Version: resolvedVersion, SHA: sha}, nil
-				}
-			}
-			// Else try resolve as an exact tag
-			if sha, tagName, err := resolveTagToCommitSHA(ctx, client, owner, repo, requestedRef); err == nil {
-				return ActionInfo{Owner: owner, Repo: repo, Version: tagName, SHA: sha}, nil
-			}
-			// If ref already a SHA, keep it
-			if isFullSHA(requestedRef) {
-				return ActionInfo{Owner: owner, Repo: repo, Version: requestedRef, SHA: requestedRef}, nil
-			}
+// git runs a git subcommand against the git directory dir.
+func (m localMirrors) git(ctx context.Context, dir string, args ...string) (string, error) {
+	return gitOutput(exec.CommandContext(ctx, "git", append([]string{"--git-dir", dir}, args...)...), args[0])
+}
+
+// gitOutput runs a git command and returns its trimmed output; errors name the subcommand and
+// carry git's own message.
+func gitOutput(cmd *exec.Cmd, subcommand string) (string, error) {
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", subcommand, strings.TrimSpace(string(exitErr.Stderr)))
 		}
-		// Fall back to major policy if nothing matched
+		return "", fmt.Errorf("git %s: %w", subcommand, err)
 	}
+	return strings.TrimSpace(string(out)), nil
+}
 
-	// Policy: Same major
-	if policy == UpdatePolicySameMajor && requestedRef != "" {
-		if major, ok := parseMajor(requestedRef); ok {
-			if sha, tagName, err := selectTagBySameMajor(ctx, client, owner, repo, major); err == nil {
-				return ActionInfo{Owner: owner, Repo: repo, Version: tagName, SHA: sha}, nil
-			}
+// tags lists the mirror's tags, newest first by creation date.
+func (m localMirrors) tags(ctx context.Context, dir string) ([]mirrorTag, error) {
+	out, err := m.git(ctx, dir, "for-each-ref", "--sort=-creatordate", "--format=%(refname:short) %(objectname) %(*objectname)", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	var tags []mirrorTag
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
 		}
-		// If we failed to parse major or resolve, continue to major policy below
+		t := mirrorTag{name: fields[0], commit: fields[1]}
+		if len(fields) == 3 {
+			// Annotated tag: the peeled object is the commit
+			t.commit = fields[2]
+		}
+		tags = append(tags, t)
 	}
+	return tags, nil
+}
 
-	// Policy: Major (default) - latest release, else highest semver, else newest
-	release, resp, err := client.Repositories.GetLatestRelease(ctx, owner, repo)
-	if err == nil && release != nil {
-		version := release.GetTagName()
-		sha, tagName, err := resolveTagToCommitSHA(ctx, client, owner, repo, version)
-		if err == nil {
-			return ActionInfo{Owner: owner, Repo: repo, Version: tagName, SHA: sha}, nil
+// highestSemverTag returns the highest semver tag accepted by keep, or false if there is none.
+// Tags with equal versions are broken as highestFirst does, so mirrors pin what the API would.
+func highestSemverTag(tags []mirrorTag, keep func(mirrorTag, *semver.Version) bool) (mirrorTag, bool) {
+	var candidates []semverTag
+	byName := make(map[string]mirrorTag)
+	for _, t := range tags {
+		v, err := semver.NewVersion(t.name)
+		if err != nil || !keep(t, v) {
+			continue
+		}
+		candidates = append(candidates, semverTag{name: t.name, version: v})
+		byName[t.name] = t
+	}
+	if len(candidates) == 0 {
+		return mirrorTag{}, false
+	}
+	return byName[highestFirst(candidates)[0]], true
+}
+
+// tagForCommit returns the highest semver tag pointing at commit, limited to major unless it is -1.
+func tagForCommit(tags []mirrorTag, commit string, major int) (string, bool) {
+	t, ok := highestSemverTag(tags, func(t mirrorTag, v *semver.Version) bool {
+		return strings.EqualFold(t.commit, commit) && (major < 0 || int(v.Major()) == major)
+	})
+	return t.name, ok
+}
+
+func findMirrorTag(tags []mirrorTag, name string) (mirrorTag, bool) {
+	for _, t := range tags {
+		if t.name == name {
+			return t, true
 		}
-		// fall back to tags below if resolving tag failed
-	} else if resp != nil && resp.StatusCode != http.StatusNotFound {
-		// Unexpected error (not 404). Record and stop for this action.
-		return ActionInfo{Owner: owner, Repo: repo, Error: err}, err
 	}
+	return mirrorTag{}, false
+}
 
-	sha, tagName, err := selectTagBySemverOrNewest(ctx, client, owner, repo)
+// resolve applies the same policies as Resolver.resolveActionForPolicy to a local mirror.
+func (m localMirrors) resolve(ctx context.Context, owner, repo, requestedRef string, opts ResolveOptions) (ActionInfo, error) {
+	dir, err := m.repoDir(owner, repo)
 	if err != nil {
-		return ActionInfo{Owner: owner, Repo: repo, Error: err}, err
+		return ActionInfo{}, err
 	}
-	return ActionInfo{Owner: owner, Repo: repo, Version: tagName, SHA: sha}, nil
+	return resolveGitRefs(ctx, mirrorRepo{m: m, dir: dir}, owner, repo, requestedRef, opts)
 }
 
-// getActionInfosForOccurrences resolves each occurrence independently.
-func getActionInfosForOccurrences(ctx context.Context, client *github.Client, occurrences []ActionOccurrence, expandMajor bool, policy UpdatePolicy) []ActionInfo {
-	var wg sync.WaitGroup
-	infos := make([]ActionInfo, len(occurrences))
-	// Collect per-occurrence messages for deterministic output after wg.Wait()
-	messages := make([]string, len(occurrences))
+// gitRefs reads the refs of one repository for resolveGitRefs, from a local mirror
+// (--actions-dir) or a remote listed with git ls-remote (--use-git-ls-remote).
+type gitRefs interface {
+	// tags lists the tags with the commits they point to (after peeling), newest first.
+	tags(ctx context.Context) ([]mirrorTag, error)
+	// head returns the default branch and its commit.
+	head(ctx context.Context) (branch, sha string, err error)
+	// expand returns the full SHA of an abbreviated commit, if it can be told.
+	expand(ctx context.Context, short string) (string, bool)
+	String() string
+}
+
+// mirrorRepo is the gitRefs of a local mirror.
+type mirrorRepo struct {
+	m   localMirrors
+	dir string
+}
+
+func (r mirrorRepo) tags(ctx context.Context) ([]mirrorTag, error) { return r.m.tags(ctx, r.dir) }
 
-	// Simple cache to avoid duplicate network calls when resolution is identical.
-	type cacheEntry struct {
-		info ActionInfo
-		ok   bool
+func (r mirrorRepo) head(ctx context.Context) (string, string, error) {
+	ref, err := r.m.git(ctx, r.dir, "symbolic-ref", "HEAD")
+	if err != nil {
+		return "", "", fmt.Errorf("no default branch in %s: %w", r, err)
 	}
-	cache := make(map[string]cacheEntry)
-	var mu sync.Mutex
-	cacheKey := func(owner, repo string, policy UpdatePolicy, requestedRef string) string {
-		return fmt.Sprintf("%s/%s|%d|%s", owner, repo, policy, requestedRef)
+	sha, err := r.m.git(ctx, r.dir, "rev-parse", "--verify", "--quiet", "HEAD^{commit}")
+	if err != nil || !isFullSHA(sha) {
+		return "", "", fmt.Errorf("no commit on the default branch in %s", r)
 	}
+	return strings.TrimPrefix(ref, "refs/heads/"), sha, nil
+}
 
-	for i, occ := range occurrences {
-		wg.Add(1)
-		go func(idx int, o ActionOccurrence) {
-			defer wg.Done()
-			key := cacheKey(o.Owner, o.Repo, policy, o.RequestedRef)
-			mu.Lock()
-			if ce, exists := cache[key]; exists && ce.ok {
-				mu.Unlock()
-				infos[idx] = ce.info
-				return
-			}
-			mu.Unlock()
+func (r mirrorRepo) expand(ctx context.Context, short string) (string, bool) {
+	sha, err := r.m.git(ctx, r.dir, "rev-parse", "--verify", "--quiet", short+"^{commit}")
+	return sha, err == nil && isFullSHA(sha)
+}
 
-			info, err := resolveActionForPolicy(ctx, client, o.Owner, o.Repo, o.RequestedRef, expandMajor, policy)
-			if err == nil {
-				messages[idx] = fmt.Sprintf("  %s: %s -> %s", o.Action, info.Version, info.SHA)
-			}
-			infos[idx] = info
+func (r mirrorRepo) String() string { return "local mirror " + r.dir }
 
-			mu.Lock()
-			cache[key] = cacheEntry{info: info, ok: info.Error == nil}
-			mu.Unlock()
-		}(i, occ)
-	}
+// gitHubRemote is where --use-git-ls-remote lists github.com repositories, as
+// <gitHubRemote>/<owner>/<repo>.git. Tests replace it with a local directory.
+var gitHubRemote = "https://github.com"
 
-	wg.Wait()
-	// Print buffered messages in the original order
-	for _, m := range messages {
-		if strings.TrimSpace(m) == "" {
-			continue
-		}
-		fmt.Println(m)
+// gitRemote resolves actions by listing the refs of <base>/<owner>/<repo>.git with git
+// ls-remote (--use-git-ls-remote). That needs no API token and counts against no API rate
+// limit, but as with local mirrors there are no releases: the major policy picks the highest
+// semver tag.
+type gitRemote struct {
+	base string
+}
+
+func (g gitRemote) resolve(ctx context.Context, owner, repo, requestedRef string, opts ResolveOptions) (ActionInfo, error) {
+	return resolveGitRefs(ctx, remoteRepo{url: strings.TrimSuffix(g.base, "/") + "/" + owner + "/" + repo + ".git"}, owner, repo, requestedRef, opts)
+}
+
+// remoteRepo is the gitRefs of a repository read with git ls-remote.
+type remoteRepo struct {
+	url string
+}
+
+// lsRemote runs git ls-remote with flags on the refs of the repository matching pattern and
+// returns its output lines, each "<sha>\t<ref>". Git never prompts for credentials: private
+// repositories need a credential helper.
+func (r remoteRepo) lsRemote(ctx context.Context, pattern string, flags ...string) ([]string, error) {
+	args := append(append([]string{"ls-remote"}, flags...), r.url, pattern)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := gitOutput(cmd, "ls-remote")
+	if err != nil || out == "" {
+		return nil, err
 	}
-	return infos
+	return strings.Split(out, "\n"), nil
 }
 
-func updateContent(content string, occurrences []ActionOccurrence, actionInfos []ActionInfo) string {
-	// Build replacements for occurrences with successful resolutions
-	type repl struct {
-		start int
-		end   int
-		text  string
+// tags lists the remote's tags. Their dates are unknown remotely, so "newest" is the highest
+// by version order instead; an annotated tag's commit comes from its peeled "^{}" line.
+func (r remoteRepo) tags(ctx context.Context) ([]mirrorTag, error) {
+	lines, err := r.lsRemote(ctx, "refs/tags/*", "--tags", "--sort=-v:refname")
+	if err != nil {
+		return nil, err
 	}
-	repls := make([]repl, 0)
-	for i, occ := range occurrences {
-		if i >= len(actionInfos) {
+	var tags []mirrorTag
+	index := make(map[string]int)
+	peeled := make(map[string]string)
+	for _, line := range lines {
+		sha, ref, ok := strings.Cut(line, "\t")
+		name, isTag := strings.CutPrefix(ref, "refs/tags/")
+		if !ok || !isTag {
 			continue
 		}
-		info := actionInfos[i]
-		if info.Error != nil || strings.TrimSpace(info.SHA) == "" || occ.ReplaceStart < 0 || occ.ReplaceEnd <= occ.ReplaceStart {
+		if name, ok := strings.CutSuffix(name, "^{}"); ok {
+			peeled[name] = sha
 			continue
 		}
-		// If the target SHA equals the current ref, skip
-		if occ.RequestedRef == info.SHA {
-			continue
+		index[name] = len(tags)
+		tags = append(tags, mirrorTag{name: name, commit: sha})
+	}
+	for name, sha := range peeled {
+		if i, ok := index[name]; ok {
+			tags[i].commit = sha
 		}
-		repls = append(repls, repl{
-			start: occ.ReplaceStart,
-			end:   occ.ReplaceEnd,
-			text:  fmt.Sprintf("@%s # %s", info.SHA, info.Version),
-		})
 	}
-	if len(repls) == 0 {
-		return content
+	return tags, nil
+}
+
+func (r remoteRepo) head(ctx context.Context) (string, string, error) {
+	lines, err := r.lsRemote(ctx, "HEAD", "--symref")
+	if err != nil {
+		return "", "", err
 	}
-	// Sort by start ascending to rebuild content
-	sort.Slice(repls, func(i, j int) bool { return repls[i].start < repls[j].start })
-	var b strings.Builder
-	prev := 0
-	for _, r := range repls {
-		if r.start < prev {
-			// overlapping/unsorted; skip defensively
+	var branch, sha string
+	for _, line := range lines {
+		first, ref, _ := strings.Cut(line, "\t")
+		if ref != "HEAD" {
 			continue
 		}
-		b.WriteString(content[prev:r.start])
-		b.WriteString(r.text)
-		prev = r.end
+		if target, ok := strings.CutPrefix(first, "ref: "); ok {
+			branch = strings.TrimPrefix(target, "refs/heads/")
+		} else {
+			sha = first
+		}
 	}
-	b.WriteString(content[prev:])
-	return b.String()
+	if branch == "" || !isFullSHA(sha) {
+		return "", "", fmt.Errorf("no default branch in %s", r)
+	}
+	return branch, sha, nil
 }
 
-// Diff preview removed
+// expand cannot look up abbreviated commits: ls-remote only lists refs.
+func (r remoteRepo) expand(context.Context, string) (string, bool) { return "", false }
+
+func (r remoteRepo) String() string { return r.url }
+
+// resolveGitRefs applies the same policies as Resolver.resolveActionForPolicy to the refs
+// of a repository read with git.
+func resolveGitRefs(ctx context.Context, src gitRefs, owner, repo, requestedRef string, opts ResolveOptions) (ActionInfo, error) {
+	tags, err := src.tags(ctx)
+	if err != nil {
+		return ActionInfo{}, err
+	}
+	info := func(version, sha, via string) (ActionInfo, error) {
+		return ActionInfo{Owner: owner, Repo: repo, Version: version, SHA: sha, ResolvedVia: via}, nil
+	}
+
+	if opts.CommentOnly {
+		if !isFullSHA(requestedRef) {
+			return ActionInfo{}, fmt.Errorf("not pinned to a commit SHA: %s", requestedRef)
+		}
+		tagName, ok := tagForCommit(tags, requestedRef, -1)
+		if !ok {
+			return ActionInfo{}, fmt.Errorf("no semver tag found for commit %s", requestedRef)
+		}
+		return info(tagName, requestedRef, viaRequested)
+	}
+
+	if opts.Policy == UpdatePolicyRequested && requestedRef != "" {
+		candidates := []string{requestedRef}
+		if isMovingMajorTag(requestedRef) && !strings.HasPrefix(requestedRef, "v") {
+			candidates = append(candidates, normalizeMajorRef(requestedRef))
+		}
+		for _, c := range candidates {
+			t, ok := findMirrorTag(tags, c)
+			if !ok {
+				continue
+			}
+			version := t.name
+			if opts.ExpandMajor && isMovingMajorTag(requestedRef) {
+				if major, ok := parseMajor(requestedRef); ok {
+					if full, ok := tagForCommit(tags, t.commit, major); ok && !isMovingMajorTag(full) {
+						version = full
+					}
+				}
+			}
+			return info(version, t.commit, viaRequested)
+		}
+		if isFullSHA(requestedRef) {
+			return info(requestedRef, requestedRef, viaRequested)
+		}
+		if isShortSHA(requestedRef) {
+			if sha, ok := src.expand(ctx, requestedRef); ok {
+				version := requestedRef
+				if tagName, ok := tagForCommit(tags, sha, -1); ok {
+					version = tagName
+				}
+				return info(version, sha, viaRequested)
+			}
+		}
+		// Fall back to major policy if nothing matched
+	}
+
+	if opts.Policy == UpdatePolicyHead {
+		branch, sha, err := src.head(ctx)
+		if err != nil {
+			return ActionInfo{}, err
+		}
+		return info(branch, sha, viaBranch)
+	}
+
+	if opts.Policy == UpdatePolicySameMajor && requestedRef != "" {
+		if major, ok := parseMajor(requestedRef); ok {
+			if t, ok := highestSemverTag(tags, func(_ mirrorTag, v *semver.Version) bool { return int(v.Major()) == major }); ok {
+				return info(t.name, t.commit, viaSameMajor)
+			}
+		}
+	}
 
-func promptConfirmation(prompt string) bool {
-	fmt.Print(prompt)
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Scan()
-	response := strings.ToLower(strings.TrimSpace(scanner.Text()))
-	return response == "y" || response == "yes"
+	if t, ok := highestSemverTag(tags, func(mirrorTag, *semver.Version) bool { return true }); ok {
+		return info(t.name, t.commit, viaHighestSemver)
+	}
+	if len(tags) == 0 {
+		return ActionInfo{}, fmt.Errorf("no tags found in %s", src)
+	}
+	return info(tags[0].name, tags[0].commit, viaNewestFallback)
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsAllowed(t *testing.T) {
+	entries := []allowEntry{
+		{slug: "actions/checkout", version: "v4.2.2"},
+		{slug: "actions/setup-go", version: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"},
+		{slug: "my-org/*", version: "*"},
+	}
+	cases := []struct {
+		slug, version, sha string
+		want               bool
+	}{
+		{"actions/checkout", "v4.2.2", "11bd71901bbe5b1630ceea73d27597364c9af683", true},
+		{"Actions/Checkout", "v4.2.2", "11bd71901bbe5b1630ceea73d27597364c9af683", true},
+		{"actions/checkout", "v4.2.1", "0000000000000000000000000000000000000000", false},
+		{"actions/setup-go", "v5.5.0", "D35C59ABB061A4A6FB18E82AC0862C26744D6AB5", true},
+		{"my-org/deploy", "v1.0.0", "1111111111111111111111111111111111111111", true},
+		{"other/action", "v1.0.0", "1111111111111111111111111111111111111111", false},
+	}
+	for _, tc := range cases {
+		if got := isAllowed(entries, tc.slug, ActionInfo{Version: tc.version, SHA: tc.sha}, false); got != tc.want {
+			t.Errorf("isAllowed(%s@%s) = %t, want %t", tc.slug, tc.version, got, tc.want)
+		}
+	}
+
+	// --owner-case-insensitive=false matches the slug exactly
+	info := ActionInfo{Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"}
+	if !isAllowed(entries, "actions/checkout", info, true) || isAllowed(entries, "Actions/Checkout", info, true) {
+		t.Errorf("case-sensitive isAllowed() matched regardless of case")
+	}
+	if !isAllowed([]allowEntry{{slug: "My-Org/*", version: "*"}}, "My-Org/deploy", info, true) {
+		t.Errorf("case-sensitive isAllowed() lowered the entry")
+	}
+}
+
+func TestRun_OwnerAllowlistFile(t *testing.T) {
+	t.Setenv("GITHUB_ACTOR", "octocat")
+	dir := t.TempDir()
+	allowlist := filepath.Join(dir, "allowlist.txt")
+	audit := filepath.Join(dir, "audit.jsonl")
+	content := "steps:\n  - uses: actions/checkout@v4\n"
+
+	// Approved: pinned as usual, nothing audited.
+	if err := os.WriteFile(allowlist, []byte("# approved actions\nactions/checkout@v4.2.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := writeWorkflow(t, content)
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--owner-allowlist-file", allowlist, "--audit-log", audit, path); code != 0 {
+		t.Fatalf("exit code = %d; stderr: %s", code, stderr)
+	}
+	if got, _ := os.ReadFile(path); !strings.Contains(string(got), "@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2") {
+		t.Fatalf("approved action was not pinned:\n%s", got)
+	}
+	if _, err := os.Stat(audit); !os.IsNotExist(err) {
+		t.Fatalf("audit log written without rejections: %v", err)
+	}
+
+	// Only an older version approved: rejected, left unchanged, audited and failing the run.
+	if err := os.WriteFile(allowlist, []byte("actions/checkout@v4.2.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path = writeWorkflow(t, content)
+	code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--owner-allowlist-file", allowlist, "--audit-log", audit, path)
+	if code != 1 {
+		t.Fatalf("exit code = %d, want 1; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "actions/checkout@v4.2.2 is not in the allowlist") {
+		t.Fatalf("rejection not reported:\n%s", stderr)
+	}
+	if got, _ := os.ReadFile(path); string(got) != content {
+		t.Fatalf("rejected action was written:\n%s", got)
+	}
+	data, err := os.ReadFile(audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry auditEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("audit log is not one JSON entry: %v\n%s", err, data)
+	}
+	if entry.Time.IsZero() || entry.User != "octocat" || entry.File != path || entry.Line != 2 || entry.Column != 11 ||
+		entry.Action != "actions/checkout" || entry.Ref != "v4" || entry.Version != "v4.2.2" || entry.SHA != "11bd71901bbe5b1630ceea73d27597364c9af683" {
+		t.Fatalf("audit entry = %+v", entry)
+	}
+
+	for _, args := range [][]string{
+		{"--audit-log", audit, path},
+		{"--owner-allowlist-file", filepath.Join(dir, "missing.txt"), path},
+	} {
+		if code, _, _ := runCLI(t, checkoutRoutes(), "", args...); code != 1 {
+			t.Fatalf("%v: exit code = %d, want 1", args, code)
+		}
+	}
+	if err := os.WriteFile(allowlist, []byte("actions/checkout\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", "--owner-allowlist-file", allowlist, path); code != 1 || !strings.Contains(stderr, "allowlist.txt:1") {
+		t.Fatalf("entry without version: exit code = %d, stderr: %s", code, stderr)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveCacheFile_RoundTrip(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	path := filepath.Join(t.TempDir(), "resolve-cache.json")
+	occurrences := extractOccurrences("steps:\n  - uses: actions/checkout@v4\n")
+
+	first, _ := newTestResolver(t, checkoutRoutes())
+	first.getActionInfosForOccurrences(context.Background(), occurrences)
+	if err := saveResolveCache(path, first.exportResults()); err != nil {
+		t.Fatalf("saveResolveCache: %v", err)
+	}
+
+	entries, err := loadResolveCache(path)
+	if err != nil {
+		t.Fatalf("loadResolveCache: %v", err)
+	}
+	second, fake := newTestResolver(t, checkoutRoutes())
+	if n := second.seedResults(entries, time.Hour, time.Now()); n != 1 {
+		t.Fatalf("seedResults() = %d, want 1", n)
+	}
+	infos := second.getActionInfosForOccurrences(context.Background(), occurrences)
+	if infos[0].SHA != sha || infos[0].Version != "v4.2.2" {
+		t.Fatalf("got %+v, want %s # v4.2.2", infos[0], sha)
+	}
+	if got := fake.count("/repos/actions/checkout/releases/latest"); got != 0 {
+		t.Fatalf("cached resolution still queried the API %d times", got)
+	}
+
+	// A second job's entries are merged into the file, not replacing it.
+	other := map[string]cachedResolution{
+		cacheKey("actions", "setup-go", UpdatePolicyMajor, "v5"): {
+			Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5",
+			Options: second.optionsKey(), ResolvedAt: time.Now(),
+		},
+	}
+	if err := saveResolveCache(path, other); err != nil {
+		t.Fatalf("saveResolveCache: %v", err)
+	}
+	if entries, err = loadResolveCache(path); err != nil || len(entries) != 2 {
+		t.Fatalf("loadResolveCache() = %d entries, %v; want 2", len(entries), err)
+	}
+}
+
+func TestResolver_SeedResults_TTLAndOptions(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r := NewResolver(nil, ResolveOptions{})
+	entry := func(age time.Duration, options string) cachedResolution {
+		return cachedResolution{
+			Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683",
+			Options: options, ResolvedAt: now.Add(-age),
+		}
+	}
+	entries := map[string]cachedResolution{
+		"fresh":         entry(time.Hour, r.optionsKey()),
+		"expired":       entry(48*time.Hour, r.optionsKey()),
+		"other options": entry(time.Hour, "expand-major=true"),
+	}
+
+	if n := r.seedResults(entries, 24*time.Hour, now); n != 1 {
+		t.Fatalf("seedResults() with TTL = %d, want 1", n)
+	}
+	if _, ok := r.results["expired"]; ok {
+		t.Fatalf("expired entry was loaded")
+	}
+
+	r = NewResolver(nil, ResolveOptions{})
+	if n := r.seedResults(entries, 0, now); n != 2 {
+		t.Fatalf("seedResults() without TTL = %d, want 2", n)
+	}
+}
+
+func TestResolver_OptionsKey(t *testing.T) {
+	base := ResolveOptions{RepoOverrides: map[string]string{"actions/checkout": "mirror/checkout", "actions/cache": "mirror/cache"}}
+	key := NewResolver(nil, base).optionsKey()
+	if again := NewResolver(nil, base).optionsKey(); again != key {
+		t.Fatalf("optionsKey() is not stable: %q, then %q", key, again)
+	}
+
+	// Options that only schedule the work share the cache...
+	scheduled := base
+	scheduled.Concurrency, scheduled.Pace, scheduled.ActionTimeout = 4, time.Second, time.Minute
+	if got := NewResolver(nil, scheduled).optionsKey(); got != key {
+		t.Fatalf("scheduling options changed optionsKey() to %q, want %q", got, key)
+	}
+	// The default page size is the API maximum however it is spelled
+	maxPage := base
+	maxPage.TagsPerPage = maxTagsPerPage
+	if got := NewResolver(nil, maxPage).optionsKey(); got != key {
+		t.Fatalf("explicit default page size changed optionsKey() to %q, want %q", got, key)
+	}
+
+	// ...while every option that shapes the result misses it.
+	changes := map[string]func(*ResolveOptions){
+		"expand major":         func(o *ResolveOptions) { o.ExpandMajor = true },
+		"prefer release name":  func(o *ResolveOptions) { o.PreferReleaseName = true },
+		"comment only":         func(o *ResolveOptions) { o.CommentOnly = true },
+		"actions dir":          func(o *ResolveOptions) { o.ActionsDir = "/mirrors" },
+		"git remote":           func(o *ResolveOptions) { o.GitRemote = "https://github.com" },
+		"tags per page":        func(o *ResolveOptions) { o.TagsPerPage = 30 },
+		"repo override":        func(o *ResolveOptions) { o.RepoOverrides = map[string]string{"actions/checkout": "fork/checkout"} },
+		"no repo overrides":    func(o *ResolveOptions) { o.RepoOverrides = nil },
+		"rewrite overrides":    func(o *ResolveOptions) { o.RewriteOverrides = true },
+		"no resolve annotated": func(o *ResolveOptions) { o.NoResolveAnnotated = true },
+	}
+	for name, change := range changes {
+		opts := base
+		change(&opts)
+		got := NewResolver(nil, opts).optionsKey()
+		if got == key {
+			t.Errorf("%s: optionsKey() unchanged: %q", name, got)
+		}
+		entries := map[string]cachedResolution{
+			cacheKey("actions", "checkout", UpdatePolicyMajor, "v4"): {
+				Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683",
+				Options: key, ResolvedAt: time.Now(),
+			},
+		}
+		if n := NewResolver(nil, opts).seedResults(entries, 0, time.Now()); n != 0 {
+			t.Errorf("%s: seedResults() reused %d entries resolved with other options", name, n)
+		}
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name       string
+		resolvedAt time.Time
+		maxAge     time.Duration
+		want       bool
+	}{
+		{"recent", now.Add(-time.Hour), 6 * time.Hour, false},
+		{"exactly at the limit", now.Add(-6 * time.Hour), 6 * time.Hour, false},
+		{"older than the limit", now.Add(-7 * time.Hour), 6 * time.Hour, true},
+		{"unknown resolution time", time.Time{}, 6 * time.Hour, true},
+		{"clock skew", now.Add(time.Minute), 6 * time.Hour, false},
+		{"no expiry", now.Add(-365 * 24 * time.Hour), 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isStale(tc.resolvedAt, tc.maxAge, now); got != tc.want {
+				t.Fatalf("isStale() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRun_SinceLastRun(t *testing.T) {
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+	cache := filepath.Join(t.TempDir(), "resolve-cache.json")
+	r := NewResolver(nil, ResolveOptions{})
+	entry := func(resolvedAt time.Time) map[string]cachedResolution {
+		return map[string]cachedResolution{
+			cacheKey("actions", "checkout", UpdatePolicyMajor, "v4"): {
+				Owner: "actions", Repo: "checkout", Version: "v4.1.0", SHA: "b4ffde65f46336ab88eb53be808477a3936bae11",
+				Options: r.optionsKey(), ResolvedAt: resolvedAt,
+			},
+		}
+	}
+
+	// Resolved an hour ago: the locked SHA is reused without asking the API.
+	if err := saveResolveCache(cache, entry(time.Now().Add(-time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", "--since-last-run", "6h", "--resolve-cache-file", cache, path)
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2 (changes pending); stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Reusing 1 resolution(s) made in the last 6h0m0s") || !strings.Contains(stdout, "v4.1.0") {
+		t.Fatalf("recent resolution was not reused:\n%s", stdout)
+	}
+
+	// Resolved a day ago: stale, so the action is resolved again.
+	if err := os.Remove(cache); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveResolveCache(cache, entry(time.Now().Add(-24*time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	code, stdout, stderr = runCLI(t, checkoutRoutes(), "", "--dry-run", "--since-last-run", "6h", "--resolve-cache-file", cache, path)
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2 (changes pending); stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Reusing 0 resolution(s)") || !strings.Contains(stdout, "v4.2.2") {
+		t.Fatalf("stale resolution was not refreshed:\n%s", stdout)
+	}
+
+	for _, args := range [][]string{
+		{"--since-last-run", "6h", path},
+		{"--since-last-run", "0s", "--resolve-cache-file", cache, path},
+		{"--since-last-run", "6h", "--resolve-cache-ttl", "1h", "--resolve-cache-file", cache, path},
+	} {
+		if code, _, _ := runCLI(t, checkoutRoutes(), "", args...); code != 1 {
+			t.Fatalf("%v: exit code = %d, want 1", args, code)
+		}
+	}
+}
+
+func TestRun_ExplainCacheKey(t *testing.T) {
+	if got, want := cacheKey("actions", "checkout", UpdatePolicyMajor, "v4"), "actions/checkout|0|v4"; got != want {
+		t.Fatalf("cacheKey() = %q, want %q", got, want)
+	}
+
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/checkout@v4\n")
+	code, _, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", "--concurrency", "1", "--explain-cache-key", path)
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2 (changes pending); stderr: %s", code, stderr)
+	}
+	for _, line := range []string{"actions/checkout@v4 (L2:C11): ", "actions/checkout@v4 (L3:C11): "} {
+		if !strings.Contains(stderr, "Cache key: "+line+"actions/checkout|0|v4 (") {
+			t.Fatalf("missing cache key for %q:\n%s", line, stderr)
+		}
+	}
+	// Resolved one at a time, exactly one of the two identical occurrences reaches the API.
+	if strings.Count(stderr, "(resolved)") != 1 || strings.Count(stderr, "(cached)") != 1 {
+		t.Fatalf("want one resolved and one cached occurrence:\n%s", stderr)
+	}
+
+	if _, _, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", path); strings.Contains(stderr, "Cache key:") {
+		t.Fatalf("cache keys printed without --explain-cache-key:\n%s", stderr)
+	}
+}
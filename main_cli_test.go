@@ -0,0 +1,1424 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v57/github"
+)
+
+// runCLI runs the CLI against a fake GitHub API serving routes and returns the exit code
+// along with everything written to stdout and stderr.
+func runCLI(t *testing.T, routes map[string]string, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	client, _ := newTestClient(t, routes)
+	orig := newGitHubClient
+	newGitHubClient = func(context.Context, string, http.Header) *github.Client { return client }
+	t.Cleanup(func() { newGitHubClient = orig })
+	t.Setenv("GH_TOKEN", "test-token")
+
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// writeWorkflow writes content to a workflow file in a temp dir and returns its path.
+func writeWorkflow(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "workflow.yml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write workflow: %v", err)
+	}
+	return path
+}
+
+// checkoutRoutes stubs actions/checkout with v4.2.2 as its latest release.
+func checkoutRoutes() map[string]string {
+	return map[string]string{
+		"/repos/actions/checkout/releases/latest":     `{"tag_name":"v4.2.2"}`,
+		"/repos/actions/checkout/git/ref/tags/v4.2.2": `{"ref":"refs/tags/v4.2.2","object":{"type":"commit","sha":"11bd71901bbe5b1630ceea73d27597364c9af683"}}`,
+	}
+}
+
+func TestRun_SummaryOnly(t *testing.T) {
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--summary-only", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	want := "actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"
+	if stdout != want {
+		t.Fatalf("stdout = %q, want %q", stdout, want)
+	}
+}
+
+func TestRun_RefusesDowngrade(t *testing.T) {
+	input := "steps:\n  - uses: actions/checkout@08c6903cd8c0fde910a37f88322edcfb5dd907a8 # v5.0.0\n"
+
+	path := writeWorkflow(t, input)
+	code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "older than current v5.0.0") {
+		t.Fatalf("expected a downgrade warning, stderr: %s", stderr)
+	}
+	if got, _ := os.ReadFile(path); string(got) != input {
+		t.Fatalf("file was rewritten despite downgrade guard:\n%s", got)
+	}
+
+	path = writeWorkflow(t, input)
+	code, _, stderr = runCLI(t, checkoutRoutes(), "", "--yes", "--allow-downgrade", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	want := "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Fatalf("file = %q, want %q", got, want)
+	}
+}
+
+func TestRun_ExportEnv(t *testing.T) {
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/checkout@v4\n")
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--export-env", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	want := "PIN_actions_checkout=11bd71901bbe5b1630ceea73d27597364c9af683\n"
+	if stdout != want {
+		t.Fatalf("stdout = %q, want %q", stdout, want)
+	}
+}
+
+func TestExpandPaths_DeduplicatesFiles(t *testing.T) {
+	dir := t.TempDir()
+	ci := filepath.Join(dir, "ci.yml")
+	release := filepath.Join(dir, "release.yaml")
+	for _, p := range []string{ci, release, filepath.Join(dir, "README.md")} {
+		if err := os.WriteFile(p, []byte("steps: []\n"), 0644); err != nil {
+			t.Fatalf("write %s: %v", p, err)
+		}
+	}
+	got, err := expandPaths([]string{ci, filepath.Join(dir, ".", "ci.yml"), filepath.Join(dir, "*.yml"), dir, filepath.Join(dir, "*.y*")}, false)
+	if err != nil {
+		t.Fatalf("expandPaths: %v", err)
+	}
+	want := []string{ci, release}
+	if strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Fatalf("expandPaths() = %v, want %v", got, want)
+	}
+}
+
+func TestExpandPaths_HiddenDirectories(t *testing.T) {
+	root := t.TempDir()
+	workflow := filepath.Join(root, ".github", "workflows", "ci.yml")
+	hidden := filepath.Join(root, "ci", ".templates", "nested", "deploy.yml")
+	vendored := filepath.Join(root, ".git", "hooks", "config.yml")
+	for _, p := range []string{workflow, hidden, vendored} {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("steps: []\n"), 0644); err != nil {
+			t.Fatalf("write %s: %v", p, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		includeHidden bool
+		want          []string
+	}{
+		{false, []string{workflow}},
+		{true, []string{workflow, hidden}},
+	} {
+		got, err := expandPaths([]string{root}, tc.includeHidden)
+		if err != nil {
+			t.Fatalf("expandPaths: %v", err)
+		}
+		if strings.Join(got, ";") != strings.Join(tc.want, ";") {
+			t.Fatalf("includeHidden=%t: expandPaths() = %v, want %v", tc.includeHidden, got, tc.want)
+		}
+	}
+
+	// A hidden directory named explicitly is always searched.
+	if got, err := expandPaths([]string{filepath.Join(root, "ci", ".templates")}, false); err != nil || len(got) != 1 || got[0] != hidden {
+		t.Fatalf("explicit hidden directory: expandPaths() = %v, %v; want [%s]", got, err, hidden)
+	}
+}
+
+func TestRun_DuplicatePathsProcessedOnce(t *testing.T) {
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--yes", path, path, filepath.Join(filepath.Dir(path), ".", filepath.Base(path)))
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if n := strings.Count(stdout, "Scanning workflow"); n != 1 {
+		t.Fatalf("file scanned %d times, want 1:\n%s", n, stdout)
+	}
+	if n := strings.Count(stdout, "Updated file"); n != 1 {
+		t.Fatalf("file written %d times, want 1:\n%s", n, stdout)
+	}
+}
+
+func TestRun_ValidateRefusesBrokenYAML(t *testing.T) {
+	// In a flow mapping the appended comment swallows the closing brace
+	input := "steps:\n  - {uses: actions/checkout@v4}\n"
+
+	path := writeWorkflow(t, input)
+	code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--validate", path)
+	if code != 1 {
+		t.Fatalf("exit code = %d, want 1; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "is not valid YAML") {
+		t.Fatalf("expected a validation error, stderr: %s", stderr)
+	}
+	if got, _ := os.ReadFile(path); string(got) != input {
+		t.Fatalf("file was written despite failing validation:\n%s", got)
+	}
+}
+
+func TestRun_TokenStdin(t *testing.T) {
+	code, _, stderr := runCLI(t, checkoutRoutes(), "token\n", "--token-stdin", "-")
+	if code != 1 || !strings.Contains(stderr, "--token-stdin cannot be used with -") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+
+	client, _ := newTestClient(t, checkoutRoutes())
+	orig := newGitHubClient
+	var gotToken string
+	newGitHubClient = func(_ context.Context, token string, _ http.Header) *github.Client {
+		gotToken = token
+		return client
+	}
+	t.Cleanup(func() { newGitHubClient = orig })
+	t.Setenv("GH_TOKEN", "env-token")
+
+	// The token is the first line; the confirmation prompt reads the next one.
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+	var stdout, errOut bytes.Buffer
+	code = run([]string{"--token-stdin", path}, strings.NewReader("stdin-token\ny\n"), &stdout, &errOut)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, errOut.String())
+	}
+	if gotToken != "stdin-token" {
+		t.Fatalf("client token = %q, want stdin-token", gotToken)
+	}
+	want := "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Fatalf("file = %q, want %q", got, want)
+	}
+}
+
+func TestRun_ExitCodeMapping(t *testing.T) {
+	mapping := []string{"--no-op-exit-code", "10", "--changes-exit-code", "11", "--error-exit-code", "12"}
+
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", append(mapping, "--dry-run", path)...); code != 11 {
+		t.Fatalf("changes pending: exit code = %d, want 11; stderr: %s", code, stderr)
+	}
+
+	path = writeWorkflow(t, "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n")
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", append(mapping, "--dry-run", path)...); code != 10 {
+		t.Fatalf("no-op: exit code = %d, want 10; stderr: %s", code, stderr)
+	}
+
+	// A file without actions has nothing to do: a no-op, not an error.
+	path = writeWorkflow(t, "name: no actions\n")
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", append(mapping, "--dry-run", path)...); code != 10 {
+		t.Fatalf("no actions: exit code = %d, want 10; stderr: %s", code, stderr)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.yml")
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", append(mapping, "--dry-run", missing)...); code != 12 {
+		t.Fatalf("error: exit code = %d, want 12; stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_WarnArchived(t *testing.T) {
+	routes := checkoutRoutes()
+	routes["/repos/actions/checkout"] = `{"name":"checkout","archived":true}`
+
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/checkout@v4\n")
+	code, stdout, stderr := runCLI(t, routes, "", "--yes", "--warn-archived", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got := strings.Count(stderr, "actions/checkout is archived"); got != 1 {
+		t.Fatalf("archived warning printed %d times, want 1; stderr: %s", got, stderr)
+	}
+	if !strings.Contains(stdout, "# v4.2.2 (archived)") {
+		t.Fatalf("summary does not mark the archived action:\n%s", stdout)
+	}
+
+	path = writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+	_, stdout, stderr = runCLI(t, routes, "", "--yes", path)
+	if strings.Contains(stderr, "archived") || strings.Contains(stdout, "archived") {
+		t.Fatalf("archived state reported without --warn-archived:\n%s%s", stdout, stderr)
+	}
+}
+
+func TestRun_ExcludeArchivedFromPin(t *testing.T) {
+	routes := checkoutRoutes()
+	routes["/repos/actions/checkout"] = `{"name":"checkout","archived":true}`
+	routes["/repos/actions/setup-go"] = `{"name":"setup-go","archived":false}`
+	routes["/repos/actions/setup-go/releases/latest"] = `{"tag_name":"v5.5.0"}`
+	routes["/repos/actions/setup-go/git/ref/tags/v5.5.0"] = `{"ref":"refs/tags/v5.5.0","object":{"type":"commit","sha":"d35c59abb061a4a6fb18e82ac0862c26744d6ab5"}}`
+
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/setup-go@v5\n")
+	code, _, stderr := runCLI(t, routes, "", "--yes", "--exclude-archived-from-pin", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "skipping actions/checkout (L2:C11): actions/checkout is archived (--exclude-archived-from-pin)") {
+		t.Fatalf("archived action not reported; stderr: %s", stderr)
+	}
+	want := "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/setup-go@d35c59abb061a4a6fb18e82ac0862c26744d6ab5 # v5.5.0\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestRun_PinFile(t *testing.T) {
+	routes := checkoutRoutes()
+	routes["/repos/actions/setup-go/releases/latest"] = `{"tag_name":"v5.5.0"}`
+	routes["/repos/actions/setup-go/git/ref/tags/v5.5.0"] = `{"ref":"refs/tags/v5.5.0","object":{"type":"commit","sha":"d35c59abb061a4a6fb18e82ac0862c26744d6ab5"}}`
+	pinFile := filepath.Join("testdata", "pinfile", "actions.txt")
+
+	code, stdout, stderr := runCLI(t, routes, "", "--pin-file", pinFile)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	want := "actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n" +
+		"actions/setup-go@d35c59abb061a4a6fb18e82ac0862c26744d6ab5 # v5.5.0\n"
+	if stdout != want {
+		t.Fatalf("stdout = %q, want %q", stdout, want)
+	}
+
+	code, stdout, _ = runCLI(t, routes, "", "--export-env", "--pin-file", pinFile)
+	if code != 0 || stdout != "PIN_actions_checkout=11bd71901bbe5b1630ceea73d27597364c9af683\nPIN_actions_setup_go=d35c59abb061a4a6fb18e82ac0862c26744d6ab5\n" {
+		t.Fatalf("--export-env: exit code = %d, stdout = %q", code, stdout)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.txt")
+	if err := os.WriteFile(bad, []byte("actions/checkout@v4\nnot a reference\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	code, _, stderr = runCLI(t, routes, "", "--pin-file", bad)
+	if code != 1 || !strings.Contains(stderr, "bad.txt:2: expected owner/repo@ref") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_ReportsFailedResolutions(t *testing.T) {
+	routes := checkoutRoutes()
+	routes["/repos/foo/missing"] = `{"full_name":"foo/missing"}`
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n  - uses: foo/missing@v9\n")
+	code, stdout, stderr := runCLI(t, routes, "", "--dry-run", path)
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "actions/checkout: v4.2.2 -> 11bd71901bbe5b1630ceea73d27597364c9af683") {
+		t.Fatalf("successful resolution missing from stdout:\n%s", stdout)
+	}
+	if !strings.Contains(stderr, "Failed to resolve:") ||
+		!strings.Contains(stderr, "  - foo/missing@v9 (L3:C11): 404 Not Found for GET http://") ||
+		!strings.Contains(stderr, "/repos/foo/missing/tags") {
+		t.Fatalf("failure not reported with status and URL, stderr:\n%s", stderr)
+	}
+	if strings.Contains(stderr, "actions/checkout") {
+		t.Fatalf("successful action reported as failed, stderr:\n%s", stderr)
+	}
+}
+
+func TestRun_UpdateCommentOnlySkipsTagRefs(t *testing.T) {
+	routes := checkoutRoutes()
+	routes["/repos/actions/checkout/tags"] = `[{"name":"v4.2.2","commit":{"sha":"11bd71901bbe5b1630ceea73d27597364c9af683"}}]`
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683\n")
+
+	// The tag ref is left alone as skipped, not failed, so --on-error skip-file does not trip.
+	code, stdout, stderr := runCLI(t, routes, "", "--yes", "--update-comment-only", "--on-error", "skip-file", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "1 occurrence(s) not pinned to a commit SHA") || strings.Contains(stderr, "Failed to resolve") {
+		t.Fatalf("tag ref not reported as skipped:\nstdout: %s\nstderr: %s", stdout, stderr)
+	}
+	want := "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Fatalf("file = %q, want %q", got, want)
+	}
+}
+
+func TestRun_CommentChangesAreNoop(t *testing.T) {
+	routes := checkoutRoutes()
+	routes["/repos/actions/checkout/tags"] = `[{"name":"v4.2.2","commit":{"sha":"11bd71901bbe5b1630ceea73d27597364c9af683"}}]`
+	commentOnly := writeWorkflow(t, "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.1\n")
+	moving := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+
+	cases := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"comment change counted", []string{"--update-comment-only", "--dry-run", commentOnly}, 2},
+		{"comment change ignored", []string{"--update-comment-only", "--dry-run", "--comment-changes-are-noop", commentOnly}, 0},
+		{"SHA change still counted", []string{"--dry-run", "--comment-changes-are-noop", moving}, 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code, _, stderr := runCLI(t, routes, "", tc.args...); code != tc.want {
+				t.Fatalf("exit code = %d, want %d; stderr: %s", code, tc.want, stderr)
+			}
+		})
+	}
+}
+
+func TestRun_RepoOverride(t *testing.T) {
+	sha := "9f265659d3bb64ab1440b03b12f4d47a24320917"
+	routes := map[string]string{
+		"/repos/acme/checkout-fork/releases/latest":            `{"tag_name":"v4.2.2-acme.1"}`,
+		"/repos/acme/checkout-fork/git/ref/tags/v4.2.2-acme.1": `{"ref":"refs/tags/v4.2.2-acme.1","object":{"type":"commit","sha":"` + sha + `"}}`,
+	}
+	input := "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/setup-go@v5\n"
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"resolve only", nil, "steps:\n  - uses: actions/checkout@" + sha + " # v4.2.2-acme.1\n  - uses: actions/setup-go@v5\n"},
+		{"rewrite", []string{"--rewrite-overrides"}, "steps:\n  - uses: acme/checkout-fork@" + sha + " # v4.2.2-acme.1\n  - uses: actions/setup-go@v5\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeWorkflow(t, input)
+			args := append([]string{"--yes", "--repo-override", "Actions/Checkout=acme/checkout-fork"}, tc.args...)
+			// setup-go is not routed, so it fails to resolve and stays as is
+			runCLI(t, routes, "", append(args, path)...)
+			if got, _ := os.ReadFile(path); string(got) != tc.want {
+				t.Fatalf("file = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRun_PrintSHAs(t *testing.T) {
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/checkout@v4\n")
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--print-shas", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	line := "actions/checkout\t11bd71901bbe5b1630ceea73d27597364c9af683\tv4.2.2\n"
+	if stdout != line+line {
+		t.Fatalf("stdout = %q, want %q", stdout, line+line)
+	}
+
+	if code, _, stderr = runCLI(t, checkoutRoutes(), "", "--print-shas", "--export-env", path); code != 1 || !strings.Contains(stderr, "cannot be combined") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_TreatExactTagsAsPinned(t *testing.T) {
+	input := "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/checkout@v4.1.0\n"
+	path := writeWorkflow(t, input)
+
+	code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--treat-exact-tags-as-pinned", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	want := "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n  - uses: actions/checkout@v4.1.0\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Fatalf("file = %q, want %q", got, want)
+	}
+}
+
+func TestRun_OnError(t *testing.T) {
+	const broken = "steps:\n  - uses: actions/checkout@v4\n  - uses: foo/missing@v9\n"
+	const good = "steps:\n  - uses: actions/checkout@v4\n"
+	const pinned = "actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2"
+
+	cases := []struct {
+		mode                             string
+		wantCode                         int
+		wantBrokenPinned, wantGoodPinned bool
+	}{
+		{"continue", 0, true, true},
+		{"skip-file", 1, false, true},
+		{"abort", 1, false, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.mode, func(t *testing.T) {
+			brokenPath, goodPath := writeWorkflow(t, broken), writeWorkflow(t, good)
+			code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--on-error", tc.mode, brokenPath, goodPath)
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d; stderr: %s", code, tc.wantCode, stderr)
+			}
+			gotBroken, _ := os.ReadFile(brokenPath)
+			gotGood, _ := os.ReadFile(goodPath)
+			if strings.Contains(string(gotBroken), pinned) != tc.wantBrokenPinned {
+				t.Fatalf("file with a failure pinned = %v, want %v:\n%s", !tc.wantBrokenPinned, tc.wantBrokenPinned, gotBroken)
+			}
+			if strings.Contains(string(gotGood), pinned) != tc.wantGoodPinned {
+				t.Fatalf("healthy file pinned = %v, want %v:\n%s", !tc.wantGoodPinned, tc.wantGoodPinned, gotGood)
+			}
+			if tc.mode == "abort" && !strings.Contains(stderr, "Aborting: 1 file(s) not processed") {
+				t.Fatalf("abort not reported, stderr:\n%s", stderr)
+			}
+		})
+	}
+
+	// Only references that fail to resolve stop the run: a file without actions, a missing file
+	// or, under --update-comment-only, tag refs it skips are not resolution failures.
+	routes := checkoutRoutes()
+	routes["/repos/actions/checkout/tags"] = `[{"name":"v4.2.2","commit":{"sha":"11bd71901bbe5b1630ceea73d27597364c9af683"}}]`
+	for _, parallel := range []string{"1", "2"} {
+		runOnly, goodPath := writeWorkflow(t, "steps:\n  - run: make\n"), writeWorkflow(t, good)
+		missing := filepath.Join(t.TempDir(), "missing.yml")
+		code, _, stderr := runCLI(t, routes, "", "--yes", "--on-error", "abort", "--max-parallel-files", parallel, runOnly, missing, goodPath)
+		if code != 1 || strings.Contains(stderr, "Aborting") {
+			t.Fatalf("parallel %s: exit code = %d, want 1 without aborting; stderr: %s", parallel, code, stderr)
+		}
+		if got, _ := os.ReadFile(goodPath); !strings.Contains(string(got), pinned) {
+			t.Fatalf("parallel %s: healthy file after harmless ones was not pinned:\n%s", parallel, got)
+		}
+
+		tagRef, shaPinned := writeWorkflow(t, good), writeWorkflow(t, "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683\n")
+		for _, mode := range []string{"abort", "skip-file"} {
+			code, _, stderr := runCLI(t, routes, "", "--yes", "--update-comment-only", "--on-error", mode, "--max-parallel-files", parallel, tagRef, shaPinned)
+			if code != 0 || strings.Contains(stderr, "Aborting") {
+				t.Fatalf("parallel %s, --update-comment-only --on-error %s: exit code = %d; stderr: %s", parallel, mode, code, stderr)
+			}
+		}
+		if got, _ := os.ReadFile(shaPinned); !strings.Contains(string(got), pinned) {
+			t.Fatalf("parallel %s: comment was not added after a file with tag refs:\n%s", parallel, got)
+		}
+	}
+
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", "--on-error", "panic", writeWorkflow(t, good)); code != 1 || !strings.Contains(stderr, "invalid --on-error") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_DryRunJSON(t *testing.T) {
+	routes := checkoutRoutes()
+	routes["/repos/foo/missing"] = `{"full_name":"foo/missing"}`
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n  - uses: foo/missing@v9\n")
+
+	code, stdout, stderr := runCLI(t, routes, "", "--dry-run", "--json", path)
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2; stderr: %s", code, stderr)
+	}
+	var report map[string]any
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("stdout is not a single JSON document: %v\n%s", err, stdout)
+	}
+	if got, ok := report["schemaVersion"].(float64); !ok || got != dryRunSchemaVersion {
+		t.Fatalf("schemaVersion = %v, want %d", report["schemaVersion"], dryRunSchemaVersion)
+	}
+	changes, _ := report["changes"].([]any)
+	failures, _ := report["failures"].([]any)
+	if report["file"] != path || len(changes) != 1 || len(failures) != 1 {
+		t.Fatalf("unexpected report: %s", stdout)
+	}
+	want := map[string]any{"action": "actions/checkout", "line": 2.0, "column": 11.0, "from": "v4", "to": "11bd71901bbe5b1630ceea73d27597364c9af683", "version": "v4.2.2", "resolvedVia": "latest-release"}
+	for k, v := range want {
+		if changes[0].(map[string]any)[k] != v {
+			t.Fatalf("change %s = %v, want %v", k, changes[0].(map[string]any)[k], v)
+		}
+	}
+
+	if unpinnable, ok := report["unpinnable"].([]any); !ok || len(unpinnable) != 0 {
+		t.Fatalf("unpinnable = %v, want an empty list", report["unpinnable"])
+	}
+
+	// Every reference the tool cannot pin is listed with its reason code.
+	fixture := filepath.Join("testdata", "unpinnable", "refs.yaml")
+	code, stdout, stderr = runCLI(t, routes, "", "--dry-run", "--json", fixture)
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2; stderr: %s", code, stderr)
+	}
+	var withUnpinnable dryRunReport
+	if err := json.Unmarshal([]byte(stdout), &withUnpinnable); err != nil {
+		t.Fatalf("stdout is not a single JSON document: %v\n%s", err, stdout)
+	}
+	var reasons []string
+	for _, ref := range withUnpinnable.Unpinnable {
+		reasons = append(reasons, ref.Reason)
+	}
+	if got := strings.Join(reasons, ","); got != "local,container,expression,no-ref" {
+		t.Fatalf("unpinnable reasons = %s", got)
+	}
+
+	if code, _, stderr := runCLI(t, routes, "", "--json", path); code != 1 || !strings.Contains(stderr, "--json requires --dry-run") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+
+	// A file that ends early still gets its (empty) document, in file order
+	runOnly := writeWorkflow(t, "steps:\n  - run: make\n  - uses: ./local\n")
+	missing := filepath.Join(t.TempDir(), "missing.yml")
+	code, stdout, stderr = runCLI(t, routes, "", "--dry-run", "--json", "--on-error", "continue", runOnly, missing, path)
+	if code != 1 {
+		t.Fatalf("early returns: exit code = %d, want 1 for the missing file; stderr: %s", code, stderr)
+	}
+	dec := json.NewDecoder(strings.NewReader(stdout))
+	for _, want := range []struct {
+		file                          string
+		changes, failures, unpinnable int
+	}{{runOnly, 0, 0, 1}, {missing, 0, 0, 0}, {path, 1, 1, 0}} {
+		var doc dryRunReport
+		if err := dec.Decode(&doc); err != nil {
+			t.Fatalf("no document for %s: %v\n%s", want.file, err, stdout)
+		}
+		if doc.SchemaVersion != dryRunSchemaVersion || doc.File != want.file || len(doc.Changes) != want.changes || len(doc.Failures) != want.failures || len(doc.Unpinnable) != want.unpinnable {
+			t.Fatalf("document = %+v, want %s with %d change(s), %d failure(s), %d unpinnable", doc, want.file, want.changes, want.failures, want.unpinnable)
+		}
+	}
+	if dec.More() {
+		t.Fatalf("more than one document per file:\n%s", stdout)
+	}
+}
+
+// The unpinnable list is progress output: it stays with its file when files are processed in
+// parallel, and moves to stderr when stdout carries the pinned workflow.
+func TestRun_UnpinnableFollowsFileOutput(t *testing.T) {
+	first := writeWorkflow(t, "steps:\n  - uses: ./first-local\n  - uses: actions/checkout@v4\n")
+	second := writeWorkflow(t, "steps:\n  - uses: ./second-local\n  - uses: actions/checkout@v4\n")
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", "--max-parallel-files", "2", first, second)
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2; stderr: %s", code, stderr)
+	}
+	positions := []int{
+		strings.Index(stdout, first),
+		strings.Index(stdout, "./first-local"),
+		strings.Index(stdout, second),
+		strings.Index(stdout, "./second-local"),
+	}
+	if !sort.IntsAreSorted(positions) || positions[0] < 0 {
+		t.Fatalf("unpinnable references not listed with their files (positions %v):\n%s", positions, stdout)
+	}
+
+	input := "steps:\n  - uses: ./local\n  - uses: actions/checkout@v4\n"
+	code, stdout, stderr = runCLI(t, checkoutRoutes(), input, "--yes", "-")
+	if code != 0 {
+		t.Fatalf("stdin: exit code = %d; stderr: %s", code, stderr)
+	}
+	if want := "steps:\n  - uses: ./local\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"; stdout != want {
+		t.Fatalf("stdin: stdout = %q, want only the pinned workflow %q", stdout, want)
+	}
+	if !strings.Contains(stderr, "./local (L2:C11): local") {
+		t.Fatalf("stdin: unpinnable reference not listed on stderr:\n%s", stderr)
+	}
+}
+
+// Files processed in parallel share one resolver, also when their actions are only found
+// through --include-commented, so resolutions land in the shared --resolve-cache-file.
+func TestRun_ParallelSharesResolverWithScanOptions(t *testing.T) {
+	first := writeWorkflow(t, "steps:\n  # - uses: actions/checkout@v4\n")
+	second := writeWorkflow(t, "steps:\n  # - uses: actions/checkout@v4\n")
+	cache := filepath.Join(t.TempDir(), "resolve-cache.json")
+	code, _, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", "--include-commented", "--max-parallel-files", "2", "--resolve-cache-file", cache, first, second)
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2; stderr: %s", code, stderr)
+	}
+	data, err := os.ReadFile(cache)
+	if err != nil || !strings.Contains(string(data), "11bd71901bbe5b1630ceea73d27597364c9af683") {
+		t.Fatalf("resolution not saved to the cache file (%v):\n%s", err, data)
+	}
+}
+
+func TestRun_ErrorFormatParseable(t *testing.T) {
+	routes := checkoutRoutes()
+	routes["/repos/foo/missing"] = `{"full_name":"foo/missing"}`
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n  - uses: foo/missing@v9\n")
+
+	code, _, stderr := runCLI(t, routes, "", "--dry-run", "--error-format", "parseable", path)
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2; stderr: %s", code, stderr)
+	}
+	want := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(path) + `:3:11: foo/missing@v9 failed to resolve: \S.*$`)
+	if !want.MatchString(stderr) || strings.Contains(stderr, "Failed to resolve:") {
+		t.Fatalf("stderr has no parseable failure line:\n%s", stderr)
+	}
+
+	line, msg := yamlErrorLine(validateYAML("name: ci\n on: push\n"))
+	if line != 2 || msg != "mapping values are not allowed in this context" {
+		t.Fatalf("yamlErrorLine() = %d, %q", line, msg)
+	}
+
+	if code, _, stderr := runCLI(t, routes, "", "--error-format", "json", path); code != 1 || !strings.Contains(stderr, "invalid --error-format") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_CheckAndFix(t *testing.T) {
+	const input = "steps:\n  - uses: actions/checkout@v4\n"
+	const want = "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"
+
+	cases := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantFile string
+	}{
+		{"fix applies", []string{"--fix"}, 0, want},
+		{"check reports only", []string{"--check"}, 2, input},
+		{"check fix applies", []string{"--check", "--fix"}, 0, want},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeWorkflow(t, input)
+			if code, _, stderr := runCLI(t, checkoutRoutes(), "", append(tc.args, path)...); code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d; stderr: %s", code, tc.wantCode, stderr)
+			}
+			if got, _ := os.ReadFile(path); string(got) != tc.wantFile {
+				t.Fatalf("file = %q, want %q", got, tc.wantFile)
+			}
+		})
+	}
+
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", "--fix", writeWorkflow(t, input)); code != 1 || !strings.Contains(stderr, "cannot be used with") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_StdinFilename(t *testing.T) {
+	const input = "steps:\n  - uses: actions/checkout@v4\n"
+	const want = "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), input, "--stdin-filename", ".github/workflows/ci.yml", "-")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if stdout != want {
+		t.Fatalf("stdout = %q, want the pinned workflow %q", stdout, want)
+	}
+	if !strings.Contains(stderr, "Scanning workflow") || !strings.Contains(stderr, " .github/workflows/ci.yml\n") || strings.Contains(stderr, "-\n") {
+		t.Fatalf("filename not reported, stderr:\n%s", stderr)
+	}
+
+	code, stdout, stderr = runCLI(t, checkoutRoutes(), input, "--dry-run", "--json", "-")
+	if code != 2 || !strings.Contains(stdout, `"file":"<stdin>"`) {
+		t.Fatalf("exit code = %d, stdout: %s, stderr: %s", code, stdout, stderr)
+	}
+
+	// Nothing to pin: the input is echoed unchanged so filters never lose content.
+	code, stdout, stderr = runCLI(t, checkoutRoutes(), want, "-")
+	if code != 0 || stdout != want {
+		t.Fatalf("exit code = %d, stdout = %q, stderr: %s", code, stdout, stderr)
+	}
+}
+
+func TestRun_RangeFormat(t *testing.T) {
+	const input = "# ci\nsteps:\n  - uses: actions/checkout@v4\n  - run: make\n  - uses: \"actions/checkout@v4\"\n"
+	const want = "# ci\nsteps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n  - run: make\n  - uses: \"actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683\" # v4.2.2\n"
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), input, "--range-format", "-")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	var doc rangeEdits
+	if err := json.Unmarshal([]byte(stdout), &doc); err != nil {
+		t.Fatalf("stdout is not JSON: %v\n%s", err, stdout)
+	}
+	if doc.File != "<stdin>" || len(doc.Edits) != 2 {
+		t.Fatalf("got %+v, want two edits for <stdin>", doc)
+	}
+	// Each edit replaces exactly the @ref (and closing quote) of its occurrence
+	first := strings.Index(input, "@v4\n")
+	second := strings.Index(input, "@v4\"\n")
+	for i, span := range [][2]int{{first, first + len("@v4")}, {second, second + len("@v4\"")}} {
+		if e := doc.Edits[i]; e.Start != span[0] || e.End != span[1] {
+			t.Fatalf("edit %d spans [%d, %d), want [%d, %d)", i, e.Start, e.End, span[0], span[1])
+		}
+	}
+	if got := applyEdits(input, doc.Edits); got != want {
+		t.Fatalf("applied edits = %q, want %q", got, want)
+	}
+
+	// Nothing to pin: an empty edit list
+	code, stdout, _ = runCLI(t, checkoutRoutes(), want, "--range-format", "-")
+	if code != 0 || stdout != `{"file":"<stdin>","edits":[]}`+"\n" {
+		t.Fatalf("exit code = %d, stdout = %q", code, stdout)
+	}
+
+	if code, _, stderr = runCLI(t, checkoutRoutes(), "", "--range-format", writeWorkflow(t, input)); code != 1 || !strings.Contains(stderr, "requires - or --from-ref") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_PrintCurrent(t *testing.T) {
+	const input = "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n  - uses: actions/setup-go@v5\n"
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), input, "--print-current", "--stdin-filename", "ci.yml", "-")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if want := "ci.yml:2:11: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\nci.yml:3:11: actions/setup-go@v5\n"; stdout != want {
+		t.Fatalf("stdout = %q, want %q", stdout, want)
+	}
+	if stderr != "" {
+		t.Fatalf("unexpected stderr: %s", stderr)
+	}
+}
+
+func TestRun_CaseInsensitiveUses(t *testing.T) {
+	const input = "steps:\n  - uses: actions/checkout@v4\n  - Uses: actions/checkout@v4\n"
+	const sha = "11bd71901bbe5b1630ceea73d27597364c9af683"
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), input, "-")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if want := "steps:\n  - uses: actions/checkout@" + sha + " # v4.2.2\n  - Uses: actions/checkout@v4\n"; stdout != want {
+		t.Fatalf("stdout = %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, "Warning: <stdin> (L3:C11): GitHub only accepts a lowercase uses: key, not Uses:; actions/checkout@v4 not pinned") {
+		t.Fatalf("no miscased key warning, stderr:\n%s", stderr)
+	}
+
+	code, stdout, stderr = runCLI(t, checkoutRoutes(), input, "--case-insensitive-uses", "-")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if want := "steps:\n  - uses: actions/checkout@" + sha + " # v4.2.2\n  - Uses: actions/checkout@" + sha + " # v4.2.2\n"; stdout != want {
+		t.Fatalf("stdout = %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, "pinned anyway (--case-insensitive-uses)") {
+		t.Fatalf("no miscased key warning, stderr:\n%s", stderr)
+	}
+}
+
+func TestRun_PostWriteCmd(t *testing.T) {
+	if _, err := exec.LookPath("cp"); err != nil {
+		t.Skip("cp not installed")
+	}
+	const want = "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"
+
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+	copied := filepath.Join(t.TempDir(), "copy.yml")
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--post-write-cmd", "cp {file} "+copied, path); code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got, _ := os.ReadFile(copied); string(got) != want {
+		t.Fatalf("hook saw %q, want the written file %q", got, want)
+	}
+
+	// With --post-write-shell the path is quoted, so shell syntax in it is not interpreted.
+	dir := filepath.Join(t.TempDir(), "it's $(here)")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "ci.yml")
+	if err := os.WriteFile(path, []byte("steps:\n  - uses: actions/checkout@v4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--post-write-shell", "--post-write-cmd", "cat {file} > "+copied+".shell", path); code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if got, _ := os.ReadFile(copied + ".shell"); string(got) != want {
+		t.Fatalf("shell hook saw %q, want %q", got, want)
+	}
+
+	path = writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+	code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--post-write-cmd", "false {file}", path)
+	if code != 1 || !strings.Contains(stderr, "--post-write-cmd for "+path) {
+		t.Fatalf("failing hook: exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_JSONPretty(t *testing.T) {
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+
+	cases := []struct {
+		name   string
+		args   []string
+		pretty bool
+	}{
+		{"compact when not a terminal", nil, false},
+		{"pretty", []string{"--json-pretty"}, true},
+		{"compact", []string{"--json-pretty=false"}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"--dry-run", "--json"}, tc.args...)
+			code, stdout, stderr := runCLI(t, checkoutRoutes(), "", append(args, path)...)
+			if code != 2 {
+				t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+			}
+			var report dryRunReport
+			if err := json.Unmarshal([]byte(stdout), &report); err != nil || report.SchemaVersion != dryRunSchemaVersion {
+				t.Fatalf("invalid report (%v):\n%s", err, stdout)
+			}
+			if lines := strings.Count(stdout, "\n"); (lines > 1) != tc.pretty {
+				t.Fatalf("report spans %d lines, want pretty = %v:\n%s", lines, tc.pretty, stdout)
+			}
+			if tc.pretty && !strings.Contains(stdout, "\n  \"schemaVersion\": 1,\n") {
+				t.Fatalf("report is not indented:\n%s", stdout)
+			}
+		})
+	}
+
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", "--json-pretty", path); code != 1 || !strings.Contains(stderr, "--json-pretty requires --json") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_MaxParallelFiles(t *testing.T) {
+	routes := checkoutRoutes()
+	routes["/repos/actions/setup-go/releases/latest"] = `{"tag_name":"v5.5.0"}`
+	routes["/repos/actions/setup-go/git/ref/tags/v5.5.0"] = `{"ref":"refs/tags/v5.5.0","object":{"type":"commit","sha":"d35c59abb061a4a6fb18e82ac0862c26744d6ab5"}}`
+	files := []string{
+		writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n"),
+		writeWorkflow(t, "steps:\n  - uses: foo/missing@v1\n"),
+		writeWorkflow(t, "steps:\n  - uses: actions/setup-go@v5\n  - uses: actions/checkout@v4\n"),
+	}
+
+	// Each run gets its own test server; errors quoting its URL differ only in the port.
+	port := regexp.MustCompile(`127\.0\.0\.1:\d+`)
+	wantCode, wantStdout, wantStderr := runCLI(t, routes, "", append([]string{"--dry-run"}, files...)...)
+	wantStderr = port.ReplaceAllString(wantStderr, "server")
+	for i := 0; i < 5; i++ {
+		code, stdout, stderr := runCLI(t, routes, "", append([]string{"--dry-run", "--max-parallel-files", "3"}, files...)...)
+		stderr = port.ReplaceAllString(stderr, "server")
+		if code != wantCode || stdout != wantStdout || stderr != wantStderr {
+			t.Fatalf("parallel run differs from sequential run:\ncode %d, want %d\nstdout:\n%s\nwant:\n%s\nstderr:\n%s\nwant:\n%s",
+				code, wantCode, stdout, wantStdout, stderr, wantStderr)
+		}
+	}
+
+	if code, _, stderr := runCLI(t, routes, "", append([]string{"--max-parallel-files", "2"}, files...)...); code != 1 || !strings.Contains(stderr, "needs --dry-run") {
+		t.Fatalf("interactive run: exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_ReportOnlyChanged(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	content := "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/checkout@" + sha + " # v4.2.2\n"
+	want := "actions/checkout@" + sha + " # v4.2.2\n"
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{nil, want + want},
+		{[]string{"--report-only-changed"}, want},
+	} {
+		path := writeWorkflow(t, content)
+		args := append(append([]string{"--yes", "--summary-only"}, tc.args...), path)
+		code, stdout, stderr := runCLI(t, checkoutRoutes(), "", args...)
+		if code != 0 {
+			t.Fatalf("%v: exit code = %d, stderr: %s", tc.args, code, stderr)
+		}
+		if stdout != tc.want {
+			t.Fatalf("%v: stdout = %q, want %q", tc.args, stdout, tc.want)
+		}
+	}
+
+	// Nothing changes in an already pinned file, so nothing is reported.
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@"+sha+" # v4.2.2\n")
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--print-shas", "--report-only-changed", path)
+	if code != 0 || stdout != "" {
+		t.Fatalf("exit code = %d, stdout = %q, stderr: %s", code, stdout, stderr)
+	}
+}
+
+func TestRun_PromptDefault(t *testing.T) {
+	pinned := "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"
+	for _, tc := range []struct {
+		args  []string
+		hint  string
+		write bool
+	}{
+		{nil, "[y/N]", false},
+		{[]string{"--prompt-default", "yes"}, "[Y/n]", true},
+	} {
+		path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+		code, stdout, stderr := runCLI(t, checkoutRoutes(), "\n", append(tc.args, path)...)
+		if code != 0 {
+			t.Fatalf("%v: exit code = %d, stderr: %s", tc.args, code, stderr)
+		}
+		if !strings.Contains(stdout, tc.hint) {
+			t.Fatalf("%v: prompt does not show %s:\n%s", tc.args, tc.hint, stdout)
+		}
+		if got, _ := os.ReadFile(path); (string(got) == pinned) != tc.write {
+			t.Fatalf("%v: Enter wrote the file = %v, want %v", tc.args, string(got) == pinned, tc.write)
+		}
+	}
+
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", "--prompt-default", "maybe", writeWorkflow(t, "")); code != 1 || !strings.Contains(stderr, "invalid --prompt-default") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_EmitRenovateConfig(t *testing.T) {
+	path := writeWorkflow(t, `steps:
+  - uses: actions/setup-go@v5
+  - uses: actions/checkout@v4
+  - uses: github/codeql-action/init@v3
+  - uses: github/codeql-action/analyze@v3
+  - uses: ./local
+`)
+	// No resolution happens, so no API routes are needed.
+	code, stdout, stderr := runCLI(t, nil, "", "--emit-renovate-config", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	var config renovateConfig
+	if err := json.Unmarshal([]byte(stdout), &config); err != nil {
+		t.Fatalf("invalid config (%v):\n%s", err, stdout)
+	}
+	want := []string{"actions/checkout", "actions/setup-go", "github/codeql-action"}
+	if len(config.PackageRules) != 1 || strings.Join(config.PackageRules[0].MatchPackageNames, ",") != strings.Join(want, ",") {
+		t.Fatalf("package rules = %+v, want %v", config.PackageRules, want)
+	}
+	if len(config.CustomManagers) != 0 {
+		t.Fatalf("plain version comments need no regex manager: %+v", config.CustomManagers)
+	}
+
+	// A prefixed comment is only understood through a regex manager, written to --output.
+	output := filepath.Join(t.TempDir(), "renovate.json")
+	code, stdout, stderr = runCLI(t, nil, "", "--emit-renovate-config", "--comment-prefix", "pinned:", "--output", output, path)
+	if code != 0 || stdout != "" {
+		t.Fatalf("exit code = %d, stdout = %q, stderr: %s", code, stdout, stderr)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil || len(config.CustomManagers) != 1 {
+		t.Fatalf("config (%v):\n%s", err, data)
+	}
+	re := regexp.MustCompile(strings.ReplaceAll(config.CustomManagers[0].MatchStrings[0], "(?<", "(?P<"))
+	m := re.FindStringSubmatch(`      - uses: github/codeql-action/init@11bd71901bbe5b1630ceea73d27597364c9af683 # pinned: v3.28.0`)
+	if m == nil || m[re.SubexpIndex("depName")] != "github/codeql-action" || m[re.SubexpIndex("currentValue")] != "v3.28.0" {
+		t.Fatalf("match string %q does not match a pinned line: %q", re, m)
+	}
+
+	if code, _, stderr := runCLI(t, nil, "", "--output", output, path); code != 1 || !strings.Contains(stderr, "--output requires --emit-renovate-config") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_EmitRenovateConfigScanOptions(t *testing.T) {
+	path := writeWorkflow(t, `steps:
+  - uses: actions/checkout@v4
+  - uses: https://ghe.example.com/acme/tool@v1
+  # - uses: actions/cache@v4
+  - Uses: actions/setup-node@v4
+`)
+	for _, tc := range []struct {
+		args []string
+		want []string
+	}{
+		// Full-URL actions are not on github.com, so they are left out.
+		{nil, []string{"actions/checkout"}},
+		{[]string{"--include-commented"}, []string{"actions/cache", "actions/checkout"}},
+		{[]string{"--case-insensitive-uses"}, []string{"actions/checkout", "actions/setup-node"}},
+	} {
+		args := append(append([]string{"--emit-renovate-config"}, tc.args...), path)
+		code, stdout, stderr := runCLI(t, nil, "", args...)
+		if code != 0 {
+			t.Fatalf("%v: exit code = %d, stderr: %s", tc.args, code, stderr)
+		}
+		var config renovateConfig
+		if err := json.Unmarshal([]byte(stdout), &config); err != nil {
+			t.Fatalf("%v: invalid config (%v):\n%s", tc.args, err, stdout)
+		}
+		if len(config.PackageRules) != 1 || strings.Join(config.PackageRules[0].MatchPackageNames, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("%v: package rules = %+v, want %v", tc.args, config.PackageRules, tc.want)
+		}
+	}
+}
+
+func TestRun_IncludeCommented(t *testing.T) {
+	pin := "actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2"
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "steps:\n  - uses: " + pin + "\n  # - uses: actions/checkout@v4\n"},
+		{[]string{"--include-commented"}, "steps:\n  - uses: " + pin + "\n  # - uses: " + pin + "\n"},
+	} {
+		path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n  # - uses: actions/checkout@v4\n")
+		if code, _, stderr := runCLI(t, checkoutRoutes(), "", append(append([]string{"--yes"}, tc.args...), path)...); code != 0 {
+			t.Fatalf("%v: exit code = %d, stderr: %s", tc.args, code, stderr)
+		}
+		if got, _ := os.ReadFile(path); string(got) != tc.want {
+			t.Fatalf("%v: got %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestRun_StatsJSON(t *testing.T) {
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n  - uses: foo/missing@v1\n")
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+	code, _, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", "--stats-json", statsPath, path)
+	if code != 2 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	data, err := os.ReadFile(statsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The schema: exactly these fields, at every level.
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid JSON (%v):\n%s", err, data)
+	}
+	assertKeys := func(what string, m map[string]any, want ...string) {
+		t.Helper()
+		var got []string
+		for k := range m {
+			got = append(got, k)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("%s keys = %v, want %v", what, got, want)
+		}
+	}
+	assertKeys("stats", doc, "schemaVersion", "wallTimeMs", "apiCalls", "apiTimeMs", "cache", "actions")
+	assertKeys("cache", doc["cache"].(map[string]any), "resultHits", "resultMisses", "tagHits", "tagMisses", "results", "tags")
+	actions := doc["actions"].([]any)
+	if len(actions) != 2 {
+		t.Fatalf("actions = %v, want one entry per occurrence", actions)
+	}
+	assertKeys("action", actions[0].(map[string]any), "action", "ref", "durationMs", "cached")
+	assertKeys("failed action", actions[1].(map[string]any), "action", "ref", "durationMs", "cached", "error")
+
+	var stats runStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.SchemaVersion != statsSchemaVersion || stats.APICalls < 3 || stats.WallTimeMs <= 0 || stats.Cache.ResultMisses != 2 {
+		t.Fatalf("unexpected stats:\n%s", data)
+	}
+	if stats.Actions[0].Action != "actions/checkout" || stats.Actions[0].Ref != "v4" || stats.Actions[1].Action != "foo/missing" {
+		t.Fatalf("actions are not sorted by name:\n%s", data)
+	}
+
+	code, stdout, _ := runCLI(t, checkoutRoutes(), "", "--dry-run", "--stats-json", "-", path)
+	if code != 2 || !strings.Contains(stdout, `"apiCalls"`) {
+		t.Fatalf("--stats-json - did not write to stdout:\n%s", stdout)
+	}
+
+	// Stats appended to a --json plan or a rewritten stdin workflow would corrupt it.
+	for _, args := range [][]string{
+		{"--dry-run", "--json", "--stats-json", "-", path},
+		{"--yes", "--stats-json", "-", "-"},
+	} {
+		code, stdout, stderr := runCLI(t, checkoutRoutes(), "steps:\n  - uses: actions/checkout@v4\n", args...)
+		if code != 1 || stdout != "" || !strings.Contains(stderr, "--stats-json - cannot be combined") {
+			t.Fatalf("%v: exit code = %d, stdout = %q, stderr: %s", args, code, stdout, stderr)
+		}
+	}
+}
+
+func TestRun_RequireAttestation(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	attested := checkoutRoutes()
+	attested["/repos/actions/checkout/attestations/sha1:"+sha] = `{"attestations":[{"bundle":{}}]}`
+	content := "steps:\n  - uses: actions/checkout@v4\n"
+
+	path := writeWorkflow(t, content)
+	code, stdout, stderr := runCLI(t, attested, "", "--yes", "--require-attestation", "fail", path)
+	if code != 0 || !strings.Contains(stdout, "actions/checkout@v4.2.2: attested (1)") {
+		t.Fatalf("attested: exit code = %d, stdout:\n%s\nstderr: %s", code, stdout, stderr)
+	}
+	if got, _ := os.ReadFile(path); !strings.Contains(string(got), sha) {
+		t.Fatalf("attested action was not pinned:\n%s", got)
+	}
+
+	path = writeWorkflow(t, content)
+	code, stdout, stderr = runCLI(t, checkoutRoutes(), "", "--yes", "--require-attestation", "warn", path)
+	if code != 0 || !strings.Contains(stdout, "actions/checkout@v4.2.2: missing") || !strings.Contains(stderr, "Warning: actions/checkout@v4.2.2: no build provenance attestation") {
+		t.Fatalf("warn: exit code = %d, stdout:\n%s\nstderr: %s", code, stdout, stderr)
+	}
+	if got, _ := os.ReadFile(path); !strings.Contains(string(got), sha) {
+		t.Fatalf("warn mode did not pin:\n%s", got)
+	}
+
+	path = writeWorkflow(t, content)
+	_, _, stderr = runCLI(t, checkoutRoutes(), "", "--yes", "--require-attestation", "fail", path)
+	if !strings.Contains(stderr, "no build provenance attestation for "+sha) {
+		t.Fatalf("fail: stderr: %s", stderr)
+	}
+	if got, _ := os.ReadFile(path); string(got) != content {
+		t.Fatalf("fail mode pinned an unattested action:\n%s", got)
+	}
+}
+
+func TestRun_InputFormat(t *testing.T) {
+	workflow := "on: push\njobs:\n  build:\n    steps:\n      - uses: actions/checkout@v4\n"
+	composite := "name: setup\nruns:\n  using: composite\n  steps:\n    - uses: actions/checkout@v4\n"
+	node := "name: hello\nruns:\n  using: node20\n  main: index.js\n"
+
+	cases := []struct {
+		name, format, content string
+		wantCode              int
+		wantOut               string
+	}{
+		{"auto workflow", "auto", workflow, 2, ""},
+		{"auto composite action", "auto", composite, 2, ""},
+		{"auto node action", "auto", node, 0, "is a node20 action; only composite actions use other actions"},
+		{"auto empty workflow", "auto", "on: push\njobs:\n  build:\n    steps:\n      - run: make\n", 0, "in the steps of workflow"},
+		{"workflow", "workflow", workflow, 2, ""},
+		{"workflow given an action", "workflow", composite, 1, "not a workflow: no top-level jobs mapping (--input-format workflow)"},
+		{"action", "action", composite, 2, ""},
+		{"action given a workflow", "action", workflow, 1, "not an action: no top-level runs mapping (--input-format action)"},
+		{"action without steps", "action", "runs:\n  using: composite\n  steps:\n    - run: make\n", 0, "in the steps of composite action"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeWorkflow(t, tc.content)
+			code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", "--input-format", tc.format, path)
+			if code != tc.wantCode || !strings.Contains(stdout+stderr, tc.wantOut) {
+				t.Fatalf("exit code = %d, want %d; output does not contain %q:\n%s%s", code, tc.wantCode, tc.wantOut, stdout, stderr)
+			}
+		})
+	}
+
+	if code, _, stderr := runCLI(t, nil, "", "--input-format", "makefile", writeWorkflow(t, workflow)); code != 1 || !strings.Contains(stderr, "invalid --input-format") {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_MultipleHosts(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("testdata", "hosts", "mixed.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "hosts", "mixed.golden.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ghes, fake := newTestClient(t, map[string]string{
+		"/repos/octo-org/deploy-action/releases/latest":     `{"tag_name":"v2.1.0"}`,
+		"/repos/octo-org/deploy-action/git/ref/tags/v2.1.0": `{"ref":"refs/tags/v2.1.0","object":{"type":"commit","sha":"0123456789abcdef0123456789abcdef01234567"}}`,
+	})
+	var clients []string
+	orig := newHostClient
+	newHostClient = func(_ context.Context, host, token string, _ http.Header) (*github.Client, error) {
+		clients = append(clients, host+" "+token)
+		return ghes, nil
+	}
+	t.Cleanup(func() { newHostClient = orig })
+	t.Setenv("GH_ENTERPRISE_TOKEN", "ghes-token")
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), string(input), "-")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if stdout != string(want) {
+		t.Fatalf("stdout = %q, want %q", stdout, want)
+	}
+	if got := strings.Join(clients, ","); got != "ghes.example.com ghes-token" {
+		t.Fatalf("host clients = %s, want one for ghes.example.com", got)
+	}
+	if got := fake.count("/repos/actions/checkout/releases/latest"); got != 0 {
+		t.Fatalf("github.com action was resolved against the enterprise host %d times", got)
+	}
+}
+
+func TestRun_FollowLocalReusable(t *testing.T) {
+	dir := filepath.Join("testdata", "reusable", ".github", "workflows")
+	caller := filepath.Join(dir, "caller.yml")
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", "--json", "--follow-local-reusable", caller)
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2; stderr: %s", code, stderr)
+	}
+	var files []string
+	dec := json.NewDecoder(strings.NewReader(stdout))
+	for dec.More() {
+		var report dryRunReport
+		if err := dec.Decode(&report); err != nil {
+			t.Fatalf("decode report: %v\n%s", err, stdout)
+		}
+		files = append(files, report.File)
+	}
+	// nested.yml calls reusable.yml back; each file is processed once
+	want := []string{caller, filepath.Join(dir, "reusable.yml"), filepath.Join(dir, "nested.yml")}
+	if strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Fatalf("processed %v, want %v", files, want)
+	}
+	if !strings.Contains(stderr, "reusable workflow "+filepath.Join(dir, "missing.yml")+" not found") {
+		t.Fatalf("missing target not reported, stderr: %s", stderr)
+	}
+
+	// Without the flag only the caller is processed.
+	code, stdout, _ = runCLI(t, checkoutRoutes(), "", "--dry-run", "--json", caller)
+	if code != 2 || strings.Count(stdout, `"schemaVersion"`) != 1 {
+		t.Fatalf("exit code = %d, stdout: %s", code, stdout)
+	}
+
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", "--follow-local-reusable", "-"); code != 1 || !strings.Contains(stderr, "--follow-local-reusable") {
+		t.Fatalf("stdin: exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_PrintDiscoveredJSON(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("testdata", "discovered", "refs.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile(filepath.Join("testdata", "discovered", "refs.golden.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, stderr := runCLI(t, nil, string(input), "--print-discovered-json", "--stdin-filename", "refs.yaml", "-")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if stdout != string(golden) {
+		t.Fatalf("stdout =\n%s\nwant\n%s", stdout, golden)
+	}
+}
+
+func TestRun_ConfirmEachFile(t *testing.T) {
+	pinned := "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"
+	first := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+	second := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+
+	// --yes alone would write both; each file is asked about, and only the first is accepted.
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "y\nn\n", "--yes", "--confirm-each-file", first, second)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	for _, path := range []string{first, second} {
+		if !strings.Contains(stdout, "Apply changes to "+path+"?") {
+			t.Fatalf("no prompt for %s:\n%s", path, stdout)
+		}
+	}
+	if change := strings.Index(stdout, "actions/checkout (L2:C11)"); change < 0 || change > strings.Index(stdout, "Apply changes to "+first) {
+		t.Fatalf("planned changes are not shown before the prompt:\n%s", stdout)
+	}
+	if got, _ := os.ReadFile(first); string(got) != pinned {
+		t.Fatalf("accepted file was not written: %q", got)
+	}
+	if got, _ := os.ReadFile(second); string(got) == pinned {
+		t.Fatalf("declined file was written")
+	}
+	if !strings.Contains(stdout, "No changes applied to\u001b[0m "+second) {
+		t.Fatalf("declined file not reported:\n%s", stdout)
+	}
+
+	for _, args := range [][]string{
+		{"--confirm-each-file", "--dry-run", first},
+		{"--confirm-each-file", "--yes", "--max-parallel-files", "2", first, second},
+		{"--confirm-each-file", "-"},
+	} {
+		if code, _, _ := runCLI(t, checkoutRoutes(), "", args...); code != 1 {
+			t.Fatalf("%v: exit code = %d, want 1", args, code)
+		}
+	}
+}
+
+func TestRun_TagFirstPlan(t *testing.T) {
+	const sha = "11bd71901bbe5b1630ceea73d27597364c9af683"
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+
+	for _, args := range [][]string{{}, {"--group-by-action"}} {
+		args = append(args, "--dry-run", "--mode", "tag-first", path)
+		code, stdout, stderr := runCLI(t, checkoutRoutes(), "", args...)
+		if code != 2 {
+			t.Fatalf("%v: exit code = %d, stderr: %s", args, code, stderr)
+		}
+		// The plan shows what is written: the tag kept as the ref, the SHA in the comment.
+		if !strings.Contains(stdout, "v4 → v4  ("+sha[:12]+"…)") || strings.Contains(stdout, "v4 → "+sha[:12]) {
+			t.Fatalf("%v: plan does not match the tag-first pin:\n%s", args, stdout)
+		}
+	}
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", "--json", "--mode", "tag-first", path)
+	if code != 2 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	var report dryRunReport
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("invalid JSON (%v):\n%s", err, stdout)
+	}
+	if len(report.Changes) != 1 || report.Changes[0].To != "v4" || report.Changes[0].SHA != sha || report.Changes[0].Version != "v4.2.2" {
+		t.Fatalf("changes = %+v, want v4 → v4 with SHA %s", report.Changes, sha)
+	}
+}
+
+func TestRun_CommentStyleNoneStrip(t *testing.T) {
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4 # keep me?\n")
+	if code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--comment-style", "none-strip", path); code != 0 {
+		t.Fatalf("exit code = %d; stderr: %s", code, stderr)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683\n"; string(got) != want {
+		t.Fatalf("content = %q, want %q", got, want)
+	}
+
+	for _, args := range [][]string{
+		{"--comment-style", "bogus", path},
+		{"--comment-style", "none-strip", "--mode", "tag-first", path},
+		{"--comment-style", "none-strip", "--comment-prefix", "pinned:", path},
+	} {
+		if code, _, _ := runCLI(t, checkoutRoutes(), "", args...); code != 1 {
+			t.Fatalf("%v: exit code = %d, want 1", args, code)
+		}
+	}
+}
+
+func TestRun_CommentStyleRatchetAndDated(t *testing.T) {
+	const sha = "11bd71901bbe5b1630ceea73d27597364c9af683"
+	tests := []struct {
+		style string
+		want  *regexp.Regexp
+	}{
+		{"ratchet", regexp.MustCompile(`^steps:\n  - uses: actions/checkout@` + sha + ` # ratchet:actions/checkout@v4\.2\.2\n$`)},
+		{"dated", regexp.MustCompile(`^steps:\n  - uses: actions/checkout@` + sha + ` # v4\.2\.2 \(\d{4}-\d{2}-\d{2}\)\n$`)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.style, func(t *testing.T) {
+			path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+			if code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--comment-style", tc.style, path); code != 0 {
+				t.Fatalf("exit code = %d; stderr: %s", code, stderr)
+			}
+			first, _ := os.ReadFile(path)
+			if !tc.want.Match(first) {
+				t.Fatalf("content = %q, want match for %s", first, tc.want)
+			}
+			// A second run, also in comment-only mode, reads the comment back and changes nothing
+			for _, args := range [][]string{{"--yes"}, {"--yes", "--update-comment-only"}} {
+				args = append(args, "--comment-style", tc.style, path)
+				if code, _, stderr := runCLI(t, checkoutRoutes(), "", args...); code != 0 {
+					t.Fatalf("%v: exit code = %d; stderr: %s", args, code, stderr)
+				}
+				if again, _ := os.ReadFile(path); string(again) != string(first) {
+					t.Fatalf("%v: second run changed %q to %q", args, first, again)
+				}
+			}
+
+			for _, args := range [][]string{
+				{"--comment-style", tc.style, "--mode", "tag-first", path},
+				{"--comment-style", tc.style, "--comment-prefix", "pinned:", path},
+			} {
+				if code, _, _ := runCLI(t, checkoutRoutes(), "", args...); code != 1 {
+					t.Fatalf("%v: exit code = %d, want 1", args, code)
+				}
+			}
+		})
+	}
+}
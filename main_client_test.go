@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNewGitHubClient_SendsExtraHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"tag_name":"v1.0.0"}`))
+	}))
+	defer srv.Close()
+
+	headers := headerFlag{}
+	for _, v := range []string{"X-Proxy-Auth=secret", "X-Trace = abc=123"} {
+		if err := headers.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+
+	client := newGitHubClient(context.Background(), "test-token", http.Header(headers))
+	baseURL, _ := url.Parse(srv.URL + "/")
+	client.BaseURL = baseURL
+	if _, _, err := client.Repositories.GetLatestRelease(context.Background(), "actions", "checkout"); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	if v := got.Get("X-Proxy-Auth"); v != "secret" {
+		t.Errorf("X-Proxy-Auth = %q, want %q", v, "secret")
+	}
+	if v := got.Get("X-Trace"); v != "abc=123" {
+		t.Errorf("X-Trace = %q, want %q", v, "abc=123")
+	}
+	if v := got.Get("Authorization"); v != "Bearer test-token" {
+		t.Errorf("Authorization = %q, want the token to still be sent", v)
+	}
+}
+
+func TestHeaderFlag_RejectsMalformed(t *testing.T) {
+	for _, v := range []string{"no-equals", "=value", "  =value"} {
+		if err := (headerFlag{}).Set(v); err == nil {
+			t.Errorf("Set(%q) expected error, got nil", v)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun_ExpandMajorConfig(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	routes := map[string]string{
+		"/repos/actions/checkout/git/ref/tags/v4": `{"ref":"refs/tags/v4","object":{"type":"commit","sha":"` + sha + `"}}`,
+		"/repos/actions/checkout/tags":            `[{"name":"v4.2.2","commit":{"sha":"` + sha + `"}},{"name":"v4","commit":{"sha":"` + sha + `"}}]`,
+	}
+	writeConfig := func(content string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "config.yml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	enabled, disabled := writeConfig("expand_major: true\n"), writeConfig("expand_major: false\n")
+
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"config enables", []string{"--config", enabled}, "# v4.2.2"},
+		{"flag overrides config to false", []string{"--config", enabled, "--expand-major=false"}, "# v4\n"},
+		{"flag overrides config to true", []string{"--config", disabled, "--expand-major"}, "# v4.2.2"},
+		{"config disables", []string{"--config", disabled}, "# v4\n"},
+		{"no config", nil, "# v4\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"--policy", "requested"}, tc.args...)
+			code, stdout, stderr := runCLI(t, routes, "steps:\n  - uses: actions/checkout@v4\n", append(args, "-")...)
+			if code != 0 {
+				t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+			}
+			if !strings.Contains(stdout, "actions/checkout@"+sha+" "+tc.want) {
+				t.Fatalf("stdout = %q, want comment %q", stdout, tc.want)
+			}
+		})
+	}
+
+	if code, _, stderr := runCLI(t, routes, "", "--config", filepath.Join(t.TempDir(), "missing.yml"), "-"); code != 1 || !strings.Contains(stderr, "--config") {
+		t.Fatalf("missing config: exit code = %d, stderr: %s", code, stderr)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newFakeGHCR serves a token endpoint that requires the GitHub token and a manifest endpoint
+// that replays the recorded OCI index for acme/tool:1.2.3.
+func newFakeGHCR(t *testing.T, githubToken string, sendDigestHeader bool) string {
+	t.Helper()
+	manifest, err := os.ReadFile(filepath.Join("testdata", "ghcr", "manifest_index.json"))
+	if err != nil {
+		t.Fatalf("read recorded manifest: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if _, pass, _ := r.BasicAuth(); pass != githubToken || r.URL.Query().Get("scope") != "repository:acme/tool:pull" {
+			http.Error(w, `{"errors":[{"code":"DENIED"}]}`, http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"token":"registry-bearer"}`)
+	})
+	mux.HandleFunc("/v2/acme/tool/manifests/1.2.3", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer registry-bearer" || !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+			http.Error(w, `{"errors":[{"code":"UNAUTHORIZED"}]}`, http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+		if sendDigestHeader {
+			w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(manifest)))
+		}
+		_, _ = w.Write(manifest)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestGHCRRegistry_Digest(t *testing.T) {
+	manifest, err := os.ReadFile(filepath.Join("testdata", "ghcr", "manifest_index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+
+	for _, header := range []bool{true, false} {
+		g := newGHCRRegistry("gh-token")
+		g.baseURL = newFakeGHCR(t, "gh-token", header)
+		got, err := g.digest(context.Background(), "acme/tool", "1.2.3")
+		if err != nil || got != want {
+			t.Fatalf("digest() with header=%v = %q, %v; want %q", header, got, err, want)
+		}
+	}
+
+	g := newGHCRRegistry("wrong-token")
+	g.baseURL = newFakeGHCR(t, "gh-token", true)
+	if _, err := g.digest(context.Background(), "acme/tool", "1.2.3"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("expected a 403 error, got %v", err)
+	}
+}
+
+func TestRun_PinContainers(t *testing.T) {
+	manifest, err := os.ReadFile(filepath.Join("testdata", "ghcr", "manifest_index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	orig := ghcrBaseURL
+	ghcrBaseURL = newFakeGHCR(t, "test-token", true)
+	t.Cleanup(func() { ghcrBaseURL = orig })
+	t.Setenv("PIN_REGISTRY_TOKEN", "")
+
+	input := "steps:\n" +
+		"  - uses: actions/checkout@v4\n" +
+		"  - uses: docker://ghcr.io/acme/tool:1.2.3 # run the tool\n" +
+		"  - uses: docker://ghcr.io/acme/tool@" + digest + "\n" +
+		"  - uses: docker://alpine:3.20\n"
+	path := writeWorkflow(t, input)
+
+	code, _, stderr := runCLI(t, checkoutRoutes(), "", "--yes", "--pin-containers", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	want := "steps:\n" +
+		"  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n" +
+		"  - uses: docker://ghcr.io/acme/tool@" + digest + " # 1.2.3\n" +
+		"  - uses: docker://ghcr.io/acme/tool@" + digest + "\n" +
+		"  - uses: docker://alpine:3.20\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Fatalf("file = %q, want %q", got, want)
+	}
+
+	// Without the flag, container references are left alone and not treated as actions.
+	path = writeWorkflow(t, input)
+	runCLI(t, checkoutRoutes(), "", "--yes", path)
+	if got, _ := os.ReadFile(path); !strings.Contains(string(got), "docker://ghcr.io/acme/tool:1.2.3 # run the tool\n") {
+		t.Fatalf("container reference changed without --pin-containers:\n%s", got)
+	}
+}
+
+func TestScanContainerOccurrences(t *testing.T) {
+	content := "steps:\n" +
+		"  - Uses: docker://ghcr.io/acme/tool:1.2.3\n" +
+		"  # - uses: docker://ghcr.io/acme/old:1.0\n" +
+		"  - uses: docker://ghcr.io/acme/other:2.0\n"
+	for _, tc := range []struct {
+		opts scanOptions
+		want string
+	}{
+		{scanOptions{}, "acme/other"},
+		{scanOptions{anyCaseUses: true}, "acme/tool,acme/other"},
+		{scanOptions{includeCommented: true}, "acme/old,acme/other"},
+	} {
+		var images []string
+		for _, occ := range scanContainerOccurrences(content, tc.opts) {
+			images = append(images, occ.Image)
+		}
+		if got := strings.Join(images, ","); got != tc.want {
+			t.Fatalf("scanContainerOccurrences(%+v) = %s, want %s", tc.opts, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+)
+
+// TestRun_DeterministicOutput locks in that parallelism never shows in the results: however
+// files and resolutions are scheduled, run writes the same bytes and prints byte-for-byte the
+// same stdout and stderr, since each file's output is buffered and flushed in file order.
+// Every run gets different response delays to shuffle the scheduling; run with -race, it also
+// checks the parallel paths for data races.
+func TestRun_DeterministicOutput(t *testing.T) {
+	const runs = 50
+	routes := map[string]string{}
+	var actions []string
+	for i := 0; i < 8; i++ {
+		repo := fmt.Sprintf("action-%d", i)
+		sha := strings.Repeat(fmt.Sprintf("%x", i+1), 40)
+		routes["/repos/octo-org/"+repo+"/releases/latest"] = fmt.Sprintf(`{"tag_name":"v%d.1.0"}`, i+1)
+		routes[fmt.Sprintf("/repos/octo-org/%s/git/ref/tags/v%d.1.0", repo, i+1)] = fmt.Sprintf(`{"ref":"refs/tags/v%d.1.0","object":{"type":"commit","sha":"%s"}}`, i+1, sha)
+		actions = append(actions, repo)
+	}
+	// Each file uses a different mix of the actions, including repeats and one that fails.
+	inputs := map[string]string{}
+	for f := 0; f < 4; f++ {
+		var b strings.Builder
+		b.WriteString("jobs:\n  build:\n    steps:\n")
+		for i := 0; i < 6; i++ {
+			fmt.Fprintf(&b, "      - uses: octo-org/%s@v%d\n", actions[(f+i*3)%len(actions)], (f+i*3)%len(actions)+1)
+		}
+		b.WriteString("      - uses: octo-org/missing@v1\n")
+		inputs[fmt.Sprintf("wf%d.yml", f)] = b.String()
+	}
+	dir := t.TempDir()
+	var files []string
+	for name := range inputs {
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+
+	client, fake := newTestClient(t, routes)
+	t.Setenv("GH_TOKEN", "test-token")
+	orig := newGitHubClient
+	newGitHubClient = func(context.Context, string, http.Header) *github.Client { return client }
+	t.Cleanup(func() { newGitHubClient = orig })
+	random := rand.New(rand.NewSource(1))
+
+	var wantFiles map[string]string
+	var wantStdout, wantStderr string
+	for i := 0; i < runs; i++ {
+		for name, content := range inputs {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		delays := map[string]time.Duration{}
+		for path := range routes {
+			delays[path] = time.Duration(random.Intn(2000)) * time.Microsecond
+		}
+		fake.mu.Lock()
+		fake.delays = delays
+		fake.mu.Unlock()
+
+		var stdout, stderr bytes.Buffer
+		args := append([]string{"--yes", "--on-error", "continue", "--max-parallel-files", "4", "--concurrency", "3"}, files...)
+		if code := run(args, strings.NewReader(""), &stdout, &stderr); code != 0 {
+			t.Fatalf("run %d: exit code = %d, want 0 (failures are reported, not fatal); stderr: %s", i, code, stderr.String())
+		}
+		written := map[string]string{}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			written[file] = string(data)
+		}
+
+		if i == 0 {
+			wantFiles, wantStdout, wantStderr = written, stdout.String(), stderr.String()
+			if written[files[0]] == inputs["wf0.yml"] {
+				t.Fatalf("nothing was pinned:\n%s", stdout.String())
+			}
+			continue
+		}
+		for _, file := range files {
+			if written[file] != wantFiles[file] {
+				t.Fatalf("run %d wrote %s differently:\n%s\nwant\n%s", i, filepath.Base(file), written[file], wantFiles[file])
+			}
+		}
+		if stdout.String() != wantStdout {
+			t.Fatalf("run %d: stdout differs:\n%s\nwant\n%s", i, stdout.String(), wantStdout)
+		}
+		if stderr.String() != wantStderr {
+			t.Fatalf("run %d: stderr differs:\n%s\nwant\n%s", i, stderr.String(), wantStderr)
+		}
+	}
+}
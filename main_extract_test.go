@@ -1,10 +1,13 @@
 package main
 
 import (
+    "fmt"
     "os"
     "path/filepath"
     "strings"
     "testing"
+
+    "gopkg.in/yaml.v3"
 )
 
 func TestExtractOccurrences_Spacing(t *testing.T) {
@@ -106,4 +109,510 @@ func TestExtractOccurrences_Multiple(t *testing.T) {
     if strings.Join(got, ";") != strings.Join(want, ";") {
         t.Fatalf("got %v, want %v", got, want)
     }
-}
\ No newline at end of file
+}
+
+func TestExtractOccurrences_BOM(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "bom.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	if !strings.HasPrefix(string(content), utf8BOM) {
+		t.Fatalf("fixture should start with a UTF-8 BOM")
+	}
+
+	occs := extractOccurrences(string(content))
+	if len(occs) != 2 {
+		t.Fatalf("expected 2 occurrences, got %d", len(occs))
+	}
+	if occs[0].Line != 1 || occs[0].Column != 11 {
+		t.Fatalf("occ[0] at L%d:C%d, want L1:C11", occs[0].Line, occs[0].Column)
+	}
+	if occs[1].Line != 2 || occs[1].Column != 11 {
+		t.Fatalf("occ[1] at L%d:C%d, want L2:C11", occs[1].Line, occs[1].Column)
+	}
+
+	infos := []ActionInfo{
+		{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"},
+		{Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"},
+	}
+	got := updateContent(string(content), occs, infos, CommentStyle{})
+	want := utf8BOM + "  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n" +
+		"  - uses: actions/setup-go@d35c59abb061a4a6fb18e82ac0862c26744d6ab5 # v5.5.0\n"
+	if got != want {
+		t.Fatalf("updateContent() = %q, want %q", got, want)
+	}
+}
+
+func TestUpdateContent_TrailingWhitespace(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "trailing_whitespace.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	occs := extractOccurrences(string(content))
+	if len(occs) != 4 {
+		t.Fatalf("expected 4 occurrences, got %d", len(occs))
+	}
+	if occs[1].Comment != "old comment" {
+		t.Fatalf("occ[1].Comment = %q, want %q", occs[1].Comment, "old comment")
+	}
+
+	infos := []ActionInfo{
+		{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"},
+		{Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"},
+		{Owner: "actions", Repo: "cache", Version: "v4.2.3", SHA: "5a3ec84eff668545956fd18022155c47e93e2684"},
+		{Owner: "actions", Repo: "upload-artifact", Version: "v4.6.2", SHA: "ea165f8d65b6e75b540449e92b4886f43607fa02"},
+	}
+	got := updateContent(string(content), occs, infos, CommentStyle{})
+	want := "name: Trailing Whitespace\n" +
+		"jobs:\n" +
+		"  test:\n" +
+		"    runs-on: ubuntu-latest\n" +
+		"    steps:\n" +
+		"      - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n" +
+		"      - uses: actions/setup-go@d35c59abb061a4a6fb18e82ac0862c26744d6ab5 # v5.5.0\n" +
+		"      - uses: actions/cache@5a3ec84eff668545956fd18022155c47e93e2684 # v4.2.3\n" +
+		"      - uses: actions/upload-artifact@ea165f8d65b6e75b540449e92b4886f43607fa02 # v4.6.2\r\n" +
+		"        # not a version comment\n"
+	if got != want {
+		t.Fatalf("updateContent() = %q, want %q", got, want)
+	}
+}
+
+func TestExcludeOwners(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "multiple.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	occs, skipped := excludeOwners(extractOccurrences(string(content)), splitList(" actions, ,octo-org"), false)
+	if skipped != 2 {
+		t.Fatalf("skipped = %d, want 2", skipped)
+	}
+	if len(occs) != 1 || occs[0].Action != "github/super-linter" {
+		t.Fatalf("kept %+v, want only github/super-linter", occs)
+	}
+}
+
+func TestExtractOccurrences_TrailingArtifacts(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "trailing_artifacts.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	occs := extractOccurrences(string(content))
+	got := make([]string, 0, len(occs))
+	for _, oc := range occs {
+		got = append(got, oc.Action+"@"+oc.RequestedRef)
+	}
+	want := []string{"actions/checkout@v4", "actions/setup-go@v5", "actions/cache@v4.2.3"}
+	if strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	infos := []ActionInfo{
+		{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"},
+		{Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"},
+		{Owner: "actions", Repo: "cache", Version: "v4.2.3", SHA: "5a3ec84eff668545956fd18022155c47e93e2684"},
+	}
+	updated := updateContent(string(content), occs, infos, CommentStyle{})
+	for _, line := range []string{
+		"      - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n",
+		"      - uses: actions/setup-go@d35c59abb061a4a6fb18e82ac0862c26744d6ab5 # v5.5.0\n",
+		"      - uses: actions/cache@5a3ec84eff668545956fd18022155c47e93e2684 # v4.2.3\n",
+		"      - uses: actions/upload-artifact@/\n",
+	} {
+		if !strings.Contains(updated, line) {
+			t.Errorf("updated content missing %q:\n%s", line, updated)
+		}
+	}
+
+	// Already-pinned SHAs with a trailing slash are cleaned up too
+	pinned := "- uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683/ # v4.2.2\n"
+	occs = extractOccurrences(pinned)
+	if got := updateContent(pinned, occs, infos[:1], CommentStyle{}); got != "- uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n" {
+		t.Fatalf("updateContent() = %q", got)
+	}
+}
+
+func TestUpdateContent_CommentPrefixIdempotent(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "comment_prefix.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	golden, err := os.ReadFile(filepath.Join("testdata", "extract", "comment_prefix.golden.yaml"))
+	if err != nil {
+		t.Fatalf("read golden: %v", err)
+	}
+
+	style := CommentStyle{Prefix: "pinned:"}
+	infos := []ActionInfo{
+		{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"},
+		{Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"},
+	}
+
+	first := updateContent(string(content), extractOccurrences(string(content)), infos, style)
+	if first != string(golden) {
+		t.Fatalf("first pass = %q, want %q", first, golden)
+	}
+
+	occs := extractOccurrences(first)
+	for i, oc := range occs {
+		if got := currentVersion(oc, style); got != infos[i].Version {
+			t.Fatalf("occ[%d] current version = %q, want %q", i, got, infos[i].Version)
+		}
+	}
+	if second := updateContent(first, occs, infos, style); second != first {
+		t.Fatalf("second pass changed content:\n%s", second)
+	}
+	if again, added, corrected := updateComments(first, occs, infos, style); again != first || added != 0 || corrected != 0 {
+		t.Fatalf("updateComments() changed prefixed comments (added=%d corrected=%d):\n%s", added, corrected, again)
+	}
+}
+
+func TestExtractOccurrences_WindowsLocalPaths(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "windows_local.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	occs := extractOccurrences(string(content))
+	if len(occs) != 1 || occs[0].Action != "actions/checkout" {
+		t.Fatalf("expected only actions/checkout, got %+v", occs)
+	}
+	if actions := extractActions(string(content)); len(actions) != 1 || actions[0] != "actions/checkout" {
+		t.Fatalf("extractActions() = %v, want [actions/checkout]", actions)
+	}
+}
+
+func TestExcludeExactTags(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "exact_tags.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	occs, skipped := excludeExactTags(extractOccurrences(string(content)))
+	if skipped != 3 {
+		t.Fatalf("skipped = %d, want 3", skipped)
+	}
+	var kept []string
+	for _, occ := range occs {
+		kept = append(kept, occ.Action+"@"+occ.RequestedRef)
+	}
+	if want := "actions/setup-go@v5 actions/cache@v4.2 octo-org/deploy@main"; strings.Join(kept, " ") != want {
+		t.Fatalf("kept %v, want %s", kept, want)
+	}
+}
+
+// TestUpdateContent_NoDoubleComments guards against a rewrite appending a second version
+// comment (`@<sha> # v4.1.0 # v4.2.2`) instead of replacing the old one, across spacing,
+// quoting and line ending variants, for both full rewrites and comment-only passes.
+func TestUpdateContent_NoDoubleComments(t *testing.T) {
+	info := ActionInfo{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"}
+	infos := func(occs []ActionOccurrence) []ActionInfo {
+		out := make([]ActionInfo, len(occs))
+		for i := range out {
+			out[i] = info
+		}
+		return out
+	}
+	assertSingleComments := func(t *testing.T, content string) {
+		t.Helper()
+		for i, line := range strings.Split(content, "\n") {
+			if strings.Contains(line, "uses:") && strings.Count(line, "#") > 1 {
+				t.Fatalf("line %d has more than one comment: %q", i+1, line)
+			}
+		}
+	}
+
+	for _, name := range []string{"double_comment.yaml", "double_comment_crlf.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			content, err := os.ReadFile(filepath.Join("testdata", "extract", name))
+			if err != nil {
+				t.Fatalf("read fixture: %v", err)
+			}
+
+			occs := extractOccurrences(string(content))
+			pinned := updateContent(string(content), occs, infos(occs), CommentStyle{})
+			assertSingleComments(t, pinned)
+			if strings.Count(pinned, "\"") != strings.Count(string(content), "\"") || strings.Count(pinned, "'") != strings.Count(string(content), "'") {
+				t.Fatalf("quotes were not preserved:\n%s", pinned)
+			}
+
+			occs = extractOccurrences(pinned)
+			if again := updateContent(pinned, occs, infos(occs), CommentStyle{}); again != pinned {
+				t.Fatalf("second pass changed content:\n%s", again)
+			}
+			commented, _, _ := updateComments(pinned, occs, infos(occs), CommentStyle{})
+			assertSingleComments(t, commented)
+			for _, occ := range extractOccurrences(commented) {
+				if occ.Comment != "v4.2.2" {
+					t.Fatalf("L%d comment = %q, want v4.2.2", occ.Line, occ.Comment)
+				}
+			}
+
+			for _, style := range []CommentStyle{{Prefix: "pinned:"}, {Prefix: "renovate"}} {
+				occs = extractOccurrences(commented)
+				prefixed, _, _ := updateComments(commented, occs, infos(occs), style)
+				assertSingleComments(t, prefixed)
+			}
+		})
+	}
+
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "double_comment.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	golden, err := os.ReadFile(filepath.Join("testdata", "extract", "double_comment.golden.yaml"))
+	if err != nil {
+		t.Fatalf("read golden: %v", err)
+	}
+	occs := extractOccurrences(string(content))
+	if got := updateContent(string(content), occs, infos(occs), CommentStyle{}); got != string(golden) {
+		t.Fatalf("updateContent() =\n%s\nwant\n%s", got, golden)
+	}
+}
+
+func TestExcludeOwners_CaseInsensitive(t *testing.T) {
+	occs := extractOccurrences("steps:\n  - uses: Actions/Checkout@v4\n  - uses: actions/setup-go@v5\n  - uses: Octo-Org/deploy@v1\n")
+
+	kept, skipped := excludeOwners(occs, []string{"actions", "OCTO-ORG"}, false)
+	if skipped != 3 || len(kept) != 0 {
+		t.Fatalf("case-insensitive: kept %+v, skipped %d; want all 3 skipped", kept, skipped)
+	}
+
+	kept, skipped = excludeOwners(occs, []string{"actions", "OCTO-ORG"}, true)
+	if skipped != 1 || len(kept) != 2 || kept[0].Action != "Actions/Checkout" || kept[1].Action != "Octo-Org/deploy" {
+		t.Fatalf("case-sensitive: kept %+v, skipped %d; want only actions/setup-go skipped", kept, skipped)
+	}
+}
+
+// Steps reused through a YAML alias exist once in the text, so they are found and pinned once,
+// at the anchor, and every alias picks up the pinned step.
+func TestExtractOccurrences_AnchoredStep(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "anchors.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	occs := extractOccurrences(string(content))
+	if len(occs) != 1 || occs[0].Line != 9 || occs[0].Column != 15 {
+		t.Fatalf("got %+v, want one occurrence at the anchor (L9:C15)", occs)
+	}
+	if actions := extractActions(string(content)); len(actions) != 1 {
+		t.Fatalf("extractActions() = %v, want one action", actions)
+	}
+
+	golden, err := os.ReadFile(filepath.Join("testdata", "extract", "anchors.golden.yaml"))
+	if err != nil {
+		t.Fatalf("read golden: %v", err)
+	}
+	info := ActionInfo{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"}
+	pinned := updateContent(string(content), occs, []ActionInfo{info}, CommentStyle{})
+	if pinned != string(golden) {
+		t.Fatalf("updateContent() =\n%s\nwant\n%s", pinned, golden)
+	}
+
+	var workflow struct {
+		Jobs map[string]struct {
+			Steps []map[string]any `yaml:"steps"`
+		} `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal([]byte(pinned), &workflow); err != nil {
+		t.Fatalf("pinned workflow does not parse: %v", err)
+	}
+	want := "actions/checkout@" + info.SHA
+	if got := workflow.Jobs["test"].Steps[0]["uses"]; got != want {
+		t.Fatalf("aliased step uses %v, want %s", got, want)
+	}
+}
+
+func TestExtractOccurrences_CommentedOut(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "commented.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	names := func(occs []ActionOccurrence) string {
+		var out []string
+		for _, occ := range occs {
+			out = append(out, occ.Action)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if got, want := names(extractOccurrences(string(content))), "actions/checkout,actions/upload-artifact"; got != want {
+		t.Fatalf("extractOccurrences() = %s, want %s", got, want)
+	}
+	if got, want := strings.Join(extractActions(string(content)), ","), "actions/checkout,actions/upload-artifact"; got != want {
+		t.Fatalf("extractActions() = %s, want %s", got, want)
+	}
+
+	all := "actions/checkout,actions/setup-go,actions/cache,foo/bar,actions/upload-artifact,actions/download-artifact"
+	if got := names(scanOccurrences(string(content), scanOptions{includeCommented: true})); got != all {
+		t.Fatalf("scanOccurrences(includeCommented) = %s, want %s", got, all)
+	}
+	if got := strings.Join(scanActions(string(content), scanOptions{includeCommented: true}), ","); got != all {
+		t.Fatalf("scanActions(includeCommented) = %s, want %s", got, all)
+	}
+}
+
+func TestReadIgnoreFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".pin-ignore")
+	content := "# internal actions are pinned by their own release process\nmy-org/*\n\n  actions/cache   # pinned by hand\n#octo-org/deploy\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := readIgnoreFile(path)
+	if err != nil {
+		t.Fatalf("readIgnoreFile: %v", err)
+	}
+	if strings.Join(patterns, ",") != "my-org/*,actions/cache" {
+		t.Fatalf("patterns = %q, want [my-org/* actions/cache]", patterns)
+	}
+
+	// Merged with inline --ignore flags by the caller; matching ignores case.
+	occs := extractOccurrences("steps:\n  - uses: My-Org/build@v1\n  - uses: actions/cache@v4\n  - uses: actions/checkout@v4\n  - uses: octo-org/deploy@v1\n")
+	kept, skipped := ignoreActions(occs, append(patterns, "octo-*/deploy"), false)
+	if skipped != 3 || len(kept) != 1 || kept[0].Action != "actions/checkout" {
+		t.Fatalf("kept %+v, skipped %d; want only actions/checkout kept", kept, skipped)
+	}
+	// With --owner-case-insensitive=false, My-Org no longer matches my-org/*
+	if kept, skipped := ignoreActions(occs, append(patterns, "octo-*/deploy"), true); skipped != 2 || len(kept) != 2 || kept[0].Action != "My-Org/build" {
+		t.Fatalf("case-sensitive: kept %+v, skipped %d; want My-Org/build and actions/checkout kept", kept, skipped)
+	}
+
+	if err := os.WriteFile(path, []byte("my-org/[\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readIgnoreFile(path); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Fatalf("expected an invalid pattern error with its line, got %v", err)
+	}
+}
+
+// The discovered list must read in the same order as the per-occurrence resolution output, even
+// with duplicates, interleaving and references the resolver never sees.
+func TestDiscoveredActions_OccurrenceOrder(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "interleaved.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	for _, includeCommented := range []bool{false, true} {
+		occs := scanOccurrences(string(content), scanOptions{includeCommented: includeCommented})
+		got := discoveredActions(scanActions(string(content), scanOptions{includeCommented: includeCommented}), occs)
+
+		var firstSeen []string
+		seen := make(map[string]bool)
+		for _, occ := range occs {
+			if !seen[occ.Action] {
+				seen[occ.Action] = true
+				firstSeen = append(firstSeen, occ.Action)
+			}
+		}
+		if strings.Join(got[:len(firstSeen)], ",") != strings.Join(firstSeen, ",") {
+			t.Fatalf("includeCommented=%t: discovered %v, want first occurrences %v first", includeCommented, got, firstSeen)
+		}
+		want := "actions/checkout,actions/cache,github/codeql-action/init,actions/setup-go,github/codeql-action/analyze"
+		if includeCommented {
+			// Inside the trailing comment of a pinnable occurrence: listed, not resolved
+			want += ",octo-org/commented"
+		}
+		if strings.Join(got, ",") != want {
+			t.Fatalf("includeCommented=%t: discovered %v, want %s", includeCommented, got, want)
+		}
+	}
+}
+
+func TestScanOccurrences_MiscasedUses(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "uses_case.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	names := func(occs []ActionOccurrence) string {
+		var out []string
+		for _, occ := range occs {
+			out = append(out, occ.Action)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if got := names(extractOccurrences(string(content))); got != "actions/checkout" {
+		t.Fatalf("extractOccurrences() = %s, want only the lowercase uses:", got)
+	}
+	if got := names(scanOccurrences(string(content), scanOptions{anyCaseUses: true})); got != "actions/checkout,actions/setup-go,actions/cache" {
+		t.Fatalf("scanOccurrences(anyCaseUses) = %s", got)
+	}
+	if got := strings.Join(scanActions(string(content), scanOptions{anyCaseUses: true}), ","); got != "actions/checkout,actions/setup-go,actions/cache" {
+		t.Fatalf("scanActions(anyCaseUses) = %s", got)
+	}
+
+	miscased := miscasedUses(string(content), scanOptions{})
+	if names(miscased) != "actions/setup-go,actions/cache" || miscased[0].Line != 6 || miscased[1].Line != 8 {
+		t.Fatalf("miscasedUses() = %+v", miscased)
+	}
+	if key := usesKey(string(content), miscased[1]); key != "USES" {
+		t.Fatalf("usesKey() = %q, want USES", key)
+	}
+}
+
+func TestScanOccurrences_FullURLHost(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "hosts", "mixed.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	var got []string
+	for _, occ := range extractOccurrences(string(content)) {
+		got = append(got, occ.Host+"|"+occ.Owner+"/"+occ.Repo)
+	}
+	want := "|actions/checkout,ghes.example.com|octo-org/deploy-action,|actions/checkout"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("hosts = %s, want %s", strings.Join(got, ","), want)
+	}
+}
+
+func TestFindUnpinnable_ReasonCodes(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "unpinnable", "refs.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	var got []string
+	for _, ref := range findUnpinnable(string(content), scanOptions{}, false) {
+		got = append(got, fmt.Sprintf("%d:%d %s %s", ref.Line, ref.Column, ref.Reason, ref.Uses))
+	}
+	want := []string{
+		"8:15 local ./.github/actions/setup",
+		"9:15 container docker://alpine:3.20",
+		"11:15 expression ${{ matrix.action }}",
+		"12:15 no-ref actions/cache",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("findUnpinnable() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestUpdateContent_StripCommentsIdempotent(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "strip", "input.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	golden, err := os.ReadFile(filepath.Join("testdata", "strip", "input.golden.yaml"))
+	if err != nil {
+		t.Fatalf("read golden: %v", err)
+	}
+
+	// The last action is already pinned to its SHA: its line is not rewritten, so its
+	// comment stays.
+	style := CommentStyle{Strip: true}
+	infos := []ActionInfo{
+		{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"},
+		{Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"},
+		{Owner: "actions", Repo: "cache", Version: "v4.2.3", SHA: "5a3ec84eff668545956fd18022155c47e93e2684"},
+		{Owner: "actions", Repo: "upload-artifact", Version: "v4.6.2", SHA: "ea165f8d65b6e75b540449e92b4886f43607fa02"},
+	}
+
+	first := updateContent(string(content), extractOccurrences(string(content)), infos, style)
+	if first != string(golden) {
+		t.Fatalf("first pass = %q, want %q", first, golden)
+	}
+	if second := updateContent(first, extractOccurrences(first), infos, style); second != first {
+		t.Fatalf("second pass changed content:\n%s", second)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+// TestIdempotency_CommentStyles runs every comment configuration twice: the first pass pins the
+// workflow, and feeding its output back through extraction and update must change nothing.
+func TestIdempotency_CommentStyles(t *testing.T) {
+	input := `steps:
+  - uses: actions/checkout@v4
+  - uses: actions/checkout@v4 # keep me
+  - uses: actions/setup-go@v5.4.0   # v5.4.0
+  - uses: actions/cache@5a3ec84eff668545956fd18022155c47e93e2684
+  - uses: actions/upload-artifact@ea165f8d65b6e75b540449e92b4886f43607fa02 # pinned: v4.6.1
+  - uses: ./local-action
+`
+	infoFor := map[string]ActionInfo{
+		"actions/checkout":        {Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"},
+		"actions/setup-go":        {Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"},
+		"actions/cache":           {Owner: "actions", Repo: "cache", Version: "v4.2.3", SHA: "5a3ec84eff668545956fd18022155c47e93e2684"},
+		"actions/upload-artifact": {Owner: "actions", Repo: "upload-artifact", Version: "v4.6.2", SHA: "ea165f8d65b6e75b540449e92b4886f43607fa02"},
+	}
+	infos := func(occurrences []ActionOccurrence) []ActionInfo {
+		out := make([]ActionInfo, len(occurrences))
+		for i, occ := range occurrences {
+			out[i] = infoFor[occ.Owner+"/"+occ.Repo]
+		}
+		return out
+	}
+
+	styles := []struct {
+		name  string
+		style CommentStyle
+	}{
+		{"standard", CommentStyle{}},
+		{"prefixed", CommentStyle{Prefix: "pinned:"}},
+		{"prefixed without colon", CommentStyle{Prefix: "renovate"}},
+		{"prefixed with symbols", CommentStyle{Prefix: "@pin"}},
+		{"ratchet", CommentStyle{Ratchet: true}},
+		{"dated", CommentStyle{Date: "2024-01-15"}},
+	}
+
+	for _, tc := range styles {
+		t.Run(tc.name, func(t *testing.T) {
+			occurrences := extractOccurrences(input)
+			first := updateContent(input, occurrences, infos(occurrences), tc.style)
+			if first == input {
+				t.Fatalf("first pass changed nothing")
+			}
+			occurrences = extractOccurrences(first)
+			if second := updateContent(first, occurrences, infos(occurrences), tc.style); second != first {
+				t.Fatalf("second pass is not a no-op:\nfirst:\n%s\nsecond:\n%s", first, second)
+			}
+
+			// The comment-only pass is idempotent on its own output as well.
+			occurrences = extractOccurrences(input)
+			commented, _, _ := updateComments(input, occurrences, infos(occurrences), tc.style)
+			occurrences = extractOccurrences(commented)
+			again, added, corrected := updateComments(commented, occurrences, infos(occurrences), tc.style)
+			if added != 0 || corrected != 0 || again != commented {
+				t.Fatalf("second comment-only pass: %d added, %d corrected:\nfirst:\n%s\nsecond:\n%s", added, corrected, commented, again)
+			}
+		})
+	}
+
+	// A dated comment written on an earlier day is kept by later runs.
+	earlier, later := CommentStyle{Date: "2024-01-15"}, CommentStyle{Date: "2025-06-30"}
+	occurrences := extractOccurrences(input)
+	pinned := updateContent(input, occurrences, infos(occurrences), earlier)
+	occurrences = extractOccurrences(pinned)
+	first, _, _ := updateComments(pinned, occurrences, infos(occurrences), earlier)
+	occurrences = extractOccurrences(first)
+	if second := updateContent(first, occurrences, infos(occurrences), later); second != first {
+		t.Fatalf("later dated run is not a no-op:\nfirst:\n%s\nsecond:\n%s", first, second)
+	}
+	if again, added, corrected := updateComments(first, occurrences, infos(occurrences), later); added != 0 || corrected != 0 || again != first {
+		t.Fatalf("later dated comment-only run: %d added, %d corrected:\n%s", added, corrected, again)
+	}
+}
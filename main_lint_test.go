@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintCheckout(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "lint", "checkout.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	findings := lintCheckout(string(content))
+	if len(findings) != 2 {
+		t.Fatalf("got %d finding(s), want 2: %+v", len(findings), findings)
+	}
+	// The default is reported at the step's first key (name:), the explicit true at uses:.
+	if f := findings[0]; f.Line != 7 || f.Column != 9 || !strings.Contains(f.Message, "defaults to true") {
+		t.Fatalf("findings[0] = %+v", f)
+	}
+	if f := findings[1]; f.Line != 9 || f.Column != 9 || !strings.Contains(f.Message, "persist-credentials: true") {
+		t.Fatalf("findings[1] = %+v", f)
+	}
+}
+
+func TestRun_LintCheckout(t *testing.T) {
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+	_, _, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", "--lint-checkout", path)
+	if !strings.Contains(stderr, "Warning: actions/checkout (L2:C5): persist-credentials defaults to true") {
+		t.Fatalf("missing advisory:\n%s", stderr)
+	}
+	if _, _, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", path); strings.Contains(stderr, "persist-credentials") {
+		t.Fatalf("advisory printed without --lint-checkout:\n%s", stderr)
+	}
+}
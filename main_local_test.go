@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	semver "github.com/Masterminds/semver/v3"
+)
+
+// newMirrorFixture builds <root>/actions/checkout.git, a bare mirror with three commits:
+// v4.2.1 (lightweight), v4.2.2 (annotated) and the moving v4 on the second, v5.0.0 on the
+// third, the tip of the default branch trunk. It returns the root and the commit SHAs in order.
+func newMirrorFixture(t *testing.T) (string, []string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	work := t.TempDir()
+	root := t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "tag.gpgSign=false", "-c", "commit.gpgSign=false"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	git(work, "init", "-q", "--initial-branch", "trunk")
+	var commits []string
+	for i, tags := range [][]string{{"v4.2.1"}, {"-a v4.2.2", "v4"}, {"v5.0.0"}} {
+		if err := os.WriteFile(filepath.Join(work, "action.yml"), []byte(strings.Repeat("x", i+1)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git(work, "add", ".")
+		git(work, "commit", "-q", "-m", "commit")
+		commits = append(commits, git(work, "rev-parse", "HEAD"))
+		for _, tag := range tags {
+			if name, ok := strings.CutPrefix(tag, "-a "); ok {
+				git(work, "tag", "-a", "-m", name, name)
+			} else {
+				git(work, "tag", tag)
+			}
+		}
+	}
+	git(root, "clone", "-q", "--mirror", work, filepath.Join(root, "actions", "checkout.git"))
+	return root, commits
+}
+
+func TestLocalMirrors_Resolve(t *testing.T) {
+	root, commits := newMirrorFixture(t)
+
+	cases := []struct {
+		name        string
+		opts        ResolveOptions
+		ref         string
+		wantVersion string
+		wantSHA     string
+	}{
+		{"major picks highest semver", ResolveOptions{Policy: UpdatePolicyMajor}, "v4", "v5.0.0", commits[2]},
+		{"same major", ResolveOptions{Policy: UpdatePolicySameMajor}, "v4", "v4.2.2", commits[1]},
+		{"requested moving major", ResolveOptions{Policy: UpdatePolicyRequested}, "v4", "v4", commits[1]},
+		{"requested moving major expanded", ResolveOptions{Policy: UpdatePolicyRequested, ExpandMajor: true}, "v4", "v4.2.2", commits[1]},
+		{"requested exact tag", ResolveOptions{Policy: UpdatePolicyRequested}, "v4.2.1", "v4.2.1", commits[0]},
+		{"requested short SHA", ResolveOptions{Policy: UpdatePolicyRequested}, commits[0][:7], "v4.2.1", commits[0]},
+		{"comment only", ResolveOptions{CommentOnly: true}, commits[1], "v4.2.2", commits[1]},
+		{"head", ResolveOptions{Policy: UpdatePolicyHead}, "v4", "trunk", commits[2]},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.ActionsDir = root
+			r := NewResolver(nil, tc.opts)
+			info, err := r.resolveActionForPolicy(context.Background(), "actions", "checkout", tc.ref)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.Version != tc.wantVersion || info.SHA != tc.wantSHA {
+				t.Fatalf("got %s # %s, want %s # %s", info.SHA, info.Version, tc.wantSHA, tc.wantVersion)
+			}
+		})
+	}
+
+	r := NewResolver(nil, ResolveOptions{ActionsDir: root})
+	if _, err := r.resolveActionForPolicy(context.Background(), "actions", "setup-go", "v5"); err == nil || !strings.Contains(err.Error(), "no local mirror") {
+		t.Fatalf("expected a missing mirror error, got %v", err)
+	}
+
+	// A clone with a working tree at <root>/<owner>/<repo> is read through its .git directory
+	cmd := exec.Command("git", "clone", "-q", filepath.Join(root, "actions", "checkout.git"), filepath.Join(root, "actions", "cache"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git clone: %v\n%s", err, out)
+	}
+	info, err := r.resolveActionForPolicy(context.Background(), "actions", "cache", "v4")
+	if err != nil {
+		t.Fatalf("non-bare mirror: unexpected error: %v", err)
+	}
+	if info.Version != "v5.0.0" || info.SHA != commits[2] {
+		t.Fatalf("non-bare mirror: got %s # %s, want %s # v5.0.0", info.SHA, info.Version, commits[2])
+	}
+
+	// Names that are not plain path segments never reach outside the root
+	for _, name := range [][2]string{{"..", "checkout"}, {"actions", ".."}, {".", "actions"}, {"actions", `checkout\..`}} {
+		if _, err := r.resolveActionForPolicy(context.Background(), name[0], name[1], "v4"); err == nil || !strings.Contains(err.Error(), "not a plain") {
+			t.Fatalf("%s/%s: expected a plain name error, got %v", name[0], name[1], err)
+		}
+	}
+}
+
+func TestHighestSemverTag_EqualVersions(t *testing.T) {
+	// v1.0 and v1.0.0 are the same version; the pick must not depend on listing order and
+	// must match the API resolver's highestFirst.
+	for _, tags := range [][]mirrorTag{
+		{{name: "v1.0", commit: "a"}, {name: "v1.0.0", commit: "b"}},
+		{{name: "v1.0.0", commit: "b"}, {name: "v1.0", commit: "a"}},
+	} {
+		got, ok := highestSemverTag(tags, func(mirrorTag, *semver.Version) bool { return true })
+		if !ok || got.name != "v1.0.0" || got.commit != "b" {
+			t.Fatalf("highestSemverTag(%v) = %v, %t, want v1.0.0", tags, got, ok)
+		}
+	}
+}
+
+func TestRun_FromRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "commit.gpgSign=false"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	workflow := filepath.Join(repo, ".github", "workflows", "ci.yml")
+	if err := os.MkdirAll(filepath.Dir(workflow), 0755); err != nil {
+		t.Fatal(err)
+	}
+	git("init", "-q")
+	for _, content := range []string{"steps:\n  - uses: actions/checkout@v4\n", "steps:\n  - run: make\n"} {
+		if err := os.WriteFile(workflow, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", ".")
+		git("commit", "-q", "-m", "update workflow")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(repo); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--from-ref", "HEAD~1:.github/workflows/ci.yml")
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", code, stderr)
+	}
+	if want := "steps:\n  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"; stdout != want {
+		t.Fatalf("stdout = %q, want %q", stdout, want)
+	}
+	if got, _ := os.ReadFile(workflow); string(got) != "steps:\n  - run: make\n" {
+		t.Fatalf("working tree was modified: %q", got)
+	}
+
+	code, _, stderr = runCLI(t, checkoutRoutes(), "", "--from-ref", "HEAD:missing.yml")
+	if code != 1 || !strings.Contains(stderr, "git show") {
+		t.Fatalf("missing path: exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestRun_FailOnMovingTag(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "commit.gpgSign=false"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	write("ci.yml", "steps:\n  - uses: actions/checkout@v4\n  - run: make\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "add workflow")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(repo); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	// The pre-existing @v4 is not new, so it does not fail the gate.
+	write("ci.yml", "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/cache@5a3ec84eff668545956fd18022155c47e93e2684 # v4.2.3\n  - uses: actions/upload-artifact@v4.6.2\n  - run: make\n")
+	if code, stdout, stderr := runCLI(t, nil, "", "--fail-on-moving-tag", "ci.yml"); code != 0 || stdout != "" {
+		t.Fatalf("exit code = %d, stdout: %s, stderr: %s", code, stdout, stderr)
+	}
+
+	// A changed line and a new file introduce moving refs.
+	write("ci.yml", "steps:\n  - uses: actions/checkout@v4\n  - uses: actions/setup-go@main\n  - run: make\n")
+	write("release.yml", "steps:\n  - uses: actions/checkout@v4\n")
+	code, stdout, stderr := runCLI(t, nil, "", "--fail-on-moving-tag", "--diff-base", "HEAD", "ci.yml", "release.yml")
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2; stderr: %s", code, stderr)
+	}
+	if want := "ci.yml:3:11: actions/setup-go@main is not pinned to a commit SHA\nrelease.yml:2:11: actions/checkout@v4 is not pinned to a commit SHA\n"; stdout != want {
+		t.Fatalf("stdout = %q, want %q", stdout, want)
+	}
+
+	if code, _, stderr := runCLI(t, nil, "", "--fail-on-moving-tag", "--diff-base", "no-such-ref", "ci.yml"); code != 1 || !strings.Contains(stderr, "git ls-tree") {
+		t.Fatalf("bad base: exit code = %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestGitRemote_Resolve(t *testing.T) {
+	root, commits := newMirrorFixture(t)
+
+	cases := []struct {
+		name        string
+		opts        ResolveOptions
+		ref         string
+		wantVersion string
+		wantSHA     string
+	}{
+		{"major picks highest semver", ResolveOptions{Policy: UpdatePolicyMajor}, "v4", "v5.0.0", commits[2]},
+		{"same major peels annotated tag", ResolveOptions{Policy: UpdatePolicySameMajor}, "v4", "v4.2.2", commits[1]},
+		{"requested moving major expanded", ResolveOptions{Policy: UpdatePolicyRequested, ExpandMajor: true}, "v4", "v4.2.2", commits[1]},
+		{"requested exact tag", ResolveOptions{Policy: UpdatePolicyRequested}, "v4.2.1", "v4.2.1", commits[0]},
+		{"comment only", ResolveOptions{CommentOnly: true}, commits[1], "v4.2.2", commits[1]},
+		{"head", ResolveOptions{Policy: UpdatePolicyHead}, "v4", "trunk", commits[2]},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.GitRemote = root
+			r := NewResolver(nil, tc.opts)
+			info, err := r.resolveActionForPolicy(context.Background(), "actions", "checkout", tc.ref)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.Version != tc.wantVersion || info.SHA != tc.wantSHA {
+				t.Fatalf("got %s # %s, want %s # %s", info.SHA, info.Version, tc.wantSHA, tc.wantVersion)
+			}
+		})
+	}
+
+	r := NewResolver(nil, ResolveOptions{GitRemote: root})
+	if _, err := r.resolveActionForPolicy(context.Background(), "actions", "setup-go", "v5"); err == nil || !strings.Contains(err.Error(), "git ls-remote") {
+		t.Fatalf("expected an ls-remote error, got %v", err)
+	}
+}
+
+func TestRun_UseGitLsRemote(t *testing.T) {
+	root, commits := newMirrorFixture(t)
+	prev := gitHubRemote
+	gitHubRemote = root
+	t.Cleanup(func() { gitHubRemote = prev })
+
+	// No API routes: every lookup has to go through git.
+	path := writeWorkflow(t, "steps:\n  - uses: actions/checkout@v4\n")
+	code, _, stderr := runCLI(t, nil, "", "--yes", "--use-git-ls-remote", "--policy", "same-major", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d; stderr: %s", code, stderr)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "steps:\n  - uses: actions/checkout@" + commits[1] + " # v4.2.2\n"; string(got) != want {
+		t.Fatalf("content = %q, want %q", got, want)
+	}
+
+	if code, _, _ := runCLI(t, nil, "", "--use-git-ls-remote", "--actions-dir", root, path); code != 1 {
+		t.Fatalf("--use-git-ls-remote with --actions-dir: exit code = %d, want 1", code)
+	}
+}
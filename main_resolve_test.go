@@ -0,0 +1,836 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+)
+
+// fakeGitHub is a minimal stub of the GitHub REST API for resolver tests. Routes map a
+// request path to a JSON response body; unknown paths return 404. Every request is counted
+// and the query string of the last request per path is kept.
+type fakeGitHub struct {
+	mu      sync.Mutex
+	routes  map[string]string
+	calls   map[string]int
+	queries map[string]url.Values
+	scopes  string                   // X-OAuth-Scopes sent on every response when set
+	delays  map[string]time.Duration // per-path delay before responding
+	active  int                      // requests in flight
+	flaky   map[string]int           // per-path number of 503 responses before serving the route
+	peak    int                      // most requests in flight at once
+	pages   map[string][]string      // per-path bodies served by ?page=N, linked with rel="next"
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	f.calls[req.URL.Path]++
+	f.queries[req.URL.Path] = req.URL.Query()
+	body, ok := f.routes[req.URL.Path]
+	next := 0
+	if pages := f.pages[req.URL.Path]; len(pages) > 0 {
+		page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		page = max(page, 1)
+		body, ok = "", page <= len(pages)
+		if ok {
+			body = pages[page-1]
+		}
+		if page < len(pages) {
+			next = page + 1
+		}
+	}
+	scopes := f.scopes
+	delay := f.delays[req.URL.Path]
+	unavailable := f.flaky[req.URL.Path] > 0
+	if unavailable {
+		f.flaky[req.URL.Path]--
+	}
+	f.active++
+	f.peak = max(f.peak, f.active)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.active--
+		f.mu.Unlock()
+	}()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return
+		}
+	}
+	if scopes != "" {
+		w.Header().Set("X-OAuth-Scopes", scopes)
+	}
+	if unavailable {
+		http.Error(w, `{"message":"Service Unavailable"}`, http.StatusServiceUnavailable)
+		return
+	}
+	if !ok {
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+		return
+	}
+	if next > 0 {
+		w.Header().Set("Link", fmt.Sprintf(`<%s?page=%d>; rel="next"`, req.URL.Path, next))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(body))
+}
+
+func (f *fakeGitHub) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[path]
+}
+
+func (f *fakeGitHub) query(path string) url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries[path]
+}
+
+// newTestResolver starts a fake GitHub API serving routes and returns a Resolver wired to it.
+func newTestResolver(t *testing.T, routes map[string]string) (*Resolver, *fakeGitHub) {
+	t.Helper()
+	client, fake := newTestClient(t, routes)
+	return NewResolver(client, ResolveOptions{}), fake
+}
+
+// newTestClient starts a fake GitHub API serving routes and returns a client pointed at it.
+func newTestClient(t *testing.T, routes map[string]string) (*github.Client, *fakeGitHub) {
+	t.Helper()
+	fake := &fakeGitHub{routes: routes, calls: make(map[string]int), queries: make(map[string]url.Values)}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("parse server URL: %v", err)
+	}
+	client.BaseURL = baseURL
+	return client, fake
+}
+
+func TestResolveTagToCommitSHA_CachesNotFound(t *testing.T) {
+	sha := "8ade135a41bc03ea155e62e844d188df1ea18608"
+	r, fake := newTestResolver(t, map[string]string{
+		"/repos/foo/bar/tags":                `[{"name":"v1.0.0","commit":{"sha":"` + sha + `"}}]`,
+		"/repos/foo/bar/git/ref/tags/v1.0.0": `{"ref":"refs/tags/v1.0.0","object":{"type":"commit","sha":"` + sha + `"}}`,
+	})
+
+	r.opts.Policy = UpdatePolicyRequested
+
+	occurrences := extractOccurrences("steps:\n  - uses: foo/bar@v9\n  - uses: foo/bar@v9\n")
+	infos := r.getActionInfosForOccurrences(context.Background(), occurrences)
+
+	for i, info := range infos {
+		if info.Error != nil || info.SHA != sha || info.Version != "v1.0.0" {
+			t.Fatalf("infos[%d] = %+v, want fallback to v1.0.0 @ %s", i, info, sha)
+		}
+	}
+	if got := fake.count("/repos/foo/bar/git/ref/tags/v9"); got != 1 {
+		t.Fatalf("missing tag v9 queried %d times, want 1", got)
+	}
+}
+
+func TestResolveActionForPolicy_PreferReleaseName(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	routes := map[string]string{
+		"/repos/actions/checkout/releases/latest":     `{"tag_name":"v4.2.2","name":"v4.2.2 - Security\nfix "}`,
+		"/repos/actions/checkout/git/ref/tags/v4.2.2": `{"ref":"refs/tags/v4.2.2","object":{"type":"commit","sha":"` + sha + `"}}`,
+	}
+
+	cases := []struct {
+		name   string
+		prefer bool
+		want   string
+	}{
+		{"default uses tag name", false, "v4.2.2"},
+		{"prefer release name", true, "v4.2.2 - Security fix"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _ := newTestResolver(t, routes)
+			r.opts.PreferReleaseName = tc.prefer
+			info, err := r.resolveActionForPolicy(context.Background(), "actions", "checkout", "v4")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.SHA != sha || info.Version != tc.want {
+				t.Fatalf("got %s # %s, want %s # %s", info.SHA, info.Version, sha, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveActionForPolicy_CommentOnly(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	r, fake := newTestResolver(t, map[string]string{
+		"/repos/actions/checkout/tags": `[{"name":"v5.0.0","commit":{"sha":"08c6903cd8c0fde910a37f88322edcfb5dd907a8"}},{"name":"v4.2.2","commit":{"sha":"` + sha + `"}}]`,
+	})
+	r.opts.CommentOnly = true
+
+	info, err := r.resolveActionForPolicy(context.Background(), "actions", "checkout", sha)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.SHA != sha || info.Version != "v4.2.2" {
+		t.Fatalf("got %s # %s, want %s # v4.2.2", info.SHA, info.Version, sha)
+	}
+	if got := fake.count("/repos/actions/checkout/releases/latest"); got != 0 {
+		t.Fatalf("comment-only mode queried the latest release %d times, want 0", got)
+	}
+
+	if _, err := r.resolveActionForPolicy(context.Background(), "actions", "checkout", "v4"); err == nil {
+		t.Fatalf("expected an error for a ref that is not a commit SHA")
+	}
+}
+
+func TestResolver_CacheStats(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	r, fake := newTestResolver(t, map[string]string{
+		"/repos/actions/checkout/releases/latest":     `{"tag_name":"v4.2.2"}`,
+		"/repos/actions/checkout/git/ref/tags/v4.2.2": `{"ref":"refs/tags/v4.2.2","object":{"type":"commit","sha":"` + sha + `"}}`,
+	})
+	occurrences := extractOccurrences("steps:\n  - uses: actions/checkout@v4\n")
+
+	r.getActionInfosForOccurrences(context.Background(), occurrences)
+	r.getActionInfosForOccurrences(context.Background(), occurrences)
+
+	want := CacheStats{ResultHits: 1, ResultMisses: 1, TagHits: 0, TagMisses: 1, Results: 1, Tags: 1}
+	if got := r.CacheStats(); got != want {
+		t.Fatalf("CacheStats() = %+v, want %+v", got, want)
+	}
+	if got := fake.count("/repos/actions/checkout/releases/latest"); got != 1 {
+		t.Fatalf("latest release queried %d times, want 1", got)
+	}
+}
+
+func TestResolveActionForPolicy_ShortSHA(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "extract", "short_sha.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	occs := extractOccurrences(string(content))
+	if len(occs) != 1 || occs[0].RequestedRef != "11bd719" {
+		t.Fatalf("unexpected occurrences: %+v", occs)
+	}
+
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	r, _ := newTestResolver(t, map[string]string{
+		"/repos/actions/checkout/commits/11bd719": sha,
+		"/repos/actions/checkout/tags":            `[{"name":"v4.2.2","commit":{"sha":"` + sha + `"}}]`,
+	})
+	r.opts.Policy = UpdatePolicyRequested
+
+	infos := r.getActionInfosForOccurrences(context.Background(), occs)
+	if infos[0].Error != nil || infos[0].SHA != sha || infos[0].Version != "v4.2.2" {
+		t.Fatalf("got %+v, want %s # v4.2.2", infos[0], sha)
+	}
+
+	want := "      - uses: actions/checkout@" + sha + " # v4.2.2\n"
+	if got := updateContent(string(content), occs, infos, CommentStyle{}); !strings.HasSuffix(got, want) {
+		t.Fatalf("updateContent() = %q, want suffix %q", got, want)
+	}
+}
+
+func TestSelectTagBySemverOrNewest_NewestPublishedRelease(t *testing.T) {
+	sha := "5a3ec84eff668545956fd18022155c47e93e2684"
+	r, _ := newTestResolver(t, map[string]string{
+		"/repos/acme/deploy/tags": `[{"name":"release-2024-01-15"},{"name":"release-2024-03-02"},{"name":"release-2023-11-30"}]`,
+		"/repos/acme/deploy/releases": `[
+			{"tag_name":"release-2023-11-30","published_at":"2023-11-30T10:00:00Z"},
+			{"tag_name":"release-2024-04-01","draft":true},
+			{"tag_name":"release-2024-03-02","published_at":"2024-03-02T09:00:00Z"},
+			{"tag_name":"release-2024-01-15","published_at":"2024-01-15T12:00:00Z"}
+		]`,
+		"/repos/acme/deploy/git/ref/tags/release-2024-03-02": `{"ref":"refs/tags/release-2024-03-02","object":{"type":"commit","sha":"` + sha + `"}}`,
+	})
+
+	gotSHA, tagName, err := r.selectTagBySemverOrNewest(context.Background(), "acme", "deploy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotSHA != sha || tagName != "release-2024-03-02" {
+		t.Fatalf("got %s @ %s, want release-2024-03-02 @ %s", tagName, gotSHA, sha)
+	}
+}
+
+// Equivalent semver tags resolve to the same pin whichever order the API lists them in.
+func TestSelectTagBySemverOrNewest_EquivalentTags(t *testing.T) {
+	shas := map[string]string{
+		"v1.0":   "5a3ec84eff668545956fd18022155c47e93e2684",
+		"v1.0.0": "11bd71901bbe5b1630ceea73d27597364c9af683",
+		"1.0.0":  "b4ffde65f46336ab88eb53be808477a3936bae11",
+		"v0.9.0": "c85c95e3d7251135ab7dc9ce3241c5835cc595a9",
+	}
+	for _, order := range [][]string{
+		{"v1.0", "v1.0.0", "1.0.0", "v0.9.0"},
+		{"1.0.0", "v0.9.0", "v1.0.0", "v1.0"},
+		{"v1.0.0", "v1.0", "1.0.0", "v0.9.0"},
+	} {
+		routes := map[string]string{}
+		var tags []string
+		for _, name := range order {
+			tags = append(tags, `{"name":"`+name+`"}`)
+			routes["/repos/acme/lib/git/ref/tags/"+name] = `{"ref":"refs/tags/` + name + `","object":{"type":"commit","sha":"` + shas[name] + `"}}`
+		}
+		routes["/repos/acme/lib/tags"] = "[" + strings.Join(tags, ",") + "]"
+		r, _ := newTestResolver(t, routes)
+
+		// The most specific tag wins over v1.0; between 1.0.0 and v1.0.0 the name decides.
+		sha, tagName, err := r.selectTagBySemverOrNewest(context.Background(), "acme", "lib")
+		if err != nil || tagName != "1.0.0" || sha != shas["1.0.0"] {
+			t.Fatalf("order %v: got %s @ %s (err %v), want 1.0.0 @ %s", order, tagName, sha, err, shas["1.0.0"])
+		}
+	}
+}
+
+func TestResolver_TagsPerPage(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	routes := map[string]string{
+		"/repos/actions/checkout/tags":                `[{"name":"v4.2.2","commit":{"sha":"` + sha + `"}}]`,
+		"/repos/actions/checkout/git/ref/tags/v4.2.2": `{"ref":"refs/tags/v4.2.2","object":{"type":"commit","sha":"` + sha + `"}}`,
+	}
+
+	cases := []struct {
+		name    string
+		perPage int
+		want    string
+	}{
+		{"default", 0, "100"},
+		{"custom", 7, "7"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, fake := newTestResolver(t, routes)
+			r.opts.TagsPerPage = tc.perPage
+
+			if _, _, err := r.selectTagBySemverOrNewest(context.Background(), "actions", "checkout"); err != nil {
+				t.Fatalf("selectTagBySemverOrNewest: %v", err)
+			}
+			if got := fake.query("/repos/actions/checkout/tags").Get("per_page"); got != tc.want {
+				t.Fatalf("selectTagBySemverOrNewest per_page = %q, want %q", got, tc.want)
+			}
+
+			if _, _, err := r.selectTagBySameMajor(context.Background(), "actions", "checkout", 4); err != nil {
+				t.Fatalf("selectTagBySameMajor: %v", err)
+			}
+			if got := fake.query("/repos/actions/checkout/tags").Get("per_page"); got != tc.want {
+				t.Fatalf("selectTagBySameMajor per_page = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolver_SuspiciousResolutions(t *testing.T) {
+	tagged := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	dangling := "0123456789abcdef0123456789abcdef01234567"
+	r, _ := newTestResolver(t, map[string]string{
+		"/repos/actions/checkout/tags": `[{"name":"v4.2.2","commit":{"sha":"` + tagged + `"}}]`,
+	})
+	content := "steps:\n" +
+		"  - uses: actions/checkout@" + tagged + "\n" +
+		"  - uses: actions/checkout@" + dangling + "\n" +
+		"  - uses: actions/checkcout@v4\n" +
+		"  - uses: github/codeql-action/init@v3\n" +
+		"  - uses: github/codeql-action/analyze@v3\n"
+	occurrences := extractOccurrences(content)
+	codeql := "662472033e021d55d94146f66f6058822b0b39fd"
+	actionInfos := []ActionInfo{
+		{Owner: "actions", Repo: "checkout", Version: tagged, SHA: tagged},
+		{Owner: "actions", Repo: "checkout", Version: dangling, SHA: dangling},
+		{Owner: "actions", Repo: "checkcout", Version: "v4.2.2", SHA: tagged},
+		{Owner: "github", Repo: "codeql-action/init", Version: "v3.28.0", SHA: codeql},
+		{Owner: "github", Repo: "codeql-action/analyze", Version: "v3.28.0", SHA: codeql},
+	}
+
+	warnings := r.suspiciousResolutions(context.Background(), occurrences, actionInfos)
+	if len(warnings) != 2 {
+		t.Fatalf("got %d warnings, want 2: %q", len(warnings), warnings)
+	}
+	if !strings.Contains(warnings[0], "actions/checkout@0123456789ab… (L3:C11) is not pointed at by any semver tag") {
+		t.Errorf("unexpected dangling warning: %s", warnings[0])
+	}
+	if !strings.Contains(warnings[1], "actions/checkout (L2:C11) and actions/checkcout (L4:C11) resolve to the same commit") {
+		t.Errorf("unexpected same-commit warning: %s", warnings[1])
+	}
+}
+
+func TestResolver_ExplainsInaccessibleRepo(t *testing.T) {
+	r, fake := newTestResolver(t, map[string]string{
+		"/repos/foo/public": `{"full_name":"foo/public","private":false}`,
+	})
+	fake.scopes = "public_repo, read:org"
+	occurrences := extractOccurrences("steps:\n  - uses: foo/private@v1\n  - uses: foo/private/sub@v1\n  - uses: foo/public@v9\n")
+	infos := r.getActionInfosForOccurrences(context.Background(), occurrences)
+
+	var accessErr *accessError
+	if !errors.As(infos[0].Error, &accessErr) || accessErr.Owner != "foo" || accessErr.Repo != "private" {
+		t.Fatalf("private repo error = %v, want an access error", infos[0].Error)
+	}
+	want := `token may lack access to foo/private (repository not found or private); token scopes "public_repo, read:org" do not include repo: 404 Not Found for GET `
+	if got := describeResolveError(infos[0].Error); !strings.HasPrefix(got, want) {
+		t.Fatalf("describeResolveError() = %q, want prefix %q", got, want)
+	}
+	if !errors.As(infos[1].Error, &accessErr) {
+		t.Fatalf("subpath action error = %v, want an access error", infos[1].Error)
+	}
+	if got := fake.count("/repos/foo/private"); got != 1 {
+		t.Fatalf("repository looked up %d times, want 1", got)
+	}
+
+	// A visible repository with a missing ref keeps the plain 404.
+	if errors.As(infos[2].Error, &accessErr) || infos[2].Error == nil {
+		t.Fatalf("missing ref in visible repo = %v, want a plain 404", infos[2].Error)
+	}
+}
+
+func TestDescribeResolveError_WithoutRequest(t *testing.T) {
+	reset := github.Timestamp{Time: time.Date(2024, 5, 1, 15, 4, 0, 0, time.Local)}
+	req := &http.Request{Method: http.MethodGet, URL: &url.URL{Scheme: "https", Host: "api.github.com", Path: "/repos/o/r"}}
+	cases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"rate limit", &github.RateLimitError{Rate: github.Rate{Reset: reset}, Response: &http.Response{Status: "403 Forbidden", Request: req}},
+			"403 Forbidden rate limited for GET https://api.github.com/repos/o/r (resets 3:04PM)"},
+		{"rate limit without request", &github.RateLimitError{Rate: github.Rate{Reset: reset}, Response: &http.Response{Status: "403 Forbidden"}},
+			"403 Forbidden rate limited (resets 3:04PM)"},
+		{"secondary rate limit", &github.AbuseRateLimitError{Response: &http.Response{Status: "403 Forbidden", Request: req}},
+			"403 Forbidden secondary rate limit for GET https://api.github.com/repos/o/r"},
+		{"secondary rate limit without request", &github.AbuseRateLimitError{Response: &http.Response{Status: "429 Too Many Requests"}},
+			"429 Too Many Requests secondary rate limit"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := describeResolveError(fmt.Errorf("wrapped: %w", tc.err)); got != tc.want {
+				t.Fatalf("describeResolveError() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolver_SameMajorPerOccurrence(t *testing.T) {
+	v4SHA := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	v3SHA := "f43a0e5ff2bd294095638e18286ca9a3d1956744"
+	r, _ := newTestResolver(t, map[string]string{
+		"/repos/actions/checkout/tags": `[{"name":"v4.2.2","commit":{"sha":"` + v4SHA + `"}},` +
+			`{"name":"v4.1.0","commit":{"sha":"b4ffde65f46336ab88eb53be808477a3936bae11"}},` +
+			`{"name":"v3.6.0","commit":{"sha":"` + v3SHA + `"}},` +
+			`{"name":"v3.5.3","commit":{"sha":"c85c95e3d7251135ab7dc9ce3241c5835cc595a9"}}]`,
+		"/repos/actions/checkout/git/ref/tags/v4.2.2": `{"ref":"refs/tags/v4.2.2","object":{"type":"commit","sha":"` + v4SHA + `"}}`,
+		"/repos/actions/checkout/git/ref/tags/v3.6.0": `{"ref":"refs/tags/v3.6.0","object":{"type":"commit","sha":"` + v3SHA + `"}}`,
+	})
+	r.opts.Policy = UpdatePolicySameMajor
+
+	// A migration in progress: one job still on v3, another already on v4.
+	occurrences := extractOccurrences(`jobs:
+  legacy:
+    steps:
+      - uses: actions/checkout@v3
+  current:
+    steps:
+      - uses: actions/checkout@v4
+  legacy-again:
+    steps:
+      - uses: actions/checkout@v3
+`)
+	infos := r.getActionInfosForOccurrences(context.Background(), occurrences)
+
+	want := []struct{ version, sha string }{{"v3.6.0", v3SHA}, {"v4.2.2", v4SHA}, {"v3.6.0", v3SHA}}
+	for i, w := range want {
+		if infos[i].Error != nil || infos[i].Version != w.version || infos[i].SHA != w.sha {
+			t.Fatalf("occurrence %d (@%s) = %s # %s (err %v), want %s # %s",
+				i, occurrences[i].RequestedRef, infos[i].SHA, infos[i].Version, infos[i].Error, w.sha, w.version)
+		}
+	}
+	if stats := r.CacheStats(); stats.Results != 2 {
+		t.Fatalf("cached %d resolutions, want one per requested major", stats.Results)
+	}
+}
+
+func TestResolver_HeadPolicyUsesDefaultBranch(t *testing.T) {
+	sha := "0ad4b8fadaa221de15dcec353f45205ec38ea70b"
+	r, fake := newTestResolver(t, map[string]string{
+		"/repos/foo/bar":                `{"full_name":"foo/bar","default_branch":"trunk"}`,
+		"/repos/foo/bar/branches/trunk": `{"name":"trunk","commit":{"sha":"` + sha + `"}}`,
+	})
+	r.opts.Policy = UpdatePolicyHead
+
+	occurrences := extractOccurrences("steps:\n  - uses: foo/bar@v1\n  - uses: foo/bar@main\n")
+	infos := r.getActionInfosForOccurrences(context.Background(), occurrences)
+	for i, info := range infos {
+		if info.Error != nil || info.SHA != sha || info.Version != "trunk" {
+			t.Fatalf("occurrence %d = %s # %s (err %v), want %s # trunk", i, info.SHA, info.Version, info.Error, sha)
+		}
+	}
+	if got := fake.count("/repos/foo/bar/branches/main"); got != 0 {
+		t.Fatalf("main was assumed as the default branch (%d requests)", got)
+	}
+	if got := fake.count("/repos/foo/bar"); got != 1 {
+		t.Fatalf("repository metadata fetched %d times, want 1", got)
+	}
+}
+
+func TestResolver_SkipsTagWithoutCommit(t *testing.T) {
+	good := "b4ffde65f46336ab88eb53be808477a3936bae11"
+	r, _ := newTestResolver(t, map[string]string{
+		"/repos/foo/bar/tags": `[{"name":"v2.1.0","commit":{"sha":""}},` +
+			`{"name":"v2.0.0","commit":{"sha":"` + good + `"}},` +
+			`{"name":"v1.0.0","commit":{"sha":"c85c95e3d7251135ab7dc9ce3241c5835cc595a9"}}]`,
+		"/repos/foo/bar/git/ref/tags/v2.1.0": `{"ref":"refs/tags/v2.1.0","object":{"type":"commit"}}`,
+		"/repos/foo/bar/git/ref/tags/v2.0.0": `{"ref":"refs/tags/v2.0.0","object":{"type":"commit","sha":"` + good + `"}}`,
+	})
+
+	for _, policy := range []UpdatePolicy{UpdatePolicyMajor, UpdatePolicySameMajor} {
+		r.opts.Policy = policy
+		info, err := r.resolveActionForPolicy(context.Background(), "foo", "bar", "v2")
+		if err != nil || info.Version != "v2.0.0" || info.SHA != good {
+			t.Fatalf("policy %d: got %s # %s (err %v), want %s # v2.0.0", policy, info.SHA, info.Version, err, good)
+		}
+	}
+
+	if _, _, err := r.resolveTagToCommitSHA(context.Background(), "foo", "bar", "v2.1.0"); !errors.Is(err, errTagWithoutCommit) {
+		t.Fatalf("malformed tag error = %v, want errTagWithoutCommit", err)
+	}
+}
+
+func TestResolver_ActionTimeout(t *testing.T) {
+	routes := checkoutRoutes()
+	routes["/repos/big/monorepo/releases/latest"] = `{"tag_name":"v1.0.0"}`
+	r, fake := newTestResolver(t, routes)
+	fake.delays = map[string]time.Duration{"/repos/big/monorepo/releases/latest": 5 * time.Second}
+	r.opts.ActionTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	occurrences := extractOccurrences("steps:\n  - uses: big/monorepo@v1\n  - uses: actions/checkout@v4\n")
+	infos := r.getActionInfosForOccurrences(context.Background(), occurrences)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("slow action held up the run for %s", elapsed)
+	}
+	if infos[0].Error == nil || !strings.Contains(infos[0].Error.Error(), "timed out after 100ms") {
+		t.Fatalf("slow action error = %v, want a timeout", infos[0].Error)
+	}
+	if infos[1].Error != nil || infos[1].Version != "v4.2.2" {
+		t.Fatalf("fast action = %+v, want v4.2.2", infos[1])
+	}
+}
+
+// Each resolution reports the step of the policy's fallback chain its SHA came from.
+func TestResolver_ResolvedVia(t *testing.T) {
+	sha := "b4ffde65f46336ab88eb53be808477a3936bae11"
+	tag := func(name string) string {
+		return `{"ref":"refs/tags/` + name + `","object":{"type":"commit","sha":"` + sha + `"}}`
+	}
+	cases := []struct {
+		name    string
+		policy  UpdatePolicy
+		ref     string
+		routes  map[string]string
+		wantVia string
+	}{
+		{"latest release", UpdatePolicyMajor, "v1", map[string]string{
+			"/repos/foo/bar/releases/latest":     `{"tag_name":"v2.0.0"}`,
+			"/repos/foo/bar/git/ref/tags/v2.0.0": tag("v2.0.0"),
+		}, viaLatestRelease},
+		{"highest semver", UpdatePolicyMajor, "v1", map[string]string{
+			"/repos/foo/bar/tags":                `[{"name":"v1.0.0"},{"name":"v2.0.0"}]`,
+			"/repos/foo/bar/git/ref/tags/v2.0.0": tag("v2.0.0"),
+		}, viaHighestSemver},
+		{"newest fallback", UpdatePolicyMajor, "v1", map[string]string{
+			"/repos/foo/bar/tags":                 `[{"name":"nightly"}]`,
+			"/repos/foo/bar/git/ref/tags/nightly": tag("nightly"),
+		}, viaNewestFallback},
+		{"same major", UpdatePolicySameMajor, "v1", map[string]string{
+			"/repos/foo/bar/tags":                `[{"name":"v1.2.0"},{"name":"v2.0.0"}]`,
+			"/repos/foo/bar/git/ref/tags/v1.2.0": tag("v1.2.0"),
+		}, viaSameMajor},
+		{"requested", UpdatePolicyRequested, "v1.2.0", map[string]string{
+			"/repos/foo/bar/git/ref/tags/v1.2.0": tag("v1.2.0"),
+		}, viaRequested},
+		{"branch", UpdatePolicyHead, "v1", map[string]string{
+			"/repos/foo/bar":                `{"full_name":"foo/bar","default_branch":"trunk"}`,
+			"/repos/foo/bar/branches/trunk": `{"name":"trunk","commit":{"sha":"` + sha + `"}}`,
+		}, viaBranch},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _ := newTestResolver(t, tc.routes)
+			r.opts.Policy = tc.policy
+			info, err := r.resolveActionForPolicy(context.Background(), "foo", "bar", tc.ref)
+			if err != nil || info.SHA != sha {
+				t.Fatalf("got %s # %s (err %v), want %s", info.SHA, info.Version, err, sha)
+			}
+			if info.ResolvedVia != tc.wantVia {
+				t.Fatalf("ResolvedVia = %q, want %q", info.ResolvedVia, tc.wantVia)
+			}
+		})
+	}
+}
+
+// A same-major ref with no tags of its major walks the whole fallback chain: latest release,
+// highest semver tag, then the newest release or tag, saying at each step why.
+func TestResolver_SameMajorFallbackChain(t *testing.T) {
+	sha := "b4ffde65f46336ab88eb53be808477a3936bae11"
+	tagRoute := func(name string) (string, string) {
+		return "/repos/foo/bar/git/ref/tags/" + name, `{"ref":"refs/tags/` + name + `","object":{"type":"commit","sha":"` + sha + `"}}`
+	}
+	cases := []struct {
+		name         string
+		routes       map[string]string
+		wantVersion  string
+		wantFallback string
+	}{
+		{
+			name: "latest release",
+			routes: map[string]string{
+				"/repos/foo/bar/tags":            `[{"name":"v2.0.0"}]`,
+				"/repos/foo/bar/releases/latest": `{"tag_name":"v2.0.0"}`,
+			},
+			wantVersion:  "v2.0.0",
+			wantFallback: "no tags found for major 9; used the latest release",
+		},
+		{
+			name: "highest semver tag",
+			routes: map[string]string{
+				"/repos/foo/bar/tags": `[{"name":"v1.0.0"},{"name":"v2.0.0"}]`,
+			},
+			wantVersion:  "v2.0.0",
+			wantFallback: "no tags found for major 9; no latest release; used the highest semver tag",
+		},
+		{
+			name: "newest release",
+			routes: map[string]string{
+				"/repos/foo/bar/tags":     `[{"name":"nightly"},{"name":"release-2024-05-01"}]`,
+				"/repos/foo/bar/releases": `[{"tag_name":"release-2024-05-01","published_at":"2024-05-01T00:00:00Z"}]`,
+			},
+			wantVersion:  "release-2024-05-01",
+			wantFallback: "no tags found for major 9; no latest release; no semver tags, used the newest release or tag",
+		},
+		{
+			name: "newest tag",
+			routes: map[string]string{
+				"/repos/foo/bar/tags": `[{"name":"nightly"}]`,
+			},
+			wantVersion:  "nightly",
+			wantFallback: "no tags found for major 9; no latest release; no semver tags, used the newest release or tag",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path, body := tagRoute(tc.wantVersion)
+			tc.routes[path] = body
+			r, _ := newTestResolver(t, tc.routes)
+			r.opts.Policy = UpdatePolicySameMajor
+			info, err := r.resolveActionForPolicy(context.Background(), "foo", "bar", "v9")
+			if err != nil || info.Version != tc.wantVersion || info.SHA != sha {
+				t.Fatalf("got %s # %s (err %v), want %s # %s", info.SHA, info.Version, err, sha, tc.wantVersion)
+			}
+			if info.Fallback != tc.wantFallback {
+				t.Fatalf("Fallback = %q, want %q", info.Fallback, tc.wantFallback)
+			}
+		})
+	}
+
+	// An empty tag list ends the chain with every reason in the error.
+	r, _ := newTestResolver(t, map[string]string{"/repos/foo/bar/tags": `[]`})
+	r.opts.Policy = UpdatePolicySameMajor
+	_, err := r.resolveActionForPolicy(context.Background(), "foo", "bar", "v9")
+	if err == nil || err.Error() != "no tags found for major 9; no latest release: no tags found" {
+		t.Fatalf("error = %v, want the full chain", err)
+	}
+
+	// The major policy falling back to tags is its normal path, not a fallback worth a note.
+	r, _ = newTestResolver(t, map[string]string{"/repos/foo/bar/tags": `[{"name":"v2.0.0"}]`, "/repos/foo/bar/git/ref/tags/v2.0.0": `{"ref":"refs/tags/v2.0.0","object":{"type":"commit","sha":"` + sha + `"}}`})
+	if info, err := r.resolveActionForPolicy(context.Background(), "foo", "bar", "v9"); err != nil || info.Fallback != "" {
+		t.Fatalf("major policy: Fallback = %q (err %v), want none", info.Fallback, err)
+	}
+}
+
+// A moving major whose commit carries several full tags expands to the highest of them,
+// whatever order the API lists them in and whether they are lightweight or annotated.
+func TestResolver_ExpandMajorPicksHighestTag(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	ref := func(name string) string {
+		return `{"ref":"refs/tags/` + name + `","object":{"type":"commit","sha":"` + sha + `"}}`
+	}
+	cases := map[string]string{
+		"lightweight": `[{"name":"v4"},{"name":"v4.2.1","commit":{"sha":"` + sha + `"}},{"name":"v4.2.2","commit":{"sha":"` + sha + `"}},` +
+			`{"name":"v4.2.0","commit":{"sha":"b4ffde65f46336ab88eb53be808477a3936bae11"}}]`,
+		"annotated": `[{"name":"v4"},{"name":"v4.2.1"},{"name":"v4.2.2"}]`,
+	}
+	for name, tags := range cases {
+		t.Run(name, func(t *testing.T) {
+			r, _ := newTestResolver(t, map[string]string{
+				"/repos/actions/checkout/tags":                tags,
+				"/repos/actions/checkout/git/ref/tags/v4":     ref("v4"),
+				"/repos/actions/checkout/git/ref/tags/v4.2.1": ref("v4.2.1"),
+				"/repos/actions/checkout/git/ref/tags/v4.2.2": ref("v4.2.2"),
+			})
+			r.opts.Policy = UpdatePolicyRequested
+			r.opts.ExpandMajor = true
+			info, err := r.resolveActionForPolicy(context.Background(), "actions", "checkout", "v4")
+			if err != nil || info.Version != "v4.2.2" || info.SHA != sha {
+				t.Fatalf("got %s # %s (err %v), want %s # v4.2.2", info.SHA, info.Version, err, sha)
+			}
+		})
+	}
+}
+
+func TestResolver_NoResolveAnnotated(t *testing.T) {
+	tagObject := "0ad4b8fadaa221de15dcec353f45205ec38ea70b"
+	commit := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	routes := map[string]string{
+		"/repos/actions/checkout/releases/latest":       `{"tag_name":"v4.2.2"}`,
+		"/repos/actions/checkout/git/ref/tags/v4.2.2":   `{"ref":"refs/tags/v4.2.2","object":{"type":"tag","sha":"` + tagObject + `"}}`,
+		"/repos/actions/checkout/git/tags/" + tagObject: `{"sha":"` + tagObject + `","object":{"type":"commit","sha":"` + commit + `"}}`,
+	}
+
+	for _, tc := range []struct {
+		skip     bool
+		wantSHA  string
+		getTags  int
+		warnings int
+	}{
+		{false, commit, 1, 0},
+		{true, tagObject, 0, 1},
+	} {
+		r, fake := newTestResolver(t, routes)
+		r.opts.NoResolveAnnotated = tc.skip
+		infos := r.getActionInfosForOccurrences(context.Background(), extractOccurrences("steps:\n  - uses: actions/checkout@v4\n"))
+		if infos[0].Error != nil || infos[0].SHA != tc.wantSHA {
+			t.Fatalf("skip=%v: got %+v, want %s", tc.skip, infos[0], tc.wantSHA)
+		}
+		if got := fake.count("/repos/actions/checkout/git/tags/" + tagObject); got != tc.getTags {
+			t.Fatalf("skip=%v: dereferenced the tag %d time(s), want %d", tc.skip, got, tc.getTags)
+		}
+		warnings := r.tagObjectWarnings(infos)
+		if len(warnings) != tc.warnings || (tc.skip && !strings.Contains(warnings[0], "actions/checkout@v4.2.2 is an annotated tag")) {
+			t.Fatalf("skip=%v: warnings = %q", tc.skip, warnings)
+		}
+	}
+}
+
+func TestResolver_WorkersPerHost(t *testing.T) {
+	routes := map[string]string{}
+	delays := map[string]time.Duration{}
+	var content strings.Builder
+	content.WriteString("steps:\n")
+	for i := 0; i < 6; i++ {
+		repo := fmt.Sprintf("action-%d", i)
+		routes["/repos/octo-org/"+repo+"/releases/latest"] = `{"tag_name":"v1.0.0"}`
+		routes["/repos/octo-org/"+repo+"/git/ref/tags/v1.0.0"] = `{"ref":"refs/tags/v1.0.0","object":{"type":"commit","sha":"0123456789abcdef0123456789abcdef01234567"}}`
+		delays["/repos/octo-org/"+repo+"/releases/latest"] = 20 * time.Millisecond
+		fmt.Fprintf(&content, "  - uses: octo-org/%s@v1\n  - uses: https://ghes.example.com/octo-org/%s@v1\n", repo, repo)
+	}
+	occurrences := extractOccurrences(content.String())
+
+	for _, tc := range []struct {
+		name         string
+		opts         ResolveOptions
+		dotcom, ghes int
+	}{
+		{"explicit limit", ResolveOptions{Concurrency: 4, HostWorkers: map[string]int{"ghes.example.com": 1}}, 2, 1},
+		{"shared concurrency", ResolveOptions{Concurrency: 6}, 3, 3},
+		{"explicit for both", ResolveOptions{HostWorkers: map[string]int{"github.com": 1, "ghes.example.com": 2}}, 1, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dotcomClient, dotcom := newTestClient(t, routes)
+			ghesClient, ghes := newTestClient(t, routes)
+			dotcom.delays, ghes.delays = delays, delays
+			r := NewResolver(dotcomClient, tc.opts)
+			r.hostClient = func(context.Context, string) (*github.Client, error) { return ghesClient, nil }
+
+			for i, info := range r.getActionInfosForOccurrences(context.Background(), occurrences) {
+				if info.Error != nil {
+					t.Fatalf("occurrence %d: %v", i, info.Error)
+				}
+			}
+			if dotcom.peak > tc.dotcom || ghes.peak > tc.ghes {
+				t.Fatalf("peak requests in flight: github.com %d, GHES %d; want at most %d and %d", dotcom.peak, ghes.peak, tc.dotcom, tc.ghes)
+			}
+		})
+	}
+}
+
+// Requests, cache lookups and timings on a GitHub Enterprise Server host count towards the
+// run's statistics like those on github.com.
+func TestResolver_StatsIncludeOtherHosts(t *testing.T) {
+	routes := map[string]string{
+		"/repos/octo-org/deploy/releases/latest":     `{"tag_name":"v1.0.0"}`,
+		"/repos/octo-org/deploy/git/ref/tags/v1.0.0": `{"ref":"refs/tags/v1.0.0","object":{"type":"commit","sha":"0123456789abcdef0123456789abcdef01234567"}}`,
+	}
+	dotcomClient, dotcom := newTestClient(t, routes)
+	ghesClient, ghes := newTestClient(t, routes)
+	r := NewResolver(dotcomClient, ResolveOptions{})
+	r.countAPICalls()
+	r.hostClient = func(context.Context, string) (*github.Client, error) { return ghesClient, nil }
+
+	occurrences := extractOccurrences("steps:\n  - uses: octo-org/deploy@v1\n  - uses: https://ghes.example.com/octo-org/deploy@v1\n  - uses: https://ghes.example.com/octo-org/deploy@v1\n")
+	for i, info := range r.getActionInfosForOccurrences(context.Background(), occurrences) {
+		if info.Error != nil {
+			t.Fatalf("occurrence %d: %v", i, info.Error)
+		}
+	}
+
+	total := func(f *fakeGitHub) int64 {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		n := 0
+		for _, calls := range f.calls {
+			n += calls
+		}
+		return int64(n)
+	}
+	stats := r.Stats(time.Second)
+	if want := total(dotcom) + total(ghes); stats.APICalls != want || total(ghes) == 0 {
+		t.Fatalf("apiCalls = %d, want %d (github.com %d, GHES %d)", stats.APICalls, want, total(dotcom), total(ghes))
+	}
+	// The two GHES occurrences may resolve at once, so both can miss the cache
+	if lookups := stats.Cache.ResultHits + stats.Cache.ResultMisses; lookups != 3 || stats.Cache.Results != 2 {
+		t.Fatalf("cache = %+v, want 3 lookups and 2 results", stats.Cache)
+	}
+	if len(stats.Actions) != 3 {
+		t.Fatalf("got %d action timings, want 3: %+v", len(stats.Actions), stats.Actions)
+	}
+}
+
+// The tags API does not promise that tags on the same commit are listed together, so a page
+// boundary between them must not hide the higher one.
+func TestResolver_ExpandMajorPicksHighestTagAcrossPages(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	r, fake := newTestResolver(t, map[string]string{
+		"/repos/actions/checkout/git/ref/tags/v4": `{"ref":"refs/tags/v4","object":{"type":"commit","sha":"` + sha + `"}}`,
+	})
+	// Sorted by name, v4.2.10 comes after v4.2.9 and lands on the next page.
+	fake.pages = map[string][]string{"/repos/actions/checkout/tags": {
+		`[{"name":"v4","commit":{"sha":"` + sha + `"}}]`,
+		`[{"name":"v4.2.9","commit":{"sha":"` + sha + `"}}]`,
+		`[{"name":"v4.2.10","commit":{"sha":"` + sha + `"}}]`,
+	}}
+	r.opts.Policy = UpdatePolicyRequested
+	r.opts.ExpandMajor = true
+	r.opts.TagsPerPage = 1
+	info, err := r.resolveActionForPolicy(context.Background(), "actions", "checkout", "v4")
+	if err != nil || info.Version != "v4.2.10" {
+		t.Fatalf("got %s # %s (err %v), want v4.2.10", info.SHA, info.Version, err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport_BackoffJitter(t *testing.T) {
+	exact := &retryTransport{}
+	for attempt, want := range []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second} {
+		if got := exact.backoff(attempt); got != want {
+			t.Fatalf("backoff(%d) without jitter = %s, want %s", attempt, got, want)
+		}
+	}
+	if got := exact.backoff(40); got != retryMaxDelay {
+		t.Fatalf("backoff(40) = %s, want the cap %s", got, retryMaxDelay)
+	}
+
+	// Full jitter: every wait lies in [0, delay), and they are not all the same.
+	jittered := &retryTransport{jitter: true, random: rand.Float64}
+	for attempt := 0; attempt < 8; attempt++ {
+		ceiling := exact.backoff(attempt)
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 100; i++ {
+			got := jittered.backoff(attempt)
+			if got < 0 || got >= ceiling {
+				t.Fatalf("backoff(%d) with jitter = %s, want within [0, %s)", attempt, got, ceiling)
+			}
+			seen[got] = true
+		}
+		if len(seen) < 2 {
+			t.Fatalf("backoff(%d) with jitter is not random: always %v", attempt, seen)
+		}
+	}
+}
+
+func TestWithRetries_RetriesTransientErrors(t *testing.T) {
+	defer func(base time.Duration) { retryBaseDelay = base }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	latest := "/repos/actions/checkout/releases/latest"
+
+	for _, tc := range []struct {
+		retries, flaky int
+		wantErr        bool
+	}{
+		{retries: 0, flaky: 1, wantErr: true},
+		{retries: 2, flaky: 2},
+		{retries: 2, flaky: 3, wantErr: true},
+	} {
+		client, fake := newTestClient(t, checkoutRoutes())
+		fake.flaky = map[string]int{latest: tc.flaky}
+		r := NewResolver(withRetries(client, tc.retries, true), ResolveOptions{})
+		info, err := r.resolveActionForPolicy(context.Background(), "actions", "checkout", "v4")
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("retries=%d, %d failure(s): err = %v, wantErr %t", tc.retries, tc.flaky, err, tc.wantErr)
+		}
+		if !tc.wantErr && info.SHA != "11bd71901bbe5b1630ceea73d27597364c9af683" {
+			t.Fatalf("retries=%d: SHA = %q", tc.retries, info.SHA)
+		}
+		if got, want := fake.count(latest), min(tc.flaky, tc.retries)+1; got != want {
+			t.Fatalf("retries=%d, %d failure(s): %d request(s), want %d", tc.retries, tc.flaky, got, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// largeWorkflow generates a workflow of jobs jobs, each checking out with actions/checkout@v4
+// and running a long script, some with the ref quoted or followed by a comment.
+func largeWorkflow(jobs int) string {
+	var b strings.Builder
+	b.WriteString("name: generated\non: push\njobs:\n")
+	script := strings.Repeat("echo generated step output; ", 20)
+	for i := 0; i < jobs; i++ {
+		fmt.Fprintf(&b, "  job-%d:\n    runs-on: ubuntu-latest\n    steps:\n", i)
+		switch i % 3 {
+		case 0:
+			b.WriteString("      - uses: actions/checkout@v4\n")
+		case 1:
+			b.WriteString("      - uses: \"actions/checkout@v4\" # keep\n")
+		default:
+			b.WriteString("      - Uses: actions/checkout@v4\n")
+		}
+		fmt.Fprintf(&b, "      - run: %s\n", script)
+	}
+	return b.String()
+}
+
+func TestRun_StreamsLargeFiles(t *testing.T) {
+	content := largeWorkflow(1000)
+	orig := streamThreshold
+	streamThreshold = 256 << 10
+	t.Cleanup(func() { streamThreshold = orig })
+	if int64(len(content)) <= streamThreshold {
+		t.Fatalf("generated %d bytes, not over the %d byte threshold", len(content), streamThreshold)
+	}
+	path := filepath.Join(t.TempDir(), "generated.yml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run reports changes without writing.
+	code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", path)
+	if code != 2 {
+		t.Fatalf("dry run: exit code = %d, want 2; stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "streamed line by line") {
+		t.Fatalf("file was not streamed:\n%.500s", stdout)
+	}
+	if got, _ := os.ReadFile(path); string(got) != content {
+		t.Fatalf("dry run modified the file")
+	}
+
+	code, _, stderr = runCLI(t, checkoutRoutes(), "", "--yes", path)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr: %.500s", code, stderr)
+	}
+	if !strings.Contains(stderr, "not Uses:") {
+		t.Fatalf("miscased uses: keys were not warned about")
+	}
+
+	// Streaming writes exactly what the in-memory path does.
+	occurrences := extractOccurrences(content)
+	infos := make([]ActionInfo, len(occurrences))
+	for i := range infos {
+		infos[i] = ActionInfo{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"}
+	}
+	want := updateContent(content, occurrences, infos, CommentStyle{})
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Fatalf("streamed output differs from the in-memory rewrite")
+	}
+
+	// Pinned already: nothing changes on a second run.
+	if code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", path); code != 0 || !strings.Contains(stdout, "streamed line by line") {
+		t.Fatalf("second run: exit code = %d, stderr: %.500s", code, stderr)
+	}
+
+	// A large file without actions is a no-op, as in memory.
+	runOnly := strings.ReplaceAll(strings.ReplaceAll(content, "uses:", "run:"), "Uses:", "run:")
+	if err := os.WriteFile(path, []byte(runOnly), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code, stdout, stderr := runCLI(t, checkoutRoutes(), "", "--dry-run", path); code != 0 || !strings.Contains(stdout, "No actions:") {
+		t.Fatalf("no actions: exit code = %d, stderr: %.500s", code, stderr)
+	}
+}
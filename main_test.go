@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestUpdateContent(t *testing.T) {
@@ -48,7 +53,7 @@ jobs:
 		},
 	}
 
-	result := updateContent(input, occurrences, actionInfos)
+	result := updateContent(input, occurrences, actionInfos, CommentStyle{})
 
 	expected := `name: Test Workflow
 on:
@@ -98,3 +103,337 @@ jobs:
 		}
 	}
 }
+
+func TestUpdateComments(t *testing.T) {
+	input := `steps:
+  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683
+  - uses: actions/setup-go@d35c59abb061a4a6fb18e82ac0862c26744d6ab5 # v5.4.0
+  - uses: actions/cache@5a3ec84eff668545956fd18022155c47e93e2684 # v4.2.3
+  - uses: actions/upload-artifact@v4
+`
+	occurrences := extractOccurrences(input)
+	actionInfos := []ActionInfo{
+		{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"},
+		{Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"},
+		{Owner: "actions", Repo: "cache", Version: "v4.2.3", SHA: "5a3ec84eff668545956fd18022155c47e93e2684"},
+		{Owner: "actions", Repo: "upload-artifact", Version: "v4.6.2", SHA: "ea165f8d65b6e75b540449e92b4886f43607fa02"},
+	}
+
+	result, added, corrected := updateComments(input, occurrences, actionInfos, CommentStyle{})
+
+	expected := `steps:
+  - uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2
+  - uses: actions/setup-go@d35c59abb061a4a6fb18e82ac0862c26744d6ab5 # v5.5.0
+  - uses: actions/cache@5a3ec84eff668545956fd18022155c47e93e2684 # v4.2.3
+  - uses: actions/upload-artifact@v4
+`
+	if result != expected {
+		t.Fatalf("updateComments() = %q, want %q", result, expected)
+	}
+	if added != 1 || corrected != 1 {
+		t.Fatalf("updateComments() added=%d corrected=%d, want 1 and 1", added, corrected)
+	}
+}
+
+func TestValidateYAML(t *testing.T) {
+	// A quoted uses: value whose closing quote is captured as part of the ref; rewriting it
+	// drops the quote and leaves an unterminated string.
+	input := `steps:
+  - uses: "actions/checkout@v4"
+---
+steps:
+  - uses: actions/setup-go@v5
+`
+	if err := validateYAML(input); err != nil {
+		t.Fatalf("validateYAML(input) unexpected error: %v", err)
+	}
+
+	occurrences := extractOccurrences(input)
+	actionInfos := []ActionInfo{
+		{Owner: `"actions`, Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"},
+		{Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"},
+	}
+	result := updateContent(input, occurrences, actionInfos, CommentStyle{})
+	if err := validateYAML(result); err == nil {
+		t.Fatalf("validateYAML() expected an error for broken output:\n%s", result)
+	}
+}
+
+func TestUpdateContent_AdjacentOccurrences(t *testing.T) {
+	checkout := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	setupGo := "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"
+	input := "steps:\n- uses: actions/checkout@v4\n- uses: actions/setup-go@v5\n- uses: actions/checkout@v4"
+	occurrences := extractOccurrences(input)
+	if len(occurrences) != 3 {
+		t.Fatalf("extractOccurrences() found %d occurrences, want 3", len(occurrences))
+	}
+	actionInfos := []ActionInfo{
+		{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: checkout},
+		{Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: setupGo},
+		{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: checkout},
+	}
+
+	want := "steps:\n- uses: actions/checkout@" + checkout + " # v4.2.2\n" +
+		"- uses: actions/setup-go@" + setupGo + " # v5.5.0\n" +
+		"- uses: actions/checkout@" + checkout + " # v4.2.2"
+	if got := updateContent(input, occurrences, actionInfos, CommentStyle{}); got != want {
+		t.Fatalf("updateContent() = %q, want %q", got, want)
+	}
+
+	// Spans that touch exactly (one ends where the next starts) are both rewritten.
+	content := "a@v1b@v2"
+	touching := []ActionOccurrence{
+		{ReplaceStart: 1, RefEnd: 4, ReplaceEnd: 4},
+		{ReplaceStart: 5, RefEnd: 8, ReplaceEnd: 8},
+		{ReplaceStart: 4, RefEnd: 5, ReplaceEnd: 5},
+	}
+	infos := []ActionInfo{{SHA: "x", Version: "1"}, {SHA: "y", Version: "2"}, {SHA: "z", Version: "3"}}
+	if got, want := updateContent(content, touching, infos, CommentStyle{}), "a@x # 1@z # 3@y # 2"; got != want {
+		t.Fatalf("updateContent() = %q, want %q", got, want)
+	}
+}
+
+func TestUpdateContent_OverlappingOccurrencesSkipped(t *testing.T) {
+	content := "- uses: actions/checkout@v4\n"
+	occ := extractOccurrences(content)[0]
+	overlapping := occ
+	overlapping.ReplaceStart++
+	outOfRange := occ
+	outOfRange.ReplaceEnd, outOfRange.RefEnd = len(content)+10, len(content)+5
+
+	occurrences := []ActionOccurrence{occ, overlapping, outOfRange}
+	actionInfos := []ActionInfo{
+		{Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"},
+		{Version: "v9.9.9", SHA: "0000000000000000000000000000000000000000"},
+		{Version: "v9.9.9", SHA: "0000000000000000000000000000000000000000"},
+	}
+
+	want := "- uses: actions/checkout@11bd71901bbe5b1630ceea73d27597364c9af683 # v4.2.2\n"
+	if got := updateContent(content, occurrences, actionInfos, CommentStyle{}); got != want {
+		t.Fatalf("updateContent() = %q, want %q", got, want)
+	}
+}
+
+func TestPrintGroupedPlannedChanges(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "planned", "grouped.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	golden, err := os.ReadFile(filepath.Join("testdata", "planned", "grouped.golden.txt"))
+	if err != nil {
+		t.Fatalf("read golden: %v", err)
+	}
+
+	checkout := ActionInfo{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"}
+	setupGo := ActionInfo{Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"}
+	occurrences := extractOccurrences(string(content))
+	actionInfos := []ActionInfo{checkout, setupGo, checkout, checkout, checkout, setupGo}
+
+	var buf bytes.Buffer
+	printGroupedPlannedChanges(&buf, occurrences, actionInfos, CommentStyle{})
+	got := strings.TrimPrefix(buf.String(), bold("Planned updates:\n")+"\n")
+	if got != string(golden) {
+		t.Fatalf("printGroupedPlannedChanges() =\n%s\nwant\n%s", got, golden)
+	}
+}
+
+func TestPrintCurrentPins(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "current", "pins.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	golden, err := os.ReadFile(filepath.Join("testdata", "current", "pins.golden.txt"))
+	if err != nil {
+		t.Fatalf("read golden: %v", err)
+	}
+
+	var buf bytes.Buffer
+	printCurrentPins(&buf, "pins.yaml", extractOccurrences(string(content)), CommentStyle{Prefix: "pinned:"})
+	if buf.String() != string(golden) {
+		t.Fatalf("printCurrentPins() =\n%s\nwant\n%s", buf.String(), golden)
+	}
+}
+
+func TestUpdateContent_Modes(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "mode", "input.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	infoFor := map[string]ActionInfo{
+		"actions/checkout":        {Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"},
+		"actions/setup-go":        {Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"},
+		"actions/cache":           {Owner: "actions", Repo: "cache", Version: "v4.2.3", SHA: "5a3ec84eff668545956fd18022155c47e93e2684"},
+		"actions/upload-artifact": {Owner: "actions", Repo: "upload-artifact", Version: "v4.6.2", SHA: "ea165f8d65b6e75b540449e92b4886f43607fa02"},
+	}
+	infos := func(occurrences []ActionOccurrence) []ActionInfo {
+		out := make([]ActionInfo, len(occurrences))
+		for i, occ := range occurrences {
+			out[i] = infoFor[occ.Action]
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		golden string
+		style  CommentStyle
+	}{
+		{"sha_first.golden.yaml", CommentStyle{}},
+		{"tag_first.golden.yaml", CommentStyle{TagFirst: true}},
+	} {
+		t.Run(tc.golden, func(t *testing.T) {
+			golden, err := os.ReadFile(filepath.Join("testdata", "mode", tc.golden))
+			if err != nil {
+				t.Fatalf("read golden: %v", err)
+			}
+			occurrences := extractOccurrences(string(content))
+			first := updateContent(string(content), occurrences, infos(occurrences), tc.style)
+			if first != string(golden) {
+				t.Fatalf("updateContent() =\n%s\nwant\n%s", first, golden)
+			}
+
+			// Round trip: the pinned output has nothing left to change
+			occurrences = extractOccurrences(first)
+			if second := updateContent(first, occurrences, infos(occurrences), tc.style); second != first {
+				t.Fatalf("second pass is not a no-op:\n%s", second)
+			}
+			if occs, _ := changedOnly(occurrences, infos(occurrences), tc.style); len(occs) != 0 {
+				t.Fatalf("second pass still plans changes for %+v", occs)
+			}
+		})
+	}
+}
+
+func TestTagFirstRef(t *testing.T) {
+	for _, tc := range []struct{ requested, version, want string }{
+		{"v4", "v4.2.2", "v4"},
+		{"v4.2", "v4.2.2", "v4.2"},
+		{"v4.2.2", "v4.2.2", "v4.2.2"},
+		{"main", "main", "main"},
+		{"v4", "v5.0.0", "v5.0.0"},
+		{"v4.1", "v4.10.0", "v4.10.0"},
+		{"5a3ec84eff668545956fd18022155c47e93e2684", "v4.2.3", "v4.2.3"},
+	} {
+		if got := tagFirstRef(tc.requested, tc.version); got != tc.want {
+			t.Errorf("tagFirstRef(%q, %q) = %q, want %q", tc.requested, tc.version, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeActionInfos(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "normalize", "mixed_refs.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	golden, err := os.ReadFile(filepath.Join("testdata", "normalize", "mixed_refs.golden.yaml"))
+	if err != nil {
+		t.Fatalf("read golden: %v", err)
+	}
+
+	// As resolved under the requested policy: each ref form resolves on its own.
+	latest := ActionInfo{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"}
+	older := ActionInfo{Owner: "actions", Repo: "checkout", Version: "v4.0.0", SHA: "3df4ab11eba7bda6032a0b82a6bb43b11571feac"}
+	setupGo := ActionInfo{Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"}
+	occurrences := extractOccurrences(string(content))
+	actionInfos := []ActionInfo{latest, setupGo, older, latest}
+
+	changes := normalizeActionInfos(occurrences, actionInfos)
+	want := []refNormalization{{Action: "actions/checkout", Line: 8, Column: 15, From: "v4.0.0", To: "v4.2.2"}}
+	if len(changes) != 1 || changes[0] != want[0] {
+		t.Fatalf("normalizeActionInfos() = %+v, want %+v", changes, want)
+	}
+	if got := updateContent(string(content), occurrences, actionInfos, CommentStyle{}); got != string(golden) {
+		t.Fatalf("updateContent() =\n%s\nwant\n%s", got, golden)
+	}
+}
+
+func TestAlignComments(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "align", "pinned.yaml"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	golden, err := os.ReadFile(filepath.Join("testdata", "align", "pinned.golden.yaml"))
+	if err != nil {
+		t.Fatalf("read golden: %v", err)
+	}
+
+	infos := []ActionInfo{
+		{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: "11bd71901bbe5b1630ceea73d27597364c9af683"},
+		{Owner: "actions", Repo: "setup-go", Version: "v5.5.0", SHA: "d35c59abb061a4a6fb18e82ac0862c26744d6ab5"},
+		{Owner: "actions", Repo: "upload-artifact", Version: "v4.6.2", SHA: "ea165f8d65b6e75b540449e92b4886f43607fa02"},
+		{Owner: "actions", Repo: "cache", Version: "v4.2.3", SHA: "5a3ec84eff668545956fd18022155c47e93e2684"},
+	}
+	first := alignComments(updateContent(string(content), extractOccurrences(string(content)), infos, CommentStyle{}))
+	if first != string(golden) {
+		t.Fatalf("first pass =\n%s\nwant\n%s", first, golden)
+	}
+	if second := alignComments(updateContent(first, extractOccurrences(first), infos, CommentStyle{})); second != first {
+		t.Fatalf("second pass changed content:\n%s", second)
+	}
+}
+
+func TestRunLimited_Cap(t *testing.T) {
+	const limit = 3
+	var mu sync.Mutex
+	running, peak, calls := 0, 0, 0
+	runLimited(20, limit, func(int) {
+		mu.Lock()
+		running++
+		calls++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	if calls != 20 {
+		t.Fatalf("fn called %d times, want 20", calls)
+	}
+	if peak != limit {
+		t.Fatalf("peak concurrency = %d, want %d", peak, limit)
+	}
+}
+
+func TestChangedOnly(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	occurrences := extractOccurrences("steps:\n  - uses: actions/checkout@v4\n  - uses: actions/checkout@" + sha + "\n  - uses: foo/missing@v1\n")
+	pinned := ActionInfo{Owner: "actions", Repo: "checkout", Version: "v4.2.2", SHA: sha}
+	infos := []ActionInfo{pinned, pinned, {Owner: "foo", Repo: "missing", Error: os.ErrNotExist}}
+
+	occs, changed := changedOnly(occurrences, infos, CommentStyle{})
+	if len(occs) != 1 || len(changed) != 1 || occs[0].Line != 2 || changed[0] != pinned {
+		t.Fatalf("changedOnly() = %+v, %+v; want only the occurrence on line 2", occs, changed)
+	}
+
+	var b bytes.Buffer
+	printResolvedActions(&b, occs, changed)
+	if want := "  actions/checkout: v4.2.2 -> " + sha + "\n"; b.String() != want {
+		t.Fatalf("printResolvedActions() = %q, want %q", b.String(), want)
+	}
+}
+
+func TestPromptConfirmation_Default(t *testing.T) {
+	cases := []struct {
+		input      string
+		defaultYes bool
+		want       bool
+	}{
+		{"\n", false, false},
+		{"\n", true, true},
+		{"  \n", true, true},
+		{"y\n", false, true},
+		{"n\n", true, false},
+		{"maybe\n", true, false},
+		// Closed input is never taken as the default.
+		{"", true, false},
+	}
+	for _, tc := range cases {
+		var out bytes.Buffer
+		if got := promptConfirmation(strings.NewReader(tc.input), &out, "Apply? ", tc.defaultYes); got != tc.want {
+			t.Errorf("promptConfirmation(%q, defaultYes=%v) = %v, want %v", tc.input, tc.defaultYes, got, tc.want)
+		}
+		if out.String() != "Apply? " {
+			t.Errorf("prompt = %q", out.String())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestGetRegistryToken(t *testing.T) {
+	t.Setenv("GH_TOKEN", "gh-token")
+	t.Setenv("PIN_REGISTRY_TOKEN", "")
+	if got := getRegistryToken(""); got != "" {
+		t.Fatalf("getRegistryToken(\"\") = %q, want empty (GitHub token must not be reused)", got)
+	}
+
+	t.Setenv("PIN_REGISTRY_TOKEN", "env-token")
+	if got := getRegistryToken(""); got != "env-token" {
+		t.Fatalf("getRegistryToken(\"\") = %q, want %q", got, "env-token")
+	}
+	if got := getRegistryToken("flag-token"); got != "flag-token" {
+		t.Fatalf("getRegistryToken(\"flag-token\") = %q, want %q", got, "flag-token")
+	}
+}
+
+func TestGetGitHubToken_StdinTakesPrecedence(t *testing.T) {
+	t.Setenv("GH_TOKEN", "env-token")
+	if got, err := getGitHubToken("stdin-token"); err != nil || got != "stdin-token" {
+		t.Fatalf("getGitHubToken(\"stdin-token\") = %q, %v; want stdin-token", got, err)
+	}
+	if got, err := getGitHubToken(""); err != nil || got != "env-token" {
+		t.Fatalf("getGitHubToken(\"\") = %q, %v; want env-token", got, err)
+	}
+}
+
+func TestReadTokenFromStdin(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("  ghp_abc123\r\ny\n"))
+	got, err := readTokenFromStdin(in)
+	if err != nil || got != "ghp_abc123" {
+		t.Fatalf("readTokenFromStdin() = %q, %v; want ghp_abc123", got, err)
+	}
+	if rest, _ := in.ReadString('\n'); rest != "y\n" {
+		t.Fatalf("remaining stdin = %q, want the next line untouched", rest)
+	}
+
+	if got, err := readTokenFromStdin(bufio.NewReader(strings.NewReader("no-newline"))); err != nil || got != "no-newline" {
+		t.Fatalf("readTokenFromStdin() = %q, %v; want no-newline", got, err)
+	}
+	if _, err := readTokenFromStdin(bufio.NewReader(strings.NewReader("\n"))); err == nil {
+		t.Fatalf("expected an error for an empty token")
+	}
+}
@@ -1,6 +1,13 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+)
 
 func TestPrettyRef(t *testing.T) {
 	cases := []struct {
@@ -172,6 +179,8 @@ func TestComputeLineCol(t *testing.T) {
 		{"mixed line endings", "line1\nline2\r\nline3", 13, 3, 1},
 		{"very long line", "hello world this is a very long line with many characters", 25, 1, 26},
 		{"multi-line with varying lengths", "short\na much longer line here\nend", 6, 2, 1},
+		{"leading BOM on first line", "\ufeffhello", 5, 1, 3},
+		{"leading BOM on second line", "\ufeffhi\nthere", 7, 2, 2},
 	}
 
 	for _, tc := range cases {
@@ -182,4 +191,205 @@ func TestComputeLineCol(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestIsDowngrade(t *testing.T) {
+	sha := "11bd71901bbe5b1630ceea73d27597364c9af683"
+	cases := []struct {
+		name     string
+		ref      string
+		comment  string
+		resolved string
+		want     bool
+	}{
+		{"comment newer than resolved", sha, "v4.2.2", "v3.6.0", true},
+		{"comment older than resolved", sha, "v4.2.2", "v4.3.0", false},
+		{"comment equal to resolved", sha, "v4.2.2", "v4.2.2", false},
+		{"exact tag ref newer", "v4.2.2", "", "v4.1.0", true},
+		{"moving major compares major only", "v4", "", "v4.0.0", false},
+		{"moving major lower", "v4", "", "v3.9.9", true},
+		{"resolved moving major vs full comment", "v4", "v4.2.2", "v4", false},
+		{"non-semver comment is ignored", sha, "pin to latest", "v1.0.0", false},
+		{"branch ref is ignored", "main", "", "v1.0.0", false},
+		{"non-semver resolved is ignored", sha, "v4.2.2", sha, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			occ := ActionOccurrence{Owner: "actions", Repo: "checkout", RequestedRef: tc.ref, Comment: tc.comment}
+			info := ActionInfo{Owner: "actions", Repo: "checkout", Version: tc.resolved, SHA: sha}
+			if got := isDowngrade(occ, info, CommentStyle{}); got != tc.want {
+				t.Errorf("isDowngrade(%q # %q → %q) = %v, want %v", tc.ref, tc.comment, tc.resolved, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseConcurrency(t *testing.T) {
+	cases := []struct {
+		in       string
+		wantN    int
+		wantAuto bool
+		wantErr  bool
+	}{
+		{"", 0, false, false},
+		{"auto", 0, true, false},
+		{" AUTO ", 0, true, false},
+		{"8", 8, false, false},
+		{"0", 0, false, true},
+		{"-2", 0, false, true},
+		{"many", 0, false, true},
+	}
+
+	for _, tc := range cases {
+		n, auto, err := parseConcurrency(tc.in)
+		if (err != nil) != tc.wantErr || n != tc.wantN || auto != tc.wantAuto {
+			t.Errorf("parseConcurrency(%q) = (%d, %v, %v), want (%d, %v, err=%v)", tc.in, n, auto, err, tc.wantN, tc.wantAuto, tc.wantErr)
+		}
+	}
+}
+
+func TestAutoConcurrency(t *testing.T) {
+	cases := []struct {
+		name       string
+		remaining  int
+		actions    int
+		untilReset time.Duration
+		wantN      int
+		wantPace   time.Duration
+	}{
+		{"plenty of budget", 5000, 10, time.Hour, 16, 0},
+		{"budget just covers run", 30, 10, time.Hour, 4, 0},
+		{"budget short", 15, 10, time.Hour, 1, 12 * time.Minute},
+		{"budget exhausted", 0, 10, 10 * time.Minute, 1, 10 * time.Minute},
+		{"reset in the past", 0, 10, -time.Minute, 1, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, pace := autoConcurrency(tc.remaining, tc.actions, tc.untilReset)
+			if n != tc.wantN || pace != tc.wantPace {
+				t.Errorf("autoConcurrency(%d, %d, %s) = (%d, %s), want (%d, %s)", tc.remaining, tc.actions, tc.untilReset, n, pace, tc.wantN, tc.wantPace)
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	cases := []struct {
+		action string
+		want   string
+	}{
+		{"actions/checkout", "PIN_actions_checkout"},
+		{"docker/setup-buildx-action", "PIN_docker_setup_buildx_action"},
+		{"aws-actions/configure-aws-credentials", "PIN_aws_actions_configure_aws_credentials"},
+		{"github/codeql-action/init", "PIN_github_codeql_action_init"},
+		{"my.org/some.repo", "PIN_my_org_some_repo"},
+		{"1password/load-secrets-action", "PIN_1password_load_secrets_action"},
+		{"Owner/Ünicode", "PIN_Owner__nicode"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.action, func(t *testing.T) {
+			if got := envName(tc.action); got != tc.want {
+				t.Errorf("envName(%q) = %q, want %q", tc.action, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsShortSHA(t *testing.T) {
+	cases := []struct {
+		name string
+		ref  string
+		want bool
+	}{
+		{"7 hex", "8ade135", true},
+		{"12 hex uppercase", "8ADE135A41BC", true},
+		{"39 hex", "8ade135a41bc03ea155e62e844d188df1ea1860", true},
+		{"6 hex", "8ade13", false},
+		{"full SHA", "8ade135a41bc03ea155e62e844d188df1ea18608", false},
+		{"non-hex", "8ade13g", false},
+		{"tag", "v4.2.2", false},
+		{"major", "v4", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isShortSHA(tc.ref); got != tc.want {
+				t.Errorf("isShortSHA(%q) = %v, want %v", tc.ref, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPrintRateLimitEstimate(t *testing.T) {
+	reset := github.Timestamp{Time: time.Now().Add(30 * time.Minute)}
+	cases := []struct {
+		name      string
+		remaining int
+		actions   int
+		wantWarn  bool
+	}{
+		{"fits", 100, 10, false},
+		{"exact fit", 30, 10, false},
+		{"short", 29, 10, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			printRateLimitEstimate(&out, &errOut, &github.Rate{Limit: 5000, Remaining: tc.remaining, Reset: reset}, tc.actions)
+			if !strings.Contains(out.String(), "Estimated: up to 30 request(s) for 10 distinct action(s)") {
+				t.Fatalf("missing estimate in output: %q", out.String())
+			}
+			if gotWarn := strings.Contains(errOut.String(), "--concurrency auto"); gotWarn != tc.wantWarn {
+				t.Fatalf("warning = %v, want %v (stderr %q)", gotWarn, tc.wantWarn, errOut.String())
+			}
+		})
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	codes := exitCodes{NoOp: 3, Changes: 4, Error: 5}
+	cases := []struct {
+		internal int
+		want     int
+	}{
+		{0, 3},
+		{2, 4},
+		{1, 5},
+	}
+	for _, tc := range cases {
+		if got := codes.apply(tc.internal); got != tc.want {
+			t.Errorf("apply(%d) = %d, want %d", tc.internal, got, tc.want)
+		}
+	}
+
+	if err := (exitCodes{NoOp: 0, Changes: 2, Error: 1}).validate(); err != nil {
+		t.Errorf("default codes: unexpected error: %v", err)
+	}
+	if err := (exitCodes{NoOp: 0, Changes: 0, Error: 1}).validate(); err != nil {
+		t.Errorf("changes mapped to success: unexpected error: %v", err)
+	}
+	if err := (exitCodes{NoOp: 1, Changes: 2, Error: 1}).validate(); err == nil {
+		t.Errorf("expected an error when errors and no-op share a code")
+	}
+	if err := (exitCodes{NoOp: 0, Changes: 200, Error: 1}).validate(); err == nil {
+		t.Errorf("expected an error for an out-of-range code")
+	}
+}
+
+func TestRepoOverrideFlag(t *testing.T) {
+	o := repoOverrideFlag{}
+	if err := o.Set("Actions/Checkout = acme/checkout-fork"); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+	if got := o["actions/checkout"]; got != "acme/checkout-fork" {
+		t.Fatalf("override = %q, want acme/checkout-fork", got)
+	}
+	for _, bad := range []string{"actions/checkout", "actions=acme/fork", "actions/checkout=acme", "actions/checkout@v4=acme/fork"} {
+		if err := o.Set(bad); err == nil {
+			t.Errorf("Set(%q) expected an error", bad)
+		}
+	}
+}